@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// largest request body the admin api reads
+const MAX_ADMIN_BODY = 1 << 20
+
+// crawl states reported by the admin api
+const CRAWL_RUNNING = "running"
+const CRAWL_PAUSED = "paused"
+const CRAWL_STOPPING = "stopping"
+
+// HostRate is the number of pages stored for a host and its rate since the crawl started
+type HostRate struct {
+	Host           string  `json:"host"`
+	Pages          int64   `json:"pages"`
+	PagesPerSecond float64 `json:"pages_per_second"`
+}
+
+/*
+CrawlStats is a snapshot of a running crawl.
+
+queued: jobs in the frontier and in the host queues of the scheduler
+in_flight: jobs being fetched and processed by the workers
+stop_reason: budget or request that is ending the crawl
+pages, bytes: stored pages and their size, including earlier runs of the crawl
+errors: jobs that failed after their retries or couldn't be saved
+peer_jobs_*: jobs exchanged with the other instances of a distributed crawl
+*/
+type CrawlStats struct {
+	CrawlID          string     `json:"crawl_id"`
+	State            string     `json:"state"`
+	StopReason       string     `json:"stop_reason,omitempty"`
+	StartedAt        time.Time  `json:"started_at"`
+	Uptime           string     `json:"uptime"`
+	Pages            int64      `json:"pages"`
+	Bytes            int64      `json:"bytes"`
+	Queued           int        `json:"queued"`
+	InFlight         int64      `json:"in_flight"`
+	Visited          int        `json:"visited"`
+	Errors           int64      `json:"errors"`
+	PagesPerSecond   float64    `json:"pages_per_second"`
+	Hosts            []HostRate `json:"hosts"`
+	PeerJobsSent     int64      `json:"peer_jobs_sent,omitempty"`
+	PeerJobsReceived int64      `json:"peer_jobs_received,omitempty"`
+	PeerJobsDropped  int64      `json:"peer_jobs_dropped,omitempty"`
+}
+
+// Stats returns the current numbers of the crawl, hosts with the most pages first
+func (c *Crawler) Stats() CrawlStats {
+	elapsed := time.Since(c.startedAt).Seconds()
+	rate := func(pages int64) float64 {
+		if elapsed <= 0 {
+			return 0
+		}
+		return float64(pages) / elapsed
+	}
+
+	c.mu.Lock()
+	state := CRAWL_RUNNING
+	if c.paused {
+		state = CRAWL_PAUSED
+	}
+	hosts := make([]HostRate, 0, len(c.hostPages))
+	for host, pages := range c.hostPages {
+		hosts = append(hosts, HostRate{Host: host, Pages: pages, PagesPerSecond: rate(pages)})
+	}
+	stopReason := c.stopReason
+	c.mu.Unlock()
+	if c.stopping.Load() {
+		state = CRAWL_STOPPING
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Pages != hosts[j].Pages {
+			return hosts[i].Pages > hosts[j].Pages
+		}
+		return hosts[i].Host < hosts[j].Host
+	})
+
+	pages := c.pages.Load()
+	stats := CrawlStats{
+		CrawlID:        c.config.CrawlID,
+		State:          state,
+		StopReason:     stopReason,
+		StartedAt:      c.startedAt,
+		Uptime:         time.Since(c.startedAt).Round(time.Second).String(),
+		Pages:          pages,
+		Bytes:          c.bytes.Load(),
+		Queued:         c.frontier.Len() + c.scheduler.Len(),
+		InFlight:       c.inFlight.Load(),
+		Visited:        c.visited.Count(),
+		Errors:         c.errors.Load(),
+		PagesPerSecond: rate(pages),
+		Hosts:          hosts,
+	}
+	if c.cluster != nil {
+		stats.PeerJobsSent = c.cluster.sent.Load()
+		stats.PeerJobsReceived = c.cluster.received.Load()
+		stats.PeerJobsDropped = c.cluster.dropped.Load()
+	}
+	return stats
+}
+
+/*
+AdminServer serves the admin api of a running crawl:
+
+	GET  /status   CrawlStats as JSON
+	POST /seeds    {"urls": [...]} adds start urls
+	POST /pause    workers stop taking jobs
+	POST /resume   workers continue
+	POST /stop     ends the crawl, pending jobs are kept to resume it later
+
+Requests must carry the cluster token when one is set, without it the config
+only allows a loopback address.
+*/
+type AdminServer struct {
+	crawler *Crawler
+	server  *http.Server
+}
+
+// NewAdminServer creates an admin server for the crawler listening on addr
+func NewAdminServer(addr string, crawler *Crawler) *AdminServer {
+	a := &AdminServer{crawler: crawler}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", a.status)
+	mux.HandleFunc("POST /seeds", a.seeds)
+	mux.HandleFunc("POST /pause", a.pause)
+	mux.HandleFunc("POST /resume", a.resume)
+	mux.HandleFunc("POST /stop", a.stop)
+	var handler http.Handler = mux
+	if crawler.config.ClusterToken != "" {
+		handler = authorize(crawler.config.ClusterToken, mux)
+	}
+	a.server = &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return a
+}
+
+// Start serves the api in the background
+func (a *AdminServer) Start() {
+	go func() {
+		fmt.Println("Admin api listening on", a.server.Addr)
+		err := a.server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Println("Error serving admin api", err)
+		}
+	}()
+}
+
+// Close stops the server, requests in progress get a few seconds to finish
+func (a *AdminServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return a.server.Shutdown(ctx)
+}
+
+func (a *AdminServer) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.crawler.Stats())
+}
+
+func (a *AdminServer) seeds(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		URLs []string `json:"urls"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_ADMIN_BODY)).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(request.URLs) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no urls given"))
+		return
+	}
+	added, err := a.crawler.AddSeeds(request.URLs)
+	if errors.Is(err, errNotRunning) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	fmt.Println("Admin api added", added, "seeds")
+	writeJSON(w, http.StatusOK, map[string]int{"added": added})
+}
+
+func (a *AdminServer) pause(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Admin api paused the crawl")
+	a.crawler.Pause()
+	writeJSON(w, http.StatusOK, map[string]string{"state": a.crawler.Stats().State})
+}
+
+func (a *AdminServer) resume(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Admin api resumed the crawl")
+	a.crawler.Resume()
+	writeJSON(w, http.StatusOK, map[string]string{"state": a.crawler.Stats().State})
+}
+
+func (a *AdminServer) stop(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Admin api stopped the crawl")
+	a.crawler.Stop()
+	writeJSON(w, http.StatusAccepted, map[string]string{"state": a.crawler.Stats().State})
+}
+
+// authorize rejects requests without the cluster token as a bearer token
+func authorize(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong cluster token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes the value as the JSON body of the response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		fmt.Println("Error writing response", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// endlessSite serves pages that each link to the next one, so a crawl of it runs until it is stopped
+func endlessSite() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/page/%d", &n); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head><title>Page %d</title></head><body><div id="mw-content-text"><p>page number %d</p><a href="/page/%d">next</a></div></body></html>`, n, n, n+1)
+	}))
+}
+
+// testSiteCrawler returns a crawler of the site that hasn't started, configure changes its config
+func testSiteCrawler(t *testing.T, site string, configure func(config *Config)) *Crawler {
+	config := NewConfig()
+	config.StartLinks = []string{site + "/page/0"}
+	config.MaxDepth = 1000
+	config.HostDelay = 10 * time.Millisecond
+	config.NumWorkers = 2
+	config.Scope.AllowedHosts = []string{"127.0.0.1"}
+	config.Scope.Schemes = []string{"http"}
+	config.Scope.Include = nil
+	if configure != nil {
+		configure(config)
+	}
+	scope, err := NewScope(config.Scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	storage, err := NewFilesystemStorage(dir, config.PagesDir, config.MetadataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := NewFileLinkStore(dir, config.CrawlID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { links.Close() })
+	return NewCrawler(storage, config, openTestState(t), scope, links)
+}
+
+// adminRequest sends a request to the handler of the admin server and decodes the JSON answer
+func adminRequest(t *testing.T, handler http.Handler, method string, path string, body string, auth string) (int, map[string]any) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	answer := map[string]any{}
+	if rec.Header().Get("Content-Type") == "application/json" {
+		err := json.Unmarshal(rec.Body.Bytes(), &answer)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code, answer
+}
+
+// waitFor polls until done returns true or a few seconds passed
+func waitFor(t *testing.T, what string, done func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAdminServer(t *testing.T) {
+	site := endlessSite()
+	defer site.Close()
+	crawler := testSiteCrawler(t, site.URL, nil)
+	handler := NewAdminServer("127.0.0.1:0", crawler).server.Handler
+
+	// before the crawl runs there is nothing to add seeds to
+	status, answer := adminRequest(t, handler, http.MethodPost, "/seeds", `{"urls": ["`+site.URL+`/page/100"]}`, "")
+	if status != http.StatusConflict {
+		t.Errorf("seeds before the crawl: status %d %v, want %d", status, answer, http.StatusConflict)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- crawler.Start(context.Background())
+	}()
+	waitFor(t, "the first pages", func() bool { return crawler.Stats().Pages >= 2 })
+
+	status, answer = adminRequest(t, handler, http.MethodGet, "/status", "", "")
+	if status != http.StatusOK || answer["state"] != CRAWL_RUNNING || answer["crawl_id"] != crawler.config.CrawlID {
+		t.Errorf("status: %d %v", status, answer)
+	}
+	for _, field := range []string{"started_at", "uptime", "pages", "bytes", "queued", "in_flight", "visited", "errors", "pages_per_second"} {
+		if _, ok := answer[field]; !ok {
+			t.Errorf("status has no %s: %v", field, answer)
+		}
+	}
+	if pages, _ := answer["pages"].(float64); pages < 2 {
+		t.Errorf("status shows %v pages, want at least 2", answer["pages"])
+	}
+	hosts, _ := answer["hosts"].([]any)
+	if len(hosts) != 1 || hosts[0].(map[string]any)["host"] != strings.TrimPrefix(site.URL, "http://") {
+		t.Errorf("status hosts %v, want the site", answer["hosts"])
+	}
+	if _, ok := answer["stop_reason"]; ok {
+		t.Errorf("running crawl has a stop reason: %v", answer)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		// field of the answer and its value, empty to skip the check
+		field string
+		value any
+	}{
+		{"pause", http.MethodPost, "/pause", "", http.StatusOK, "state", CRAWL_PAUSED},
+		{"status while paused", http.MethodGet, "/status", "", http.StatusOK, "state", CRAWL_PAUSED},
+		{"resume", http.MethodPost, "/resume", "", http.StatusOK, "state", CRAWL_RUNNING},
+		{"seed", http.MethodPost, "/seeds", `{"urls": ["` + site.URL + `/page/1000"]}`, http.StatusOK, "added", float64(1)},
+		{"visited seed", http.MethodPost, "/seeds", `{"urls": ["` + site.URL + `/page/0"]}`, http.StatusOK, "added", float64(0)},
+		{"seed out of scope", http.MethodPost, "/seeds", `{"urls": ["https://example.com/"]}`, http.StatusBadRequest, "", nil},
+		{"no seeds", http.MethodPost, "/seeds", `{"urls": []}`, http.StatusBadRequest, "error", "no urls given"},
+		{"invalid json", http.MethodPost, "/seeds", `{"urls": `, http.StatusBadRequest, "", nil},
+		{"wrong method", http.MethodGet, "/stop", "", http.StatusMethodNotAllowed, "", nil},
+		{"unknown path", http.MethodGet, "/jobs", "", http.StatusNotFound, "", nil},
+	}
+	for _, test := range tests {
+		status, answer := adminRequest(t, handler, test.method, test.path, test.body, "")
+		if status != test.status || (test.field != "" && answer[test.field] != test.value) {
+			t.Errorf("%s: status %d %v, want %d with %s %v", test.name, status, answer, test.status, test.field, test.value)
+		}
+	}
+	// the added seed is crawled
+	waitFor(t, "the added seed", func() bool { return crawler.visited.IsVisited(site.URL + "/page/1000") })
+
+	status, answer = adminRequest(t, handler, http.MethodPost, "/stop", "", "")
+	if status != http.StatusAccepted || answer["state"] != CRAWL_STOPPING {
+		t.Errorf("stop: status %d %v", status, answer)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawl didn't stop")
+	}
+	_, answer = adminRequest(t, handler, http.MethodGet, "/status", "", "")
+	if answer["state"] != CRAWL_STOPPING || answer["stop_reason"] != STOP_REQUESTED {
+		t.Errorf("status after stop: %v", answer)
+	}
+	// a stopped crawl can't take seeds
+	status, _ = adminRequest(t, handler, http.MethodPost, "/seeds", `{"urls": ["`+site.URL+`/page/2000"]}`, "")
+	if status != http.StatusConflict {
+		t.Errorf("seeds after stop: status %d, want %d", status, http.StatusConflict)
+	}
+}
+
+func TestAdminServerPauseHoldsWorkers(t *testing.T) {
+	site := endlessSite()
+	defer site.Close()
+	crawler := testSiteCrawler(t, site.URL, nil)
+	handler := NewAdminServer("127.0.0.1:0", crawler).server.Handler
+	done := make(chan error)
+	go func() {
+		done <- crawler.Start(context.Background())
+	}()
+	waitFor(t, "the first page", func() bool { return crawler.Stats().Pages >= 1 })
+
+	adminRequest(t, handler, http.MethodPost, "/pause", "", "")
+	// workers finish the page they are on, a worker waiting for the host delay gets one more
+	time.Sleep(100 * time.Millisecond)
+	waitFor(t, "the workers", func() bool { return crawler.Stats().InFlight == 0 })
+	pages := crawler.Stats().Pages
+	time.Sleep(100 * time.Millisecond)
+	if crawler.Stats().Pages != pages {
+		t.Errorf("%d pages stored while paused", crawler.Stats().Pages-pages)
+	}
+
+	// stop wakes paused workers
+	adminRequest(t, handler, http.MethodPost, "/stop", "", "")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("paused crawl didn't stop")
+	}
+}
+
+func TestAdminServerAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		auth   string
+		status int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"token missing", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"token without bearer", "secret", "secret", http.StatusUnauthorized},
+		{"token", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		crawler := testSiteCrawler(t, "http://127.0.0.1:1", func(config *Config) { config.ClusterToken = test.token })
+		handler := NewAdminServer("127.0.0.1:0", crawler).server.Handler
+		for _, path := range []string{"/status", "/stop"} {
+			method := http.MethodGet
+			want := test.status
+			if path == "/stop" {
+				method = http.MethodPost
+				if want == http.StatusOK {
+					want = http.StatusAccepted
+				}
+			}
+			status, _ := adminRequest(t, handler, method, path, "", test.auth)
+			if status != want {
+				t.Errorf("%s: %s %s = %d, want %d", test.name, method, path, status, want)
+			}
+		}
+		// only an authorized stop stops the crawl
+		if crawler.stopping.Load() != (test.status == http.StatusOK) {
+			t.Errorf("%s: stopping = %v", test.name, crawler.stopping.Load())
+		}
+	}
+}
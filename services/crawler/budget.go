@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// what ended a crawl, saved in its checkpoint
+const STOP_FINISHED = "finished"
+const STOP_REQUESTED = "stopped"
+const STOP_SHUTDOWN = "shutdown"
+const STOP_MAX_PAGES = "max_pages"
+const STOP_MAX_BYTES = "max_bytes"
+const STOP_MAX_DURATION = "max_duration"
+
+// no jobs were left but some were skipped by the per host or per seed budgets
+const STOP_PAGE_BUDGETS = "page_budgets"
+
+// no jobs were left but the frontier couldn't read some spilled jobs back from disk
+const STOP_LOST_JOBS = "lost_jobs"
+
+/*
+Budgets limits the pages stored per host and per seed subtree, a job belongs to the subtree
+of the seed it was discovered from. A host or seed over its budget doesn't end the crawl,
+its remaining jobs are skipped. A job reserves its page before it is fetched, so the workers
+fetching at the same time can't store more pages than the budget allows. The pages of the
+whole crawl are reserved the same way, the crawl stops once they are stored.
+
+hosts, seeds: stored pages per host and per seed, across all runs of the crawl
+reserved: jobs being fetched by url, counted in reservedHosts and reservedSeeds
+reported: hosts and seeds whose exhausted budget was already printed
+skipped: jobs Reserve stopped, they stay pending for a run with a larger budget
+pages: stored pages of the crawl, across all runs, counted against maxPages with the reservations
+*/
+type Budgets struct {
+	maxPerHost    int
+	maxPerSeed    int
+	mu            sync.Mutex
+	hosts         map[string]int
+	seeds         map[string]int
+	reserved      map[string]Job
+	reservedHosts map[string]int
+	reservedSeeds map[string]int
+	reported      map[string]bool
+	skipped       int
+
+	maxPages int
+	pages    int
+}
+
+// NewBudgets creates the page budgets of the config, 0 means no limit
+func NewBudgets(config *Config) *Budgets {
+	return &Budgets{
+		maxPages:      config.MaxPages,
+		maxPerHost:    config.MaxPagesPerHost,
+		maxPerSeed:    config.MaxPagesPerSeed,
+		hosts:         make(map[string]int),
+		seeds:         make(map[string]int),
+		reserved:      make(map[string]Job),
+		reservedHosts: make(map[string]int),
+		reservedSeeds: make(map[string]int),
+		reported:      make(map[string]bool),
+	}
+}
+
+// Load sets the counts saved by earlier runs
+func (b *Budgets) Load(pages int, hosts map[string]int, seeds map[string]int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pages = pages
+	b.hosts = hosts
+	b.seeds = seeds
+}
+
+// Add counts a stored page of the job for the host it was stored under and the seed of the job,
+// in place of the job's reservation
+func (b *Budgets) Add(job Job, host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.release(job)
+	b.pages++
+	b.hosts[host]++
+	if job.Seed != "" {
+		b.seeds[job.Seed]++
+	}
+}
+
+// Allows reports whether the host and the seed of the job have budget left, not counting reservations
+func (b *Budgets) Allows(job Job) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.allows(job, false, false)
+}
+
+// Reserve reserves a page of the crawl, the host and the seed of the job for its fetch, it returns
+// false and prints the host or seed the first time its budget stops a job when the stored and
+// reserved pages use it up. The reservation is held until Add or Release.
+func (b *Budgets) Reserve(job Job) bool {
+	if b.maxPages == 0 && b.maxPerHost == 0 && b.maxPerSeed == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.allows(job, true, true) {
+		b.skipped++
+		return false
+	}
+	if _, ok := b.reserved[job.URL]; ok {
+		return true
+	}
+	// the last pages of the crawl are being fetched, the job is kept for a run with a larger budget
+	if b.maxPages > 0 && b.pages+len(b.reserved) >= b.maxPages {
+		b.skipped++
+		return false
+	}
+	b.reserved[job.URL] = job
+	b.reservedHosts[hostOf(job.URL)]++
+	if job.Seed != "" {
+		b.reservedSeeds[job.Seed]++
+	}
+	return true
+}
+
+// Release gives back the reservation of a job that didn't store a page
+func (b *Budgets) Release(job Job) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.release(job)
+}
+
+// Skipped returns the number of jobs Reserve stopped in this run
+func (b *Budgets) Skipped() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.skipped
+}
+
+func (b *Budgets) release(job Job) {
+	reserved, ok := b.reserved[job.URL]
+	if !ok {
+		return
+	}
+	delete(b.reserved, job.URL)
+	host := hostOf(reserved.URL)
+	if b.reservedHosts[host]--; b.reservedHosts[host] == 0 {
+		delete(b.reservedHosts, host)
+	}
+	if reserved.Seed != "" {
+		if b.reservedSeeds[reserved.Seed]--; b.reservedSeeds[reserved.Seed] == 0 {
+			delete(b.reservedSeeds, reserved.Seed)
+		}
+	}
+}
+
+func (b *Budgets) allows(job Job, reserved bool, report bool) bool {
+	if b.maxPerHost > 0 {
+		host := hostOf(job.URL)
+		pages := b.hosts[host]
+		if reserved {
+			pages += b.reservedHosts[host]
+		}
+		if pages >= b.maxPerHost {
+			if report && !b.reported["host "+host] {
+				b.reported["host "+host] = true
+				fmt.Println("Page budget of host", host, "reached, skipping its other pages")
+			}
+			return false
+		}
+	}
+	seedPages := b.seeds[job.Seed]
+	if reserved {
+		seedPages += b.reservedSeeds[job.Seed]
+	}
+	if b.maxPerSeed > 0 && job.Seed != "" && seedPages >= b.maxPerSeed {
+		if report && !b.reported["seed "+job.Seed] {
+			b.reported["seed "+job.Seed] = true
+			fmt.Println("Page budget of seed", job.Seed, "reached, skipping its other pages")
+		}
+		return false
+	}
+	return true
+}
+
+// reserveBytes counts the size of a page against MaxBytes before it is stored,
+// it returns false when the page doesn't fit
+func (c *Crawler) reserveBytes(size int) bool {
+	if c.bytes.Add(int64(size)) > c.config.MaxBytes && c.config.MaxBytes > 0 {
+		c.bytes.Add(-int64(size))
+		return false
+	}
+	return true
+}
+
+// budgetReached returns the budget of the whole crawl that was used up, empty if none was
+func (c *Crawler) budgetReached() string {
+	if c.config.MaxPages > 0 && c.pages.Load() >= int64(c.config.MaxPages) {
+		return STOP_MAX_PAGES
+	}
+	if c.config.MaxBytes > 0 && c.bytes.Load() >= c.config.MaxBytes {
+		return STOP_MAX_BYTES
+	}
+	if c.config.MaxDuration > 0 && time.Since(c.startedAt) >= c.config.MaxDuration {
+		return STOP_MAX_DURATION
+	}
+	return ""
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBudgetsReserve(t *testing.T) {
+	type step struct {
+		action string // reserve, add or release
+		url    string
+		seed   string
+		want   bool
+	}
+	tests := []struct {
+		name       string
+		maxPerHost int
+		maxPerSeed int
+		steps      []step
+	}{
+		{"no limits", 0, 0, []step{
+			{"reserve", "https://a.com/1", "", true},
+			{"add", "https://a.com/1", "", true},
+			{"reserve", "https://a.com/2", "", true},
+		}},
+		{"reservations count for the host", 2, 0, []step{
+			{"reserve", "https://a.com/1", "", true},
+			{"reserve", "https://a.com/2", "", true},
+			{"reserve", "https://a.com/3", "", false},
+			{"reserve", "https://b.com/1", "", true},
+		}},
+		{"a released reservation frees the budget", 2, 0, []step{
+			{"reserve", "https://a.com/1", "", true},
+			{"reserve", "https://a.com/2", "", true},
+			{"release", "https://a.com/1", "", true},
+			{"reserve", "https://a.com/3", "", true},
+		}},
+		{"a stored page keeps it", 2, 0, []step{
+			{"reserve", "https://a.com/1", "", true},
+			{"add", "https://a.com/1", "", true},
+			{"release", "https://a.com/1", "", true},
+			{"reserve", "https://a.com/2", "", true},
+			{"reserve", "https://a.com/3", "", false},
+		}},
+		{"seed budget across hosts", 0, 2, []step{
+			{"reserve", "https://a.com/1", "s", true},
+			{"reserve", "https://b.com/1", "s", true},
+			{"reserve", "https://c.com/1", "s", false},
+			{"reserve", "https://c.com/1", "other", true},
+			{"reserve", "https://c.com/2", "", true},
+		}},
+	}
+	for _, test := range tests {
+		budgets := NewBudgets(&Config{MaxPagesPerHost: test.maxPerHost, MaxPagesPerSeed: test.maxPerSeed})
+		for i, step := range test.steps {
+			job := Job{URL: step.url, Seed: step.seed}
+			switch step.action {
+			case "reserve":
+				if got := budgets.Reserve(job); got != step.want {
+					t.Errorf("%s: step %d: Reserve(%s) = %v, want %v", test.name, i, step.url, got, step.want)
+				}
+			case "add":
+				budgets.Add(job, hostOf(job.URL))
+			case "release":
+				budgets.Release(job)
+			}
+		}
+	}
+}
+
+func TestBudgetsConcurrentWorkers(t *testing.T) {
+	budgets := NewBudgets(&Config{MaxPagesPerHost: 5})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stored := 0
+	for worker := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 20 {
+				job := Job{URL: fmt.Sprintf("https://a.com/%d/%d", worker, i)}
+				if !budgets.Reserve(job) {
+					continue
+				}
+				// every other fetch fails and gives its reservation back
+				if i%2 == 0 {
+					budgets.Add(job, "a.com")
+					mu.Lock()
+					stored++
+					mu.Unlock()
+				}
+				budgets.Release(job)
+			}
+		}()
+	}
+	wg.Wait()
+	if stored != 5 {
+		t.Errorf("stored %d pages, want the budget of 5", stored)
+	}
+}
+
+func TestBudgetsReserveMaxPages(t *testing.T) {
+	budgets := NewBudgets(&Config{MaxPages: 3})
+	budgets.Load(1, map[string]int{"a.com": 1}, map[string]int{})
+	steps := []struct {
+		action string // reserve, add or release
+		url    string
+		want   bool
+	}{
+		// a page stored by an earlier run counts
+		{"reserve", "https://a.com/1", true},
+		{"reserve", "https://b.com/1", true},
+		{"reserve", "https://c.com/1", false},
+		// a job reserved twice keeps its reservation
+		{"reserve", "https://a.com/1", true},
+		{"release", "https://a.com/1", true},
+		{"reserve", "https://c.com/1", true},
+		{"add", "https://c.com/1", true},
+		{"add", "https://b.com/1", true},
+		{"reserve", "https://d.com/1", false},
+	}
+	for i, step := range steps {
+		job := Job{URL: step.url}
+		switch step.action {
+		case "reserve":
+			if got := budgets.Reserve(job); got != step.want {
+				t.Errorf("step %d: Reserve(%s) = %v, want %v", i, step.url, got, step.want)
+			}
+		case "add":
+			budgets.Add(job, hostOf(job.URL))
+		case "release":
+			budgets.Release(job)
+		}
+	}
+	if budgets.Skipped() != 2 {
+		t.Errorf("%d jobs skipped, want 2", budgets.Skipped())
+	}
+}
+
+func TestCrawlBudgets(t *testing.T) {
+	// every page links to four more, so the workers always have pages to fetch
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/page/%d", &n); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head><title>Page %d</title></head><body><div id="mw-content-text"><p>page number %d %s</p>`, n, n, strings.Repeat("x", n%7*100))
+		for i := 1; i <= 4; i++ {
+			fmt.Fprintf(w, `<a href="/page/%d">%d</a>`, 4*n+i, i)
+		}
+		fmt.Fprint(w, `</div></body></html>`)
+	}))
+	defer site.Close()
+
+	tests := []struct {
+		name     string
+		maxPages int
+		maxBytes int64
+		reason   string
+	}{
+		{"max pages", 7, 0, STOP_MAX_PAGES},
+		{"max bytes", 0, 3000, STOP_MAX_BYTES},
+	}
+	for _, test := range tests {
+		crawler := testSiteCrawler(t, site.URL, func(config *Config) {
+			config.NumWorkers = 8
+			config.HostDelay = 0
+			config.MaxPerHost = 8
+			config.MaxPages = test.maxPages
+			config.MaxBytes = test.maxBytes
+		})
+		err := crawler.Start(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs, err := crawler.storage.ListMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytes := int64(0)
+		for _, doc := range docs {
+			bytes += int64(doc.ContentLength)
+		}
+		if test.maxPages > 0 && len(docs) != test.maxPages {
+			t.Errorf("%s: stored %d pages, want the budget of %d", test.name, len(docs), test.maxPages)
+		}
+		if test.maxBytes > 0 && (bytes > test.maxBytes || bytes < test.maxBytes/2) {
+			t.Errorf("%s: stored %d bytes, want at most the budget of %d", test.name, bytes, test.maxBytes)
+		}
+		if crawler.Stats().Pages != int64(len(docs)) || crawler.Stats().Bytes != bytes {
+			t.Errorf("%s: counted %d pages and %d bytes, stored %d and %d", test.name, crawler.Stats().Pages, crawler.Stats().Bytes, len(docs), bytes)
+		}
+		if crawler.checkpoint.StopReason != test.reason {
+			t.Errorf("%s: stopped by %q, want %q", test.name, crawler.checkpoint.StopReason, test.reason)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// query parameters that point to the same page, they are removed from urls
+var STRIPPED_PARAMS = map[string]bool{
+	"oldid":        true,
+	"curid":        true,
+	"diff":         true,
+	"printable":    true,
+	"mobileaction": true,
+	"wprov":        true,
+	"fbclid":       true,
+	"gclid":        true,
+}
+
+// Canonicalize returns the canonical form of an absolute url,
+// two urls of the same page should have the same canonical form
+func Canonicalize(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+	if !u.IsAbs() {
+		return "", fmt.Errorf("%s is not an absolute url", rawURL)
+	}
+	return canonicalize(u).String(), nil
+}
+
+// resolve makes the href absolute against the base and canonicalizes it
+func resolve(base *url.URL, href string) (*url.URL, error) {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+	u := ref
+	if base != nil {
+		u = base.ResolveReference(ref)
+	}
+	if !u.IsAbs() {
+		return nil, fmt.Errorf("%s is not an absolute url", u)
+	}
+	return canonicalize(u), nil
+}
+
+// canonicalize lowercases the scheme and host, drops default ports, fragments
+// and tracking or revision parameters, and sorts the remaining query
+func canonicalize(u *url.URL) *url.URL {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+	c.Host = strings.ToLower(c.Host)
+	if (c.Scheme == "http" && c.Port() == "80") || (c.Scheme == "https" && c.Port() == "443") {
+		// keeps the brackets of an IPv6 host
+		c.Host = strings.TrimSuffix(c.Host, ":"+c.Port())
+	}
+	c.Fragment = ""
+	c.RawFragment = ""
+	if c.Path == "" && c.Host != "" {
+		c.Path = "/"
+	}
+
+	if c.RawQuery != "" {
+		query := c.Query()
+		for key := range query {
+			if STRIPPED_PARAMS[strings.ToLower(key)] || strings.HasPrefix(strings.ToLower(key), "utm_") {
+				query.Del(key)
+			}
+		}
+		// Encode sorts by key
+		c.RawQuery = query.Encode()
+	}
+	c.ForceQuery = false
+	return &c
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string // empty when the url is rejected
+	}{
+		{"https://en.wikipedia.org/wiki/Physics", "https://en.wikipedia.org/wiki/Physics"},
+		{"  HTTPS://EN.Wikipedia.ORG/wiki/Physics  ", "https://en.wikipedia.org/wiki/Physics"},
+		{"https://en.wikipedia.org:443/wiki/Physics", "https://en.wikipedia.org/wiki/Physics"},
+		{"http://example.org:80", "http://example.org/"},
+		{"http://example.org:8080/a", "http://example.org:8080/a"},
+		{"http://[::1]:80/a", "http://[::1]/a"},
+		{"https://example.org/a#section", "https://example.org/a"},
+		{"https://example.org/a?", "https://example.org/a"},
+		{"https://example.org/a?b=2&a=1", "https://example.org/a?a=1&b=2"},
+		{"https://en.wikipedia.org/w/index.php?title=Physics&oldid=1&printable=yes", "https://en.wikipedia.org/w/index.php?title=Physics"},
+		{"https://example.org/a?utm_source=x&UTM_medium=y&fbclid=z&id=3", "https://example.org/a?id=3"},
+		// the path keeps its case
+		{"https://example.org/Wiki/A", "https://example.org/Wiki/A"},
+		{"/wiki/Physics", ""},
+		{"https://exa mple.org/", ""},
+	}
+	for _, test := range tests {
+		got, err := Canonicalize(test.url)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("Canonicalize(%q) = %q, want an error", test.url, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("Canonicalize(%q) = %q, %v, want %q", test.url, got, err, test.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	base, _ := url.Parse("https://en.wikipedia.org/wiki/Physics?action=view")
+	tests := []struct {
+		base *url.URL
+		href string
+		want string
+	}{
+		{base, "Energy", "https://en.wikipedia.org/wiki/Energy"},
+		{base, "../w/index.php?oldid=5&title=Energy", "https://en.wikipedia.org/w/index.php?title=Energy"},
+		{base, "//upload.wikimedia.org/a.jpg", "https://upload.wikimedia.org/a.jpg"},
+		{base, "?action=edit", "https://en.wikipedia.org/wiki/Physics?action=edit"},
+		{nil, "https://Example.org", "https://example.org/"},
+		{nil, "/wiki/Energy", ""},
+	}
+	for _, test := range tests {
+		u, err := resolve(test.base, test.href)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("resolve(%q) = %q, want an error", test.href, u)
+			}
+			continue
+		}
+		if err != nil || u.String() != test.want {
+			t.Errorf("resolve(%q) = %v, %v, want %q", test.href, u, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// points of every instance on the hash ring, more points spread the hosts more evenly
+const RING_REPLICAS = 100
+
+// jobs sent to a peer in one request
+const MAX_PEER_BATCH = 500
+
+// how often queued jobs are sent to the peers and the cluster is checked for the end of the crawl
+const CLUSTER_INTERVAL = 500 * time.Millisecond
+
+// a peer that doesn't answer is retried with a growing delay, its jobs are dropped after MAX_PEER_ATTEMPTS
+// and stay pending for the next run
+const MAX_PEER_BACKOFF = 30 * time.Second
+const MAX_PEER_ATTEMPTS = 10
+
+// largest request body the peer api reads
+const MAX_PEER_BODY = 32 << 20
+
+/*
+Ring assigns hosts to the instances of a crawl with consistent hashing.
+Adding or removing an instance only moves the hosts next to its points.
+
+points: sorted hashes of the points of all instances
+nodes: instance of every point
+*/
+type Ring struct {
+	points []uint64
+	nodes  map[uint64]string
+}
+
+// NewRing places RING_REPLICAS points of every node on the ring
+func NewRing(nodes []string) *Ring {
+	r := &Ring{nodes: make(map[uint64]string)}
+	for _, node := range nodes {
+		for i := 0; i < RING_REPLICAS; i++ {
+			point := ringHash(node + "#" + strconv.Itoa(i))
+			r.points = append(r.points, point)
+			r.nodes[point] = node
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the node crawling the host, the one with the next point clockwise
+func (r *Ring) Owner(host string) string {
+	h := ringHash(host)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.nodes[r.points[i]]
+}
+
+// ringHash spreads similar keys like "host:8001" and "host:8002" over the whole ring
+func ringHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// PeerState is what an instance reports to its peers when they look for the end of the crawl
+type PeerState struct {
+	Idle     bool  `json:"idle"`
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+}
+
+// PeerJobs is the body of a request sending jobs to the instance that owns them
+type PeerJobs struct {
+	From string `json:"from"`
+	Jobs []Job  `json:"jobs"`
+}
+
+// PeerAccepted is the answer to PeerJobs, jobs already visited or of hosts the instance doesn't own aren't accepted
+type PeerAccepted struct {
+	Accepted int64 `json:"accepted"`
+}
+
+/*
+Cluster shares a crawl between several crawler instances. Every host belongs to one instance,
+which fetches its pages and keeps them in its visited set, so urls are deduplicated across the
+cluster without sharing state. Links to hosts of other instances are queued and sent to their owner.
+
+The crawl is over when every instance is idle and the jobs sent by all instances match the jobs
+they received, twice in a row with the same counts. The first instance to see that tells the others.
+
+outbox: jobs waiting to be sent, by peer
+token: shared secret sent with every request to a peer
+sending: jobs in a request that wasn't answered yet
+sent: jobs accepted by peers
+received: jobs accepted from peers
+last: states of all instances at the previous check, nil when it didn't find them idle
+delivered: called with the jobs a peer answered, they are pending until then
+*/
+type Cluster struct {
+	node     string
+	peers    []string
+	ring     *Ring
+	token    string
+	client   *http.Client
+	mu       sync.Mutex
+	outbox   map[string]*peerQueue
+	sending  int
+	sent     atomic.Int64
+	received atomic.Int64
+	dropped  atomic.Int64
+	last     []PeerState
+
+	delivered func(jobs []Job)
+}
+
+/*
+jobs: jobs waiting to be sent to the peer
+failures: requests that failed in a row
+retryAt: earliest time of the next request after a failure
+*/
+type peerQueue struct {
+	jobs     []Job
+	failures int
+	retryAt  time.Time
+}
+
+// NewCluster creates the cluster of the node and its peers from the config
+func NewCluster(config *Config) *Cluster {
+	c := &Cluster{
+		node:   config.Node,
+		peers:  config.Peers,
+		ring:   NewRing(config.Peers),
+		token:  config.ClusterToken,
+		client: &http.Client{Timeout: 10 * time.Second},
+		outbox: make(map[string]*peerQueue),
+	}
+	for _, peer := range config.Peers {
+		if peer != config.Node {
+			c.outbox[peer] = &peerQueue{}
+		}
+	}
+	return c
+}
+
+// Owns reports whether this instance crawls the url
+func (c *Cluster) Owns(url string) bool {
+	return c.ring.Owner(hostOf(url)) == c.node
+}
+
+// Split returns the jobs this instance owns and queues the others for their owners
+func (c *Cluster) Split(jobs []Job) []Job {
+	local := make([]Job, 0, len(jobs))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, job := range jobs {
+		owner := c.ring.Owner(hostOf(job.URL))
+		if owner == c.node {
+			local = append(local, job)
+			continue
+		}
+		c.outbox[owner].jobs = append(c.outbox[owner].jobs, job)
+	}
+	return local
+}
+
+// Pending returns the number of jobs not accepted by their owner yet
+func (c *Cluster) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := c.sending
+	for _, queue := range c.outbox {
+		pending += len(queue.jobs)
+	}
+	return pending
+}
+
+// Flush sends the queued jobs to every peer that isn't waiting for a retry
+func (c *Cluster) Flush() {
+	for peer := range c.outbox {
+		for c.sendBatch(peer, false) {
+		}
+	}
+}
+
+// Close sends the jobs that are still queued, the jobs of peers that don't answer are dropped
+// from the queue and sent again by the next run
+func (c *Cluster) Close() {
+	for attempt := 0; attempt < 3 && c.Pending() > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Second)
+		}
+		for peer := range c.outbox {
+			for c.sendBatch(peer, true) {
+			}
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for peer, queue := range c.outbox {
+		if len(queue.jobs) > 0 {
+			fmt.Println("Dropping", len(queue.jobs), "jobs for unreachable peer", peer)
+			c.dropped.Add(int64(len(queue.jobs)))
+			queue.jobs = nil
+		}
+	}
+}
+
+// sendBatch sends the next batch of jobs to the peer, it returns true if there may be more to send
+func (c *Cluster) sendBatch(peer string, ignoreBackoff bool) bool {
+	c.mu.Lock()
+	queue := c.outbox[peer]
+	if len(queue.jobs) == 0 || (!ignoreBackoff && time.Now().Before(queue.retryAt)) {
+		c.mu.Unlock()
+		return false
+	}
+	batch := queue.jobs[:min(len(queue.jobs), MAX_PEER_BATCH)]
+	queue.jobs = queue.jobs[len(batch):]
+	c.sending += len(batch)
+	c.mu.Unlock()
+
+	var accepted PeerAccepted
+	err := c.request(http.MethodPost, peer, "/cluster/jobs", PeerJobs{From: c.node, Jobs: batch}, &accepted)
+	// the peer saved the jobs it accepted as its own pending jobs
+	if err == nil && c.delivered != nil {
+		c.delivered(batch)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sending -= len(batch)
+	if err == nil {
+		// the peer counts the same jobs as received, so the counts of the cluster match
+		c.sent.Add(accepted.Accepted)
+		queue.failures = 0
+		return true
+	}
+	queue.failures++
+	if queue.failures >= MAX_PEER_ATTEMPTS {
+		fmt.Println("Error sending jobs to", peer, err, "dropping", len(batch)+len(queue.jobs), "jobs")
+		c.dropped.Add(int64(len(batch) + len(queue.jobs)))
+		queue.jobs = nil
+		queue.failures = 0
+		return false
+	}
+	fmt.Println("Error sending jobs to", peer, err)
+	queue.jobs = append(slices.Clone(batch), queue.jobs...)
+	queue.retryAt = time.Now().Add(min(CLUSTER_INTERVAL<<queue.failures, MAX_PEER_BACKOFF))
+	return false
+}
+
+// Finished asks every peer for its state and reports whether the crawl is over
+func (c *Cluster) Finished(local PeerState) bool {
+	if !local.Idle {
+		c.last = nil
+		return false
+	}
+	states := make([]PeerState, 0, len(c.peers))
+	var sent, received int64
+	for _, peer := range c.peers {
+		state := local
+		if peer != c.node {
+			var err error
+			state, err = c.peerState(peer)
+			if err != nil || !state.Idle {
+				c.last = nil
+				return false
+			}
+		}
+		states = append(states, state)
+		sent += state.Sent
+		received += state.Received
+	}
+	// jobs still on their way make the counts differ, jobs sent since the last check change them
+	finished := sent == received && slices.Equal(states, c.last)
+	c.last = states
+	return finished
+}
+
+// Done tells the peers that the crawl is over
+func (c *Cluster) Done() {
+	for _, peer := range c.peers {
+		if peer == c.node {
+			continue
+		}
+		err := c.request(http.MethodPost, peer, "/cluster/done", struct{}{}, nil)
+		if err != nil {
+			fmt.Println("Error telling", peer, "that the crawl is finished", err)
+		}
+	}
+}
+
+func (c *Cluster) peerState(peer string) (PeerState, error) {
+	var state PeerState
+	err := c.request(http.MethodGet, peer, "/cluster/state", nil, &state)
+	return state, err
+}
+
+// request sends the value as JSON to the path of the peer with the cluster token,
+// and decodes the answer into out unless it is nil
+func (c *Cluster) request(method string, peer string, path string, value any, out any) error {
+	var body bytes.Buffer
+	if value != nil {
+		err := json.NewEncoder(&body).Encode(value)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, "http://"+peer+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// route keeps the jobs this instance owns and sends the others to their owners
+func (c *Crawler) route(jobs []Job) []Job {
+	if c.cluster == nil {
+		return jobs
+	}
+	return c.cluster.Split(jobs)
+}
+
+// owned returns the jobs this instance owns, every instance reads the same seeds and starts its own
+func (c *Crawler) owned(jobs []Job) []Job {
+	if c.cluster == nil {
+		return jobs
+	}
+	local := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if c.cluster.Owns(job.URL) {
+			local = append(local, job)
+		}
+	}
+	return local
+}
+
+// AddPeerJobs adds jobs sent by another instance and returns how many it accepted, jobs already
+// visited or of hosts this instance doesn't own are skipped. Before the crawl runs and once it stops
+// the jobs are refused, the sender keeps them pending and sends them again.
+func (c *Crawler) AddPeerJobs(jobs []Job) (int, error) {
+	added := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if c.cluster.Owns(job.URL) && !c.visited.IsVisited(job.URL) {
+			added = append(added, job)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active == 0 || c.stopping.Load() {
+		return 0, errNotRunning
+	}
+	err := c.state.AddPending(added)
+	if err != nil {
+		return 0, err
+	}
+	c.active += len(added)
+	c.wg.Add(len(added))
+	c.frontier.PushAll(added)
+	c.cluster.received.Add(int64(len(added)))
+	return len(added), nil
+}
+
+// hold keeps the crawl running while it has no jobs, more may come from the peers
+func (c *Crawler) hold() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held = true
+	c.active++
+	c.wg.Add(1)
+}
+
+// release lets the crawl end once its last job is done
+func (c *Crawler) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.held {
+		return
+	}
+	c.held = false
+	c.active--
+	c.wg.Done()
+}
+
+// peerState returns the state reported to the peers, idle when the crawl only waits for them
+func (c *Crawler) peerState() PeerState {
+	pending := c.cluster.Pending()
+	received := c.cluster.received.Load()
+	c.mu.Lock()
+	idle := c.held && c.active == 1 && pending == 0
+	c.mu.Unlock()
+	return PeerState{Idle: idle, Sent: c.cluster.sent.Load(), Received: received}
+}
+
+// clusterLoop sends the queued jobs to the peers and ends the crawl once the whole cluster is idle
+func (c *Crawler) clusterLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(CLUSTER_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.cluster.Flush()
+			if c.cluster.Finished(c.peerState()) {
+				fmt.Println("All instances are idle, the crawl is finished")
+				c.cluster.Done()
+				c.release()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+/*
+PeerServer serves the api the instances of a distributed crawl use to talk to each other:
+
+	POST /cluster/jobs   PeerJobs, jobs owned by this instance
+	GET  /cluster/state  PeerState of this instance
+	POST /cluster/done   the crawl is over
+
+Every request must carry the cluster token as "Authorization: Bearer <token>",
+and jobs are only taken from the configured peers.
+*/
+type PeerServer struct {
+	crawler *Crawler
+	token   string
+	server  *http.Server
+}
+
+// NewPeerServer creates a peer server for the crawler listening on addr
+func NewPeerServer(addr string, crawler *Crawler) *PeerServer {
+	p := &PeerServer{crawler: crawler, token: crawler.config.ClusterToken}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /cluster/jobs", p.jobs)
+	mux.HandleFunc("GET /cluster/state", p.state)
+	mux.HandleFunc("POST /cluster/done", p.done)
+	p.server = &http.Server{
+		Addr:              addr,
+		Handler:           authorize(p.token, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return p
+}
+
+// Start serves the api in the background
+func (p *PeerServer) Start() {
+	go func() {
+		fmt.Println("Peer api listening on", p.server.Addr)
+		err := p.server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Println("Error serving peer api", err)
+		}
+	}()
+}
+
+// Close stops the server, requests in progress get a few seconds to finish
+func (p *PeerServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return p.server.Shutdown(ctx)
+}
+
+func (p *PeerServer) jobs(w http.ResponseWriter, r *http.Request) {
+	var request PeerJobs
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_PEER_BODY)).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.From == p.crawler.cluster.node || !slices.Contains(p.crawler.cluster.peers, request.From) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%q is not a peer", request.From))
+		return
+	}
+	accepted, err := p.crawler.AddPeerJobs(request.Jobs)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, PeerAccepted{Accepted: int64(accepted)})
+}
+
+func (p *PeerServer) state(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.crawler.peerState())
+}
+
+func (p *PeerServer) done(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("A peer finished the crawl")
+	p.crawler.release()
+	writeJSON(w, http.StatusOK, map[string]bool{"done": true})
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the test binary runs the crawler instead of the tests when this is set, so the cluster test
+// can start several crawler processes
+const CRAWLER_PROCESS_ENV = "CRAWLER_TEST_PROCESS"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(CRAWLER_PROCESS_ENV) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestRingOwner(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []string
+	}{
+		{"one node", []string{"a:1"}},
+		{"three nodes", []string{"a:1", "a:2", "a:3"}},
+		{"five nodes", []string{"a:1", "a:2", "a:3", "b:1", "b:2"}},
+	}
+	for _, test := range tests {
+		ring := NewRing(test.nodes)
+		again := NewRing(test.nodes)
+		counts := make(map[string]int)
+		for i := range 1000 {
+			host := fmt.Sprintf("host%d.example.com", i)
+			owner := ring.Owner(host)
+			if owner != again.Owner(host) {
+				t.Fatalf("%s: owner of %s differs between rings with the same nodes", test.name, host)
+			}
+			counts[owner]++
+		}
+		for _, node := range test.nodes {
+			// every node gets a fair share of the hosts
+			if counts[node] < 1000/len(test.nodes)/2 {
+				t.Errorf("%s: node %s owns %d of 1000 hosts", test.name, node, counts[node])
+			}
+		}
+		if len(counts) != len(test.nodes) {
+			t.Errorf("%s: hosts owned by %v, want only %v", test.name, counts, test.nodes)
+		}
+	}
+}
+
+func TestRingRemoveNode(t *testing.T) {
+	before := NewRing([]string{"a:1", "a:2", "a:3", "a:4"})
+	after := NewRing([]string{"a:1", "a:2", "a:4"})
+	for i := range 1000 {
+		host := fmt.Sprintf("host%d.example.com", i)
+		// only the hosts of the removed node move
+		if owner := before.Owner(host); owner != "a:3" && after.Owner(host) != owner {
+			t.Errorf("%s moved from %s to %s", host, owner, after.Owner(host))
+		}
+	}
+}
+
+func TestPeerServerAuth(t *testing.T) {
+	config := NewConfig()
+	config.Peers = []string{"127.0.0.1:9001", "127.0.0.1:9002"}
+	config.Node = "127.0.0.1:9001"
+	config.ClusterToken = "secret"
+	crawler := &Crawler{config: config, cluster: NewCluster(config)}
+	handler := NewPeerServer(config.Node, crawler).server.Handler
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		body   string
+		status int
+	}{
+		{"no token", http.MethodGet, "/cluster/state", "", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/cluster/state", "Bearer other", "", http.StatusUnauthorized},
+		{"token without bearer", http.MethodGet, "/cluster/state", "secret", "", http.StatusUnauthorized},
+		{"state", http.MethodGet, "/cluster/state", "Bearer secret", "", http.StatusOK},
+		{"done without token", http.MethodPost, "/cluster/done", "", "{}", http.StatusUnauthorized},
+		{"jobs from a stranger", http.MethodPost, "/cluster/jobs", "Bearer secret", `{"from":"10.0.0.1:9001","jobs":[]}`, http.StatusForbidden},
+		{"jobs from itself", http.MethodPost, "/cluster/jobs", "Bearer secret", `{"from":"127.0.0.1:9001","jobs":[]}`, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, rec.Code, test.status, rec.Body.String())
+		}
+	}
+}
+
+// testClusterCrawler returns a crawler of the first of the peers that hasn't started its crawl
+func testClusterCrawler(t *testing.T, peers []string) *Crawler {
+	config := NewConfig()
+	config.Peers = peers
+	config.Node = peers[0]
+	config.ClusterToken = "secret"
+	config.Scope.Include = nil
+	scope, err := NewScope(config.Scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := NewFilesystemStorage(t.TempDir(), config.PagesDir, config.MetadataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCrawler(storage, config, openTestState(t), scope, nil)
+}
+
+func TestPeerJobsBeforeStart(t *testing.T) {
+	crawler := testClusterCrawler(t, []string{"127.0.0.1:9001", "127.0.0.1:9002"})
+	var owned []Job
+	for i := 0; len(owned) < 3; i++ {
+		job := Job{URL: fmt.Sprintf("https://host%d.example.com/", i)}
+		if crawler.cluster.Owns(job.URL) {
+			owned = append(owned, job)
+		}
+	}
+	body, err := json.Marshal(PeerJobs{From: "127.0.0.1:9002", Jobs: owned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/cluster/jobs", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	NewPeerServer(crawler.config.Node, crawler).server.Handler.ServeHTTP(rec, req)
+
+	// the sender keeps the jobs and sends them again once the crawl runs
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	pending, err := crawler.state.PendingJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 || crawler.cluster.received.Load() != 0 {
+		t.Errorf("refused jobs were saved: %d pending, %d received", len(pending), crawler.cluster.received.Load())
+	}
+}
+
+func TestPeerJobsPendingUntilDelivered(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		pending int
+	}{
+		{"delivered", http.StatusOK, 0},
+		{"peer not running", http.StatusServiceUnavailable, 5},
+		{"peer fails", http.StatusInternalServerError, 5},
+	}
+	for _, test := range tests {
+		peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, test.status, PeerAccepted{Accepted: 5})
+		}))
+		crawler := testClusterCrawler(t, []string{"127.0.0.1:9001", peer.Listener.Addr().String()})
+		var jobs []Job
+		for i := 0; len(jobs) < 5; i++ {
+			job := Job{URL: fmt.Sprintf("https://host%d.example.com/", i)}
+			if !crawler.cluster.Owns(job.URL) {
+				jobs = append(jobs, job)
+			}
+		}
+		err := crawler.state.AddPending(jobs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if local := crawler.route(jobs); len(local) != 0 {
+			t.Fatalf("%s: %d jobs of the peer kept", test.name, len(local))
+		}
+		crawler.cluster.Flush()
+		peer.Close()
+
+		pending, err := crawler.state.PendingJobs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != test.pending {
+			t.Errorf("%s: %d jobs pending, want %d", test.name, len(pending), test.pending)
+		}
+	}
+}
+
+// clusterSite serves pages of a small site spread over several hosts and counts how often every page is fetched
+type clusterSite struct {
+	servers []*httptest.Server
+	mu      sync.Mutex
+	fetches map[string]int
+}
+
+// the site has CLUSTER_SITE_PAGES pages on each of CLUSTER_SITE_HOSTS hosts
+const CLUSTER_SITE_HOSTS = 4
+const CLUSTER_SITE_PAGES = 10
+
+func newClusterSite(t *testing.T) *clusterSite {
+	site := &clusterSite{fetches: make(map[string]int)}
+	for s := range CLUSTER_SITE_HOSTS {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				http.NotFound(w, r)
+				return
+			}
+			var k int
+			if _, err := fmt.Sscanf(r.URL.Path, "/wiki/%d", &k); err != nil || k >= CLUSTER_SITE_PAGES {
+				http.NotFound(w, r)
+				return
+			}
+			site.mu.Lock()
+			site.fetches[site.url(s, k)]++
+			site.mu.Unlock()
+			// every page links to the next page of its host, the same page of the next host
+			// and one more page of another host
+			links := []string{
+				site.url(s, (k+1)%CLUSTER_SITE_PAGES),
+				site.url((s+1)%CLUSTER_SITE_HOSTS, k),
+				site.url((s+2)%CLUSTER_SITE_HOSTS, k*3%CLUSTER_SITE_PAGES),
+			}
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<html><head><title>Page %d of host %d</title></head><body><div id="mw-content-text"><p>Page %d on host %d.</p>`, k, s, k, s)
+			for _, link := range links {
+				fmt.Fprintf(w, `<a href="%s">link</a>`, link)
+			}
+			fmt.Fprint(w, `</div></body></html>`)
+		}))
+		t.Cleanup(server.Close)
+		site.servers = append(site.servers, server)
+	}
+	return site
+}
+
+func (s *clusterSite) url(host int, page int) string {
+	return fmt.Sprintf("%s/wiki/%d", s.servers[host].URL, page)
+}
+
+// freeAddr returns a localhost address nothing listens on
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// TestClusterLocalhost runs a crawl with three crawler processes on localhost
+// and checks that every page is fetched exactly once by the whole cluster
+func TestClusterLocalhost(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts several crawler processes")
+	}
+	site := newClusterSite(t)
+	dir := t.TempDir()
+	peers := []string{freeAddr(t), freeAddr(t), freeAddr(t)}
+
+	config := map[string]any{
+		"version":         1,
+		"crawl_id":        "cluster-test",
+		"seeds":           []string{site.url(0, 0), site.url(2, 0)},
+		"max_depth":       50,
+		"workers":         4,
+		"host_delay":      "5ms",
+		"storage":         "filesystem",
+		"near_duplicates": "off",
+		"peers":           peers,
+		"scope":           map[string]any{"allowed_hosts": []string{"127.0.0.1"}, "schemes": []string{"http"}},
+	}
+	body, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// yaml reads json
+	configPath := filepath.Join(dir, "crawl.yaml")
+	err = os.WriteFile(configPath, body, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type process struct {
+		cmd    *exec.Cmd
+		output bytes.Buffer
+		done   chan error
+	}
+	processes := make([]*process, 0, len(peers))
+	for i, node := range peers {
+		args := []string{"-config", configPath, "-node", node,
+			"-state-dir", filepath.Join(dir, fmt.Sprintf("state-%d", i)),
+			"-data-dir", filepath.Join(dir, fmt.Sprintf("data-%d", i))}
+		for _, peer := range peers {
+			args = append(args, "-peer", peer)
+		}
+		p := &process{cmd: exec.Command(os.Args[0], args...), done: make(chan error, 1)}
+		p.cmd.Env = append(os.Environ(), CRAWLER_PROCESS_ENV+"=1", "CLUSTER_TOKEN=test-token", "CRAWL_MODE=crawl")
+		p.cmd.Stdout = &p.output
+		p.cmd.Stderr = &p.output
+		err := p.cmd.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() { p.done <- p.cmd.Wait() }()
+		processes = append(processes, p)
+	}
+
+	timeout := time.After(60 * time.Second)
+	for i, p := range processes {
+		select {
+		case err := <-p.done:
+			if err != nil {
+				t.Errorf("instance %d failed: %v\n%s", i, err, p.output.String())
+			}
+		case <-timeout:
+			for _, p := range processes {
+				p.cmd.Process.Kill()
+			}
+			<-p.done
+			t.Fatalf("cluster didn't finish the crawl, instance %d output:\n%s", i, p.output.String())
+		}
+	}
+
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	for s := range CLUSTER_SITE_HOSTS {
+		for k := range CLUSTER_SITE_PAGES {
+			if n := site.fetches[site.url(s, k)]; n != 1 {
+				t.Errorf("%s fetched %d times, want once", site.url(s, k), n)
+			}
+		}
+	}
+	if t.Failed() {
+		for i, p := range processes {
+			t.Logf("instance %d output:\n%s", i, p.output.String())
+		}
+	}
+}
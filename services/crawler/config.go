@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
-	"log"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -14,27 +14,116 @@ import (
 
 const PAGES_DIR = "pages"
 const METADATA_DIR = "metadata"
+const STATE_DIR = "state"
+const DATA_DIR = "data"
+
+// crawl modes
+const MODE_CRAWL = "crawl"
+const MODE_RECRAWL = "recrawl"
+const MODE_DUMP = "dump"
+const MODE_REPORT = "report"
+
+// storage backends
+const STORAGE_MINIO = "minio"
+const STORAGE_FILESYSTEM = "filesystem"
+const STORAGE_WARC = "warc"
 
 type Config struct {
-	StartLinks  []string
-	MaxDepth    int
+	// crawl discovers new pages, recrawl revisits the stored ones, dump reads a Wikipedia XML dump
+	Mode       string
+	CrawlID    string
+	StartLinks []string
+	// pages-articles.xml(.bz2) read in dump mode
+	DumpFile string
+	// files with one start link per line, read into StartLinks when the config is loaded
+	SeedFiles []string
+	MaxDepth  int
+	// budgets, 0 means no limit. Pages and bytes count the stored pages of all runs of the crawl,
+	// the duration is the one of a run. A crawl that uses up one of them stops.
+	MaxPages    int
+	MaxBytes    int64
+	MaxDuration time.Duration
+	// pages stored per host and per seed subtree, jobs over these budgets are skipped
+	MaxPagesPerHost int
+	MaxPagesPerSeed int
+	UserAgent       string
+	// timeout of a whole request, including reading the body
+	RequestTimeout time.Duration
+	// timeout for connecting, including the TLS handshake
+	ConnectTimeout time.Duration
+	// longest wait for the response headers or the next part of the body
+	ReadTimeout time.Duration
+	// larger bodies are dropped, after decompression
+	MaxBodySize int64
+	// retries after transient errors, the delay starts at RetryBackoff and doubles up to MaxBackoff
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// which links are followed and which images are kept
+	Scope ScopeRules
+	// topic of a focused crawl, the seed pages when there are no keywords,
+	// and the relevance a page needs for its links to be followed
+	FocusKeywords  []string
+	FocusThreshold float64
+	// order in which discovered pages are crawled, bfs, dfs, opic, relevance or focused
+	Strategy    string
 	JobsBuffer  int
-	MaxRounds   int
 	NumWorkers  int
+	HostDelay   time.Duration
+	MaxPerHost  int
+	MaxBackoff  time.Duration
 	PagesDir    string
 	MetadataDir string
-	MongoUri    string
-	MinioClient *minio.Client
+	StateDir    string
+	// how often the crawl state is checkpointed
+	CheckpointInterval time.Duration
+	// recrawl interval of a new page and its bounds
+	RecrawlInterval    time.Duration
+	MinRecrawlInterval time.Duration
+	MaxRecrawlInterval time.Duration
+	// near duplicates are marked, skipped or not detected, pages are near duplicates
+	// when their fingerprints differ in at most NearDuplicateDistance bits
+	NearDuplicates        string
+	NearDuplicateDistance int
+	// where pages and metadata are saved
+	StorageBackend string
+	// writes go through a background writer, pages by StorageWorkers uploaders from a queue of
+	// StorageQueue pages, the other writes in batches of MetadataBatch or every
+	// MetadataFlushInterval. Failed writes are retried StorageRetries times.
+	StorageWorkers        int
+	StorageQueue          int
+	MetadataBatch         int
+	MetadataFlushInterval time.Duration
+	StorageRetries        int
+	// directory of the filesystem and WARC storage
+	DataDir string
+	// address of the admin http api of a crawl, like "127.0.0.1:8090", empty to disable it.
+	// Other addresses need ClusterToken, requests must carry it as a bearer token.
+	AdminAddr string
+	// instances of a distributed crawl as host:port of their peer api, the same list on every
+	// instance, and the one of this instance. Empty when this instance crawls alone.
+	Peers []string
+	Node  string
+	// shared by the instances of a distributed crawl, every request to the peer api must carry it
+	ClusterToken string `json:"-"`
+	// connection strings carry credentials, they are never saved with the config
+	MongoUri string `json:"-"`
 }
 
 func NewConfig() *Config {
 	monogUri := os.Getenv("MONGO_CONNECTION")
-	minioClient, err := newR2Client()
-	if err != nil {
-		log.Println("Error creating minio client", err)
+	mode := os.Getenv("CRAWL_MODE")
+	if mode == "" {
+		mode = MODE_CRAWL
+	}
+	// reusing a crawl id resumes that crawl
+	crawlID := os.Getenv("CRAWL_ID")
+	if crawlID == "" {
+		crawlID = "crawl-" + time.Now().Format("20060102-150405")
 	}
 
 	return &Config{
+		Mode:    mode,
+		CrawlID: crawlID,
 		StartLinks: []string{
 			"https://en.wikipedia.org/wiki/Philosophy",
 			"https://en.wikipedia.org/wiki/Mathematics",
@@ -60,14 +149,43 @@ func NewConfig() *Config {
 			"https://en.wikipedia.org/wiki/Engineering",
 			"https://en.wikipedia.org/wiki/Geography",
 		},
-		MaxDepth:    1,
-		JobsBuffer:  10000,
-		MaxRounds:   1000,
-		NumWorkers:  runtime.NumCPU(),
-		PagesDir:    PAGES_DIR,
-		MetadataDir: METADATA_DIR,
-		MongoUri:    monogUri,
-		MinioClient: minioClient,
+		SeedFiles:             []string{},
+		Peers:                 []string{},
+		MaxDepth:              1,
+		Scope:                 WikipediaScope(),
+		Strategy:              STRATEGY_BFS,
+		FocusKeywords:         []string{},
+		FocusThreshold:        0.1,
+		UserAgent:             USER_AGENT,
+		RequestTimeout:        30 * time.Second,
+		ConnectTimeout:        10 * time.Second,
+		ReadTimeout:           15 * time.Second,
+		MaxBodySize:           10 << 20,
+		MaxRetries:            3,
+		RetryBackoff:          2 * time.Second,
+		JobsBuffer:            10000,
+		NumWorkers:            runtime.NumCPU(),
+		HostDelay:             200 * time.Millisecond,
+		MaxPerHost:            2,
+		MaxBackoff:            5 * time.Minute,
+		PagesDir:              PAGES_DIR,
+		MetadataDir:           METADATA_DIR,
+		StateDir:              STATE_DIR,
+		CheckpointInterval:    30 * time.Second,
+		RecrawlInterval:       7 * 24 * time.Hour,
+		MinRecrawlInterval:    24 * time.Hour,
+		NearDuplicates:        DUPLICATES_MARK,
+		NearDuplicateDistance: 3,
+		MaxRecrawlInterval:    90 * 24 * time.Hour,
+		StorageBackend:        STORAGE_MINIO,
+		StorageWorkers:        4,
+		StorageQueue:          100,
+		MetadataBatch:         300,
+		MetadataFlushInterval: 5 * time.Second,
+		StorageRetries:        3,
+		DataDir:               DATA_DIR,
+		MongoUri:              monogUri,
+		ClusterToken:          os.Getenv("CLUSTER_TOKEN"),
 	}
 }
 
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// version of the config file format
+const CONFIG_VERSION = 1
+
+/*
+FileConfig is the YAML/JSON config file. Fields that are left out keep their defaults,
+durations are strings like "30s" or "2h".
+*/
+type FileConfig struct {
+	Version               int              `yaml:"version" json:"version"`
+	CrawlID               string           `yaml:"crawl_id" json:"crawl_id"`
+	Mode                  string           `yaml:"mode" json:"mode"`
+	DumpFile              string           `yaml:"dump_file" json:"dump_file"`
+	Seeds                 []string         `yaml:"seeds" json:"seeds"`
+	SeedFiles             []string         `yaml:"seed_files" json:"seed_files"`
+	MaxDepth              *int             `yaml:"max_depth" json:"max_depth"`
+	MaxPages              *int             `yaml:"max_pages" json:"max_pages"`
+	MaxBytes              *int64           `yaml:"max_bytes" json:"max_bytes"`
+	MaxDuration           string           `yaml:"max_duration" json:"max_duration"`
+	MaxPagesPerHost       *int             `yaml:"max_pages_per_host" json:"max_pages_per_host"`
+	MaxPagesPerSeed       *int             `yaml:"max_pages_per_seed" json:"max_pages_per_seed"`
+	Workers               *int             `yaml:"workers" json:"workers"`
+	JobsBuffer            *int             `yaml:"jobs_buffer" json:"jobs_buffer"`
+	UserAgent             string           `yaml:"user_agent" json:"user_agent"`
+	RequestTimeout        string           `yaml:"request_timeout" json:"request_timeout"`
+	ConnectTimeout        string           `yaml:"connect_timeout" json:"connect_timeout"`
+	ReadTimeout           string           `yaml:"read_timeout" json:"read_timeout"`
+	MaxBodySize           *int64           `yaml:"max_body_size" json:"max_body_size"`
+	MaxRetries            *int             `yaml:"max_retries" json:"max_retries"`
+	RetryBackoff          string           `yaml:"retry_backoff" json:"retry_backoff"`
+	HostDelay             string           `yaml:"host_delay" json:"host_delay"`
+	MaxPerHost            *int             `yaml:"max_per_host" json:"max_per_host"`
+	MaxBackoff            string           `yaml:"max_backoff" json:"max_backoff"`
+	StateDir              string           `yaml:"state_dir" json:"state_dir"`
+	CheckpointInterval    string           `yaml:"checkpoint_interval" json:"checkpoint_interval"`
+	RecrawlInterval       string           `yaml:"recrawl_interval" json:"recrawl_interval"`
+	MinRecrawlInterval    string           `yaml:"min_recrawl_interval" json:"min_recrawl_interval"`
+	MaxRecrawlInterval    string           `yaml:"max_recrawl_interval" json:"max_recrawl_interval"`
+	Strategy              string           `yaml:"strategy" json:"strategy"`
+	FocusKeywords         []string         `yaml:"focus_keywords" json:"focus_keywords"`
+	FocusThreshold        *float64         `yaml:"focus_threshold" json:"focus_threshold"`
+	NearDuplicates        string           `yaml:"near_duplicates" json:"near_duplicates"`
+	NearDuplicateDistance *int             `yaml:"near_duplicate_distance" json:"near_duplicate_distance"`
+	Storage               string           `yaml:"storage" json:"storage"`
+	StorageWorkers        *int             `yaml:"storage_workers" json:"storage_workers"`
+	StorageQueue          *int             `yaml:"storage_queue" json:"storage_queue"`
+	MetadataBatch         *int             `yaml:"metadata_batch" json:"metadata_batch"`
+	MetadataFlushInterval string           `yaml:"metadata_flush_interval" json:"metadata_flush_interval"`
+	StorageRetries        *int             `yaml:"storage_retries" json:"storage_retries"`
+	DataDir               string           `yaml:"data_dir" json:"data_dir"`
+	AdminAddr             string           `yaml:"admin_addr" json:"admin_addr"`
+	Node                  string           `yaml:"node" json:"node"`
+	Peers                 []string         `yaml:"peers" json:"peers"`
+	Scope                 *FileScopeConfig `yaml:"scope" json:"scope"`
+}
+
+// FileScopeConfig replaces the default scope rules when it is set
+type FileScopeConfig struct {
+	AllowedHosts []string `yaml:"allowed_hosts" json:"allowed_hosts"`
+	Schemes      []string `yaml:"schemes" json:"schemes"`
+	Include      []string `yaml:"include" json:"include"`
+	Exclude      []string `yaml:"exclude" json:"exclude"`
+	DepthLimits  []struct {
+		Pattern  string `yaml:"pattern" json:"pattern"`
+		MaxDepth int    `yaml:"max_depth" json:"max_depth"`
+	} `yaml:"depth_limits" json:"depth_limits"`
+	ImageHosts []string `yaml:"image_hosts" json:"image_hosts"`
+}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// LoadConfig builds the config from the defaults, the config file given with -config
+// and the command line flags, in that order, and validates it
+func LoadConfig(args []string) (*Config, error) {
+	config := NewConfig()
+
+	fs := flag.NewFlagSet("crawler", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to a YAML or JSON config file")
+	crawlID := fs.String("crawl-id", "", "id of the crawl, reusing an id resumes that crawl")
+	mode := fs.String("mode", "", "crawl, recrawl, dump or report")
+	dumpFile := fs.String("dump", "", "Wikipedia pages-articles.xml(.bz2) dump read in dump mode")
+	var seeds, seedFiles, peers, focusKeywords stringList
+	fs.Var(&seeds, "seed", "start url, can be repeated, replaces the seeds of the config")
+	fs.Var(&seedFiles, "seeds-file", "file with one start url per line, can be repeated, replaces the seeds of the config unless -seed is given")
+	maxDepth := fs.Int("depth", 0, "maximum crawl depth")
+	workers := fs.Int("workers", 0, "number of concurrent workers")
+	maxPages := fs.Int("max-pages", 0, "stop after this many pages, 0 for no limit")
+	maxBytes := fs.Int64("max-bytes", 0, "stop after storing this many bytes of pages, 0 for no limit")
+	maxDuration := fs.Duration("max-duration", 0, "stop after this long, 0 for no limit")
+	maxPagesPerHost := fs.Int("max-pages-per-host", 0, "pages stored per host, 0 for no limit")
+	maxPagesPerSeed := fs.Int("max-pages-per-seed", 0, "pages stored per seed and the pages found from it, 0 for no limit")
+	userAgent := fs.String("user-agent", "", "user agent sent with every request")
+	timeout := fs.Duration("timeout", 0, "timeout of a request")
+	connectTimeout := fs.Duration("connect-timeout", 0, "timeout for connecting to a host")
+	readTimeout := fs.Duration("read-timeout", 0, "longest wait for response headers or body data")
+	maxBodySize := fs.Int64("max-body-size", 0, "maximum size of a page in bytes")
+	retries := fs.Int("retries", 0, "retries after transient errors")
+	strategy := fs.String("strategy", "", "crawl order, bfs, dfs, opic, relevance or focused")
+	fs.Var(&focusKeywords, "focus-keyword", "keyword of the topic of a focused crawl, can be repeated, replaces the keywords of the config")
+	focusThreshold := fs.Float64("focus-threshold", 0, "relevance a page of a focused crawl needs for its links to be followed, 0 to 1")
+	nearDuplicates := fs.String("near-duplicates", "", "mark, skip or off")
+	storage := fs.String("storage", "", "storage backend, minio, filesystem or warc")
+	storageWorkers := fs.Int("storage-workers", 0, "concurrent uploads of pages to the storage")
+	storageQueue := fs.Int("storage-queue", 0, "pages waiting for upload before workers wait for the storage")
+	metadataBatch := fs.Int("metadata-batch", 0, "metadata documents written together")
+	metadataFlush := fs.Duration("metadata-flush", 0, "longest time metadata waits for its batch")
+	storageRetries := fs.Int("storage-retries", 0, "retries of failed storage writes")
+	dataDir := fs.String("data-dir", "", "directory of the filesystem and warc storage")
+	adminAddr := fs.String("admin", "", "address of the admin http api, like 127.0.0.1:8090")
+	stateDir := fs.String("state-dir", "", "directory of the crawl state")
+	node := fs.String("node", "", "host:port of the peer api of this instance in a distributed crawl")
+	fs.Var(&peers, "peer", "host:port of an instance of a distributed crawl, can be repeated, replaces the peers of the config")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		err = config.applyFile(*configPath)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %w", *configPath, err)
+		}
+	}
+
+	// flags win over the config file, only the ones that were given
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "crawl-id":
+			config.CrawlID = *crawlID
+		case "mode":
+			config.Mode = *mode
+		case "dump":
+			config.DumpFile = *dumpFile
+		case "seed":
+			config.StartLinks = seeds
+		case "seeds-file":
+			config.SeedFiles = seedFiles
+			if len(seeds) == 0 {
+				config.StartLinks = []string{}
+			}
+		case "depth":
+			config.MaxDepth = *maxDepth
+		case "workers":
+			config.NumWorkers = *workers
+		case "max-pages":
+			config.MaxPages = *maxPages
+		case "max-bytes":
+			config.MaxBytes = *maxBytes
+		case "max-duration":
+			config.MaxDuration = *maxDuration
+		case "max-pages-per-host":
+			config.MaxPagesPerHost = *maxPagesPerHost
+		case "max-pages-per-seed":
+			config.MaxPagesPerSeed = *maxPagesPerSeed
+		case "user-agent":
+			config.UserAgent = *userAgent
+		case "timeout":
+			config.RequestTimeout = *timeout
+		case "connect-timeout":
+			config.ConnectTimeout = *connectTimeout
+		case "read-timeout":
+			config.ReadTimeout = *readTimeout
+		case "max-body-size":
+			config.MaxBodySize = *maxBodySize
+		case "retries":
+			config.MaxRetries = *retries
+		case "strategy":
+			config.Strategy = *strategy
+		case "focus-keyword":
+			config.FocusKeywords = focusKeywords
+		case "focus-threshold":
+			config.FocusThreshold = *focusThreshold
+		case "near-duplicates":
+			config.NearDuplicates = *nearDuplicates
+		case "storage":
+			config.StorageBackend = *storage
+		case "storage-workers":
+			config.StorageWorkers = *storageWorkers
+		case "storage-queue":
+			config.StorageQueue = *storageQueue
+		case "metadata-batch":
+			config.MetadataBatch = *metadataBatch
+		case "metadata-flush":
+			config.MetadataFlushInterval = *metadataFlush
+		case "storage-retries":
+			config.StorageRetries = *storageRetries
+		case "data-dir":
+			config.DataDir = *dataDir
+		case "admin":
+			config.AdminAddr = *adminAddr
+		case "state-dir":
+			config.StateDir = *stateDir
+		case "node":
+			config.Node = *node
+		case "peer":
+			config.Peers = peers
+		}
+	})
+
+	for _, path := range config.SeedFiles {
+		links, err := readSeedFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("seed file %s: %w", path, err)
+		}
+		config.StartLinks = append(config.StartLinks, links...)
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// applyFile overrides the config with the fields set in the file
+func (c *Config) applyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file FileConfig
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	}
+	if err != nil {
+		return err
+	}
+	if file.Version != 0 && file.Version != CONFIG_VERSION {
+		return fmt.Errorf("unsupported version %d, expected %d", file.Version, CONFIG_VERSION)
+	}
+
+	setString(&c.CrawlID, file.CrawlID)
+	setString(&c.Mode, file.Mode)
+	setString(&c.DumpFile, file.DumpFile)
+	// seed files replace the default seeds, the seeds of the same file are crawled with them
+	if file.Seeds != nil {
+		c.StartLinks = file.Seeds
+	} else if len(file.SeedFiles) > 0 {
+		c.StartLinks = []string{}
+	}
+	if file.SeedFiles != nil {
+		c.SeedFiles = file.SeedFiles
+	}
+	setInt(&c.MaxDepth, file.MaxDepth)
+	setInt(&c.MaxPages, file.MaxPages)
+	setInt(&c.MaxPagesPerHost, file.MaxPagesPerHost)
+	setInt(&c.MaxPagesPerSeed, file.MaxPagesPerSeed)
+	setInt(&c.NumWorkers, file.Workers)
+	setInt(&c.JobsBuffer, file.JobsBuffer)
+	setInt(&c.MaxPerHost, file.MaxPerHost)
+	setInt(&c.MaxRetries, file.MaxRetries)
+	if file.MaxBodySize != nil {
+		c.MaxBodySize = *file.MaxBodySize
+	}
+	if file.MaxBytes != nil {
+		c.MaxBytes = *file.MaxBytes
+	}
+	setString(&c.UserAgent, file.UserAgent)
+	setString(&c.StateDir, file.StateDir)
+	setString(&c.Strategy, file.Strategy)
+	if file.FocusKeywords != nil {
+		c.FocusKeywords = file.FocusKeywords
+	}
+	if file.FocusThreshold != nil {
+		c.FocusThreshold = *file.FocusThreshold
+	}
+	setString(&c.NearDuplicates, file.NearDuplicates)
+	setInt(&c.NearDuplicateDistance, file.NearDuplicateDistance)
+	setString(&c.StorageBackend, file.Storage)
+	setInt(&c.StorageWorkers, file.StorageWorkers)
+	setInt(&c.StorageQueue, file.StorageQueue)
+	setInt(&c.MetadataBatch, file.MetadataBatch)
+	setInt(&c.StorageRetries, file.StorageRetries)
+	setString(&c.DataDir, file.DataDir)
+	setString(&c.AdminAddr, file.AdminAddr)
+	setString(&c.Node, file.Node)
+	if file.Peers != nil {
+		c.Peers = file.Peers
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"max_duration", file.MaxDuration, &c.MaxDuration},
+		{"request_timeout", file.RequestTimeout, &c.RequestTimeout},
+		{"connect_timeout", file.ConnectTimeout, &c.ConnectTimeout},
+		{"read_timeout", file.ReadTimeout, &c.ReadTimeout},
+		{"retry_backoff", file.RetryBackoff, &c.RetryBackoff},
+		{"host_delay", file.HostDelay, &c.HostDelay},
+		{"max_backoff", file.MaxBackoff, &c.MaxBackoff},
+		{"checkpoint_interval", file.CheckpointInterval, &c.CheckpointInterval},
+		{"recrawl_interval", file.RecrawlInterval, &c.RecrawlInterval},
+		{"min_recrawl_interval", file.MinRecrawlInterval, &c.MinRecrawlInterval},
+		{"max_recrawl_interval", file.MaxRecrawlInterval, &c.MaxRecrawlInterval},
+		{"metadata_flush_interval", file.MetadataFlushInterval, &c.MetadataFlushInterval},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.name, err)
+		}
+		*d.dst = parsed
+	}
+
+	if file.Scope != nil {
+		c.Scope = ScopeRules{
+			AllowedHosts: file.Scope.AllowedHosts,
+			Schemes:      file.Scope.Schemes,
+			Include:      file.Scope.Include,
+			Exclude:      file.Scope.Exclude,
+			ImageHosts:   file.Scope.ImageHosts,
+		}
+		for _, limit := range file.Scope.DepthLimits {
+			c.Scope.DepthLimits = append(c.Scope.DepthLimits, DepthLimit{Pattern: limit.Pattern, MaxDepth: limit.MaxDepth})
+		}
+	}
+	return nil
+}
+
+// Validate checks the config and returns every problem it finds
+func (c *Config) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(slices.Contains([]string{MODE_CRAWL, MODE_RECRAWL, MODE_DUMP, MODE_REPORT}, c.Mode),
+		"mode must be %q, %q, %q or %q, got %q", MODE_CRAWL, MODE_RECRAWL, MODE_DUMP, MODE_REPORT, c.Mode)
+	check(c.CrawlID != "" && !strings.ContainsAny(c.CrawlID, `/\`) && c.CrawlID != "." && c.CrawlID != "..", "crawl id %q must be a non-empty name without slashes", c.CrawlID)
+	if c.Mode == MODE_CRAWL {
+		check(len(c.StartLinks) > 0, "at least one seed is required")
+	}
+	if c.Mode == MODE_DUMP {
+		_, err := os.Stat(c.DumpFile)
+		check(c.DumpFile != "" && err == nil, "dump mode needs an existing dump file, got %q", c.DumpFile)
+	}
+	for _, link := range c.StartLinks {
+		u, err := url.Parse(link)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "seed %q is not an absolute http(s) url", link)
+	}
+	check(c.MaxDepth >= 0, "max depth must not be negative, got %d", c.MaxDepth)
+	check(c.MaxPages >= 0, "max pages must not be negative, got %d", c.MaxPages)
+	check(c.MaxBytes >= 0, "max bytes must not be negative, got %d", c.MaxBytes)
+	check(c.MaxDuration >= 0, "max duration must not be negative, got %s", c.MaxDuration)
+	check(c.MaxPagesPerHost >= 0, "max pages per host must not be negative, got %d", c.MaxPagesPerHost)
+	check(c.MaxPagesPerSeed >= 0, "max pages per seed must not be negative, got %d", c.MaxPagesPerSeed)
+	check(c.NumWorkers >= 1, "workers must be at least 1, got %d", c.NumWorkers)
+	check(c.JobsBuffer >= 1, "jobs buffer must be at least 1, got %d", c.JobsBuffer)
+	check(strings.TrimSpace(c.UserAgent) != "", "user agent must not be empty")
+	check(c.RequestTimeout > 0, "request timeout must be positive, got %s", c.RequestTimeout)
+	check(c.ConnectTimeout > 0, "connect timeout must be positive, got %s", c.ConnectTimeout)
+	check(c.ReadTimeout > 0, "read timeout must be positive, got %s", c.ReadTimeout)
+	check(c.MaxBodySize > 0, "max body size must be positive, got %d", c.MaxBodySize)
+	check(c.MaxRetries >= 0, "max retries must not be negative, got %d", c.MaxRetries)
+	check(c.RetryBackoff > 0, "retry backoff must be positive, got %s", c.RetryBackoff)
+	check(c.HostDelay >= 0, "host delay must not be negative, got %s", c.HostDelay)
+	check(c.MaxPerHost >= 1, "max per host must be at least 1, got %d", c.MaxPerHost)
+	check(slices.Contains([]string{STRATEGY_BFS, STRATEGY_DFS, STRATEGY_OPIC, STRATEGY_RELEVANCE, STRATEGY_FOCUSED}, c.Strategy),
+		"strategy must be %q, %q, %q, %q or %q, got %q", STRATEGY_BFS, STRATEGY_DFS, STRATEGY_OPIC, STRATEGY_RELEVANCE, STRATEGY_FOCUSED, c.Strategy)
+	check(c.FocusThreshold >= 0 && c.FocusThreshold <= 1, "focus threshold must be between 0 and 1, got %g", c.FocusThreshold)
+	check(slices.Contains([]string{DUPLICATES_MARK, DUPLICATES_SKIP, DUPLICATES_OFF}, c.NearDuplicates),
+		"near duplicates must be %q, %q or %q, got %q", DUPLICATES_MARK, DUPLICATES_SKIP, DUPLICATES_OFF, c.NearDuplicates)
+	check(c.NearDuplicateDistance >= 0 && c.NearDuplicateDistance <= MAX_SIMHASH_DISTANCE,
+		"near duplicate distance must be between 0 and %d, got %d", MAX_SIMHASH_DISTANCE, c.NearDuplicateDistance)
+	check(c.MaxBackoff >= MIN_BACKOFF, "max backoff must be at least %s, got %s", MIN_BACKOFF, c.MaxBackoff)
+	check(c.StateDir != "", "state dir must not be empty")
+	check(c.CheckpointInterval > 0, "checkpoint interval must be positive, got %s", c.CheckpointInterval)
+	check(c.MinRecrawlInterval > 0 && c.MinRecrawlInterval <= c.RecrawlInterval && c.RecrawlInterval <= c.MaxRecrawlInterval,
+		"recrawl intervals must satisfy 0 < min (%s) <= interval (%s) <= max (%s)", c.MinRecrawlInterval, c.RecrawlInterval, c.MaxRecrawlInterval)
+	check(slices.Contains([]string{STORAGE_MINIO, STORAGE_FILESYSTEM, STORAGE_WARC}, c.StorageBackend),
+		"storage must be %q, %q or %q, got %q", STORAGE_MINIO, STORAGE_FILESYSTEM, STORAGE_WARC, c.StorageBackend)
+	check(c.StorageWorkers >= 1, "storage workers must be at least 1, got %d", c.StorageWorkers)
+	check(c.StorageQueue >= 1, "storage queue must be at least 1, got %d", c.StorageQueue)
+	check(c.MetadataBatch >= 1, "metadata batch must be at least 1, got %d", c.MetadataBatch)
+	check(c.MetadataFlushInterval > 0, "metadata flush interval must be positive, got %s", c.MetadataFlushInterval)
+	check(c.StorageRetries >= 0, "storage retries must not be negative, got %d", c.StorageRetries)
+	if c.StorageBackend == STORAGE_FILESYSTEM || c.StorageBackend == STORAGE_WARC {
+		check(c.DataDir != "", "data dir must not be empty with the %s storage", c.StorageBackend)
+	}
+	if c.AdminAddr != "" {
+		host, _, err := net.SplitHostPort(c.AdminAddr)
+		check(err == nil, "admin address must be host:port or :port, got %q", c.AdminAddr)
+		// the admin api can stop the crawl, only local users may reach it without a token
+		check(err != nil || c.ClusterToken != "" || isLoopback(host),
+			"admin address %q is reachable from other hosts, set a token in CLUSTER_TOKEN or use a loopback address like 127.0.0.1:8090", c.AdminAddr)
+	}
+	if len(c.Peers) > 0 || c.Node != "" {
+		check(slices.Contains(c.Peers, c.Node), "node %q must be one of the peers %v", c.Node, c.Peers)
+		check(c.ClusterToken != "", "a distributed crawl needs a shared token in CLUSTER_TOKEN")
+		for i, peer := range c.Peers {
+			host, _, err := net.SplitHostPort(peer)
+			check(err == nil && host != "", "peer %q must be host:port", peer)
+			check(!slices.Contains(c.Peers[:i], peer), "peer %q is listed twice", peer)
+		}
+	}
+	if _, err := NewScope(c.Scope); err != nil {
+		errs = append(errs, fmt.Errorf("scope: %w", err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// readSeedFile reads one url per line, skipping empty lines and # comments
+func readSeedFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	links := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		links = append(links, line)
+	}
+	return links, scanner.Err()
+}
+
+func setString(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+func setInt(dst *int, value *int) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+// isLoopback reports whether the host of an address only accepts local connections, an empty host listens on every interface
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
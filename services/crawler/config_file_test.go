@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadConfigSeeds(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	seedFile := write("seeds.txt", "# physics\nhttps://example.com/a\n\nhttps://example.com/b\n")
+	withSeeds := write("seeds.yaml", "seeds: [https://example.com/c]\nseed_files: ["+seedFile+"]\n")
+	filesOnly := write("files.yaml", "seed_files: ["+seedFile+"]\n")
+	seedsOnly := write("only.yaml", "seeds: [https://example.com/c]\n")
+	defaults := NewConfig().StartLinks
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"defaults", nil, defaults},
+		{"seed flag", []string{"-seed", "https://example.com/c"}, []string{"https://example.com/c"}},
+		{"seeds file flag replaces the defaults", []string{"-seeds-file", seedFile}, []string{"https://example.com/a", "https://example.com/b"}},
+		{"seed and seeds file flags", []string{"-seed", "https://example.com/c", "-seeds-file", seedFile}, []string{"https://example.com/c", "https://example.com/a", "https://example.com/b"}},
+		{"seed files of the config replace the defaults", []string{"-config", filesOnly}, []string{"https://example.com/a", "https://example.com/b"}},
+		{"seeds and seed files of the config", []string{"-config", withSeeds}, []string{"https://example.com/c", "https://example.com/a", "https://example.com/b"}},
+		{"seeds file flag replaces the seeds of the config", []string{"-config", seedsOnly, "-seeds-file", seedFile}, []string{"https://example.com/a", "https://example.com/b"}},
+	}
+	for _, test := range tests {
+		config, err := LoadConfig(test.args)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !slices.Equal(config.StartLinks, test.want) {
+			t.Errorf("%s: seeds = %v, want %v", test.name, config.StartLinks, test.want)
+		}
+	}
+}
+
+func TestValidateAdminAddr(t *testing.T) {
+	tests := []struct {
+		addr  string
+		token string
+		valid bool
+	}{
+		{"", "", true},
+		{"127.0.0.1:8090", "", true},
+		{"localhost:8090", "", true},
+		{"[::1]:8090", "", true},
+		{":8090", "", false},
+		{"0.0.0.0:8090", "", false},
+		{"10.0.0.5:8090", "", false},
+		{":8090", "secret", true},
+		{"8090", "secret", false},
+	}
+	for _, test := range tests {
+		config := NewConfig()
+		config.AdminAddr = test.addr
+		config.ClusterToken = test.token
+		if err := config.Validate(); (err == nil) != test.valid {
+			t.Errorf("admin address %q with token %q: %v, want valid %v", test.addr, test.token, err, test.valid)
+		}
+	}
+}
@@ -1,20 +1,36 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Job struct {
 	URL   string
 	Depth int
+	// number of failed fetches so far, the job is retried after transient errors
+	Attempt int `json:",omitempty"`
+	// set by the crawl strategy, jobs with higher priority are fetched first
+	Priority float64 `json:",omitempty"`
+	// seed the job was discovered from, its subtree shares the per seed budget
+	Seed string `json:",omitempty"`
+	// text of the link the job was discovered from, only used to prioritize it
+	Anchor string `json:"-"`
 }
 
 type DocMetadata struct {
-	URL            string
+	// canonical url of the page
+	URL string
+	// url of the job, the url after following redirects and the redirect chain in between
+	RequestedURL   string
+	FinalURL       string
+	Redirects      []string
 	Depth          int
 	Title          string
 	Hash           string
@@ -22,71 +38,257 @@ type DocMetadata struct {
 	CrawledAt      time.Time
 	FirstParagraph string
 	Images         []string
+	// validators sent with conditional requests when the page is recrawled
+	ETag         string
+	LastModified string
+	// recrawl schedule, the interval shrinks when the page changes and grows when it doesn't
+	CheckedAt       time.Time
+	NextCrawlAt     time.Time
+	RecrawlInterval time.Duration
+	ChangeCount     int
+	// SimHash of the text, hex encoded, and the near duplicate cluster of the page.
+	// The cluster id is the hash of its first page, DuplicateOf is empty for that page.
+	SimHash     string
+	ClusterID   string
+	DuplicateOf string
+	// distinct pages this page links to, and pages of the crawl linking to it
+	OutLinks int
+	InLinks  int
+	// structure of a Wikipedia article, ModifiedAt is the last edit of the article
+	ShortDescription string
+	Sections         []Section
+	Infobox          []InfoboxField
+	Categories       []string
+	Coordinates      *Coordinates
+	ModifiedAt       time.Time
+	Disambiguation   bool
+	// similarity of the page to the topic of a focused crawl
+	Relevance float64 `json:",omitempty"`
+	// encoding of the stored html, like gzip, empty when it is stored as is
+	ContentEncoding string `json:",omitempty"`
 }
 
 /*
 storage: interface to save the html and metadata
 config: config to configure the crawler
-wg: wait group to wait for all workers to finish
-jobs: channel to send jobs to the workers
-skippedJobs: struct to store jobs that were skipped
+wg: wait group to wait for all discovered jobs to be processed
+frontier: unbounded queue of jobs waiting to be dispatched, spills to disk
 visited: struct to store visited urls
+fetcher: downloads the pages
+fetchLog: records every fetch attempt
+robots: robots.txt cache used to drop disallowed jobs
+scheduler: per-host queues that enforce politeness between the frontier and the workers
+state: persisted frontier and visited set, used to resume the crawl
+checkpoint: progress of the crawl, saved periodically to the state
+scope: rules deciding which links are followed
+duplicates: fingerprints of the stored pages, nil when near duplicates aren't detected
+strategy: sets the priority of new jobs, which decides the crawl order
+links: link graph of the stored pages
+budgets: stored pages per host and per seed, jobs over their budget are skipped
+cluster: instances sharing the crawl, nil when this instance crawls alone
+
+active: jobs added and not processed yet, guarded by mu like paused and hostPages.
+The crawl ends when it drops to 0, seeds can't be added after that.
+paused: workers wait before taking the next job
+stopping: a stop was requested, jobs that weren't fetched yet stay pending
+stopReason: what ended the crawl, the first budget or stop request wins
+held: an extra active job keeps a distributed crawl running until the cluster is done
+hostPages: stored pages per host
 */
 type Crawler struct {
-	storage     Storage
-	config      *Config
-	wg          *sync.WaitGroup
-	jobs        chan Job
-	skippedJobs SkippedJobs
-	visited     *Visited
-}
-
-// NewCrawler creates a new crawler with the given storage and config
-func NewCrawler(storage Storage, config *Config) *Crawler {
-	return &Crawler{
-		storage: storage,
-		config:  config,
-		wg:      &sync.WaitGroup{},
-		jobs:    make(chan Job, config.JobsBuffer),
-		skippedJobs: SkippedJobs{
-			skipped: make([]Job, 0),
-		},
-		visited: NewVisited(),
-	}
-}
-
-// Start starts the crawler
-func (c *Crawler) Start() error {
+	storage    Storage
+	config     *Config
+	wg         *sync.WaitGroup
+	frontier   *Frontier
+	visited    *Visited
+	fetcher    *Fetcher
+	fetchLog   *FetchLogger
+	robots     *Robots
+	scheduler  *Scheduler
+	state      *CrawlState
+	checkpoint Checkpoint
+	scope      *Scope
+	duplicates *NearDuplicates
+	strategy   Strategy
+	links      LinkStore
+	cluster    *Cluster
+	budgets    *Budgets
+	startedAt  time.Time
+	pages      atomic.Int64
+	bytes      atomic.Int64
+	mu         sync.Mutex
+	resumed    *sync.Cond
+	active     int
+	paused     bool
+	held       bool
+	stopping   atomic.Bool
+	stopReason string
+	inFlight   atomic.Int64
+	errors     atomic.Int64
+	lostJobs   atomic.Int64
+	hostPages  map[string]int64
+}
+
+// NewCrawler creates a new crawler with the given storage, config, crawl state, scope and link store
+func NewCrawler(storage Storage, config *Config, state *CrawlState, scope *Scope, links LinkStore) *Crawler {
+	fetcher := NewFetcher(config)
+	robots := NewRobots(fetcher.Client(), config.UserAgent)
+	crawler := &Crawler{
+		storage:   storage,
+		config:    config,
+		wg:        &sync.WaitGroup{},
+		frontier:  NewFrontier(state, config.JobsBuffer),
+		visited:   NewVisited(),
+		fetcher:   fetcher,
+		fetchLog:  NewFetchLogger(storage, config.CrawlID),
+		robots:    robots,
+		scheduler: NewScheduler(config, robots),
+		state:     state,
+		scope:     scope,
+		strategy:  NewStrategy(config),
+		links:     links,
+		budgets:   NewBudgets(config),
+		hostPages: make(map[string]int64),
+		startedAt: time.Now(),
+	}
+	crawler.resumed = sync.NewCond(&crawler.mu)
+	// lost jobs are still pending in the crawl state, they are done for this run
+	crawler.frontier.lost = func(n int) {
+		crawler.lostJobs.Add(int64(n))
+		for range n {
+			crawler.jobDone()
+		}
+	}
+	if config.NearDuplicates != DUPLICATES_OFF {
+		crawler.duplicates = NewNearDuplicates(config.NearDuplicateDistance)
+	}
+	if len(config.Peers) > 0 {
+		crawler.cluster = NewCluster(config)
+		crawler.cluster.delivered = func(jobs []Job) {
+			err := state.RemovePending(jobs)
+			if err != nil {
+				fmt.Println("Error removing delivered jobs from the crawl state", err)
+			}
+		}
+	}
+	return crawler
+}
+
+// Start starts the crawler, canceling ctx stops it like Stop
+func (c *Crawler) Start(ctx context.Context) error {
 	// c.storage.CreateHTMLDirectory(c.config.PagesDir)
 	c.storage.CreateMetadataDirectory(c.config.MetadataDir)
 	t := time.Now()
 
-	// Start workers
-	for i := 0; i < c.config.NumWorkers; i++ {
-		go c.worker(i)
+	// resume from the saved state or seed a new crawl
+	seeds, err := c.restore()
+	if err != nil {
+		return err
+	}
+	// pages stored by earlier runs count as originals too
+	if c.duplicates != nil {
+		docs, err := c.storage.ListMetadata()
+		if err != nil {
+			return err
+		}
+		c.duplicates.Load(docs)
+		fmt.Println("Loaded", c.duplicates.Count(), "fingerprints")
 	}
 
-	// Seed initial jobs
-	for _, link := range c.config.StartLinks {
-		c.wg.Add(1)
-		c.jobs <- Job{URL: link, Depth: 0}
+	// a crawl resumed with the budget it used up ends at once
+	if reason := c.budgetReached(); reason != "" {
+		c.stopWith(reason)
+	}
+	if c.config.MaxDuration > 0 {
+		timer := time.AfterFunc(c.config.MaxDuration-time.Since(c.startedAt), func() {
+			c.stopWith(STOP_MAX_DURATION)
+		})
+		defer timer.Stop()
 	}
 
-	// wait for all jobs to be processed
-	c.wg.Wait()
+	// Start the dispatcher, the workers and the checkpoints
+	go c.dispatch()
+	for i := 0; i < c.config.NumWorkers; i++ {
+		go c.worker(ctx, i)
+	}
+	stopCheckpoints := make(chan struct{})
+	var loops sync.WaitGroup
+	loops.Add(1)
+	go func() {
+		defer loops.Done()
+		c.checkpointLoop(stopCheckpoints)
+	}()
+	// an idle instance waits for jobs from its peers
+	if c.cluster != nil {
+		c.hold()
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			c.clusterLoop(stopCheckpoints)
+		}()
+	}
+
+	// a shutdown cancels the fetches in progress and keeps the other jobs pending
+	go func() {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Shutting down, waiting for", c.inFlight.Load(), "jobs in progress")
+			c.stopWith(STOP_SHUTDOWN)
+		case <-stopCheckpoints:
+		}
+	}()
 
-	// process skipped jobs
-	c.processSkippedJobs()
+	// Seed initial jobs
+	c.addJobs(seeds)
 
-	// wait for all remaining jobs to be processed
+	// wait for all jobs to be processed, then stop the dispatcher and the workers
 	c.wg.Wait()
-	close(c.jobs)
+	c.frontier.Close()
+	// the loops write the checkpoint and use the cluster, they are done before the final checkpoint
+	close(stopCheckpoints)
+	loops.Wait()
+	if c.cluster != nil {
+		c.cluster.Close()
+	}
 
 	// flush metadata
-	err := c.storage.FlushMetadata()
+	err = c.storage.FlushMetadata()
 	if err != nil {
 		fmt.Println("Error flushing metadata", err)
 	}
+	err = c.fetchLog.Flush()
+	if err != nil {
+		fmt.Println("Error saving fetch log", err)
+	}
+	err = c.links.Flush()
+	if err != nil {
+		fmt.Println("Error saving links", err)
+	}
+	err = c.updateInLinks()
+	if err != nil {
+		fmt.Println("Error updating in-link counts", err)
+	}
+
+	// mark the crawl as finished so it isn't resumed, unless a budget or a stop ended it
+	c.mu.Lock()
+	reason := c.stopReason
+	c.mu.Unlock()
+	if reason == "" {
+		reason = STOP_FINISHED
+		// jobs over a host or seed budget are still pending, a larger budget continues the crawl
+		if c.budgets.Skipped() > 0 {
+			reason = STOP_PAGE_BUDGETS
+		}
+		// the lost jobs are restored from the pending jobs when the crawl is resumed
+		if c.lostJobs.Load() > 0 {
+			reason = STOP_LOST_JOBS
+		}
+	}
+	c.checkpoint.StopReason = reason
+	if reason != STOP_FINISHED {
+		fmt.Println("Crawl ended by", reason+", reuse crawl id", c.config.CrawlID, "to continue")
+	}
+	c.saveCheckpoint(reason == STOP_FINISHED)
 
 	// print results
 	c.printResults()
@@ -95,26 +297,228 @@ func (c *Crawler) Start() error {
 	return nil
 }
 
+// dispatch moves jobs from the frontier into the scheduler,
+// dropping visited urls and urls that robots.txt doesn't allow
+func (c *Crawler) dispatch() {
+	for {
+		job, ok := c.frontier.Pop()
+		if !ok {
+			break
+		}
+		// after a stop the remaining jobs stay pending for the next run
+		if c.stopping.Load() {
+			c.jobDone()
+			continue
+		}
+		// check if already visited and mark as visited
+		if c.visited.CheckAndMark(job.URL) {
+			c.jobDone()
+			continue
+		}
+		if !c.robotsAllows(job) {
+			continue
+		}
+		c.scheduler.Add(job)
+	}
+	c.scheduler.Close()
+}
+
 // worker is a worker that processes jobs
-func (c *Crawler) worker(id int) {
-	for job := range c.jobs {
-		fmt.Println("Worker", id, "processing job", job.URL, "depth", job.Depth)
-		c.processJob(job)
-		c.wg.Done()
+func (c *Crawler) worker(ctx context.Context, id int) {
+	for {
+		c.waitWhilePaused()
+		job, ok := c.scheduler.Next()
+		if !ok {
+			return
+		}
+		// once the crawl is stopped the remaining jobs stay pending for the next run
+		if c.stopping.Load() {
+			c.scheduler.Done(job, errStopped)
+			c.jobDone()
+			continue
+		}
+		// a job waiting for an unreachable robots.txt comes back here without passing dispatch
+		if !c.robotsAllows(job) {
+			c.scheduler.Done(job, errDisallowed)
+			continue
+		}
+		// reserved here and not when the job is queued, the pages stored in between count.
+		// A job over budget stays pending in the crawl state, so it is fetched if the crawl
+		// is resumed with a larger budget.
+		if !c.budgets.Reserve(job) {
+			c.scheduler.Done(job, errOverBudget)
+			c.jobDone()
+			continue
+		}
+		fmt.Println("Worker", id, "processing job", job.URL, "depth", job.Depth, "priority", job.Priority)
+		c.inFlight.Add(1)
+		result := c.processJob(ctx, job)
+		c.inFlight.Add(-1)
+		// a stored page took the reservation, any other result gives it back
+		c.budgets.Release(job)
+		switch result {
+		case JOB_DONE:
+			c.complete(job)
+		case JOB_INTERRUPTED:
+			// still pending in the crawl state, the next run fetches it
+			c.jobDone()
+		case JOB_RETRIED:
+			// a job that is retried later isn't done yet
+		}
 	}
 }
 
-// processJob processes a job
-func (c *Crawler) processJob(job Job) {
-	// check if already visited
-	if c.visited.IsVisited(job.URL) {
-		return
+// what processJob did with a job
+const (
+	JOB_DONE = iota
+	JOB_RETRIED
+	// the fetch was canceled by a shutdown
+	JOB_INTERRUPTED
+)
+
+// errStopped releases a job that was never fetched
+var errStopped = errors.New("crawl stopped")
+
+// errDisallowed releases a job that robots.txt doesn't allow yet
+var errDisallowed = errors.New("disallowed by robots.txt")
+
+// errOverBudget releases a job whose host, seed or crawl used up its page budget
+var errOverBudget = errors.New("page budget reached")
+
+// errNotRunning rejects seeds once the crawl ended or is stopping
+var errNotRunning = errors.New("crawl is not running")
+
+// addJobs counts the jobs as unfinished and queues them
+func (c *Crawler) addJobs(jobs []Job) {
+	c.mu.Lock()
+	c.active += len(jobs)
+	c.wg.Add(len(jobs))
+	c.mu.Unlock()
+	c.frontier.PushAll(jobs)
+}
+
+// jobDone marks a job as processed, the crawl ends with the last one
+func (c *Crawler) jobDone() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.wg.Done()
+}
+
+// AddSeeds adds start urls to a running crawl, it returns the number of new jobs.
+// Urls that were already crawled are skipped.
+func (c *Crawler) AddSeeds(links []string) (int, error) {
+	seeds := make([]Job, 0, len(links))
+	for _, link := range links {
+		canonical, err := c.scope.ResolveLink(nil, link)
+		if err != nil {
+			return 0, fmt.Errorf("seed %q: %w", link, err)
+		}
+		if c.visited.IsVisited(canonical) {
+			continue
+		}
+		seeds = append(seeds, Job{URL: canonical, Depth: 0, Seed: canonical})
 	}
-	// mark as visited
-	if c.visited.CheckAndMark(job.URL) {
-		return
+	c.strategy.Seed(seeds)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the crawl ends once there are no jobs left, it can't be restarted from here
+	if c.active == 0 || c.stopping.Load() {
+		return 0, errNotRunning
+	}
+	// seeds of other instances stay pending until their owner has them
+	err := c.state.AddPending(seeds)
+	if err != nil {
+		return 0, err
+	}
+	local := c.route(seeds)
+	c.active += len(local)
+	c.wg.Add(len(local))
+	c.frontier.PushAll(local)
+	return len(seeds), nil
+}
+
+// Pause makes the workers wait after their current job
+func (c *Crawler) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+}
+
+// Resume lets paused workers continue
+func (c *Crawler) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+	c.resumed.Broadcast()
+}
+
+// Stop ends the crawl after the jobs being fetched, the others stay pending
+// and Start returns once everything is saved and checkpointed
+func (c *Crawler) Stop() {
+	c.stopWith(STOP_REQUESTED)
+}
+
+// stopWith stops the crawl and records why, when it wasn't stopped before
+func (c *Crawler) stopWith(reason string) {
+	c.mu.Lock()
+	if c.stopReason == "" {
+		c.stopReason = reason
+		fmt.Println("Stopping the crawl:", reason)
+	}
+	c.mu.Unlock()
+	c.stopping.Store(true)
+	c.Resume()
+	c.release()
+	// queued jobs are released at once instead of waiting for their hosts
+	for range c.scheduler.Drain() {
+		c.jobDone()
+	}
+}
+
+// waitWhilePaused blocks while the crawl is paused and not stopping
+func (c *Crawler) waitWhilePaused() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.paused && !c.stopping.Load() {
+		c.resumed.Wait()
+	}
+}
+
+// robotsAllows reports whether robots.txt allows the job. A job of a host whose robots.txt
+// can't be reached waits for the next download, a disallowed job is dropped without
+// becoming visited, so it is crawled if robots.txt allows it in a later crawl.
+func (c *Crawler) robotsAllows(job Job) bool {
+	allowed, retryIn := c.robots.Check(job.URL)
+	if allowed {
+		return true
+	}
+	if retryIn > 0 {
+		c.scheduler.Retry(job, retryIn)
+		return false
+	}
+	fmt.Println("Skipping job disallowed by robots.txt", job.URL)
+	err := c.state.RemovePending([]Job{job})
+	if err != nil {
+		fmt.Println("Error saving crawl state", err)
+	}
+	c.jobDone()
+	return false
+}
+
+// complete marks the job as processed in the crawl state
+func (c *Crawler) complete(job Job) {
+	err := c.state.Complete(job)
+	if err != nil {
+		fmt.Println("Error saving crawl state", err)
 	}
+	c.jobDone()
+}
 
+// processJob processes a job, it returns JOB_RETRIED if the job was scheduled for a retry
+// and JOB_INTERRUPTED if ctx was canceled while fetching it or its page is over the byte budget
+func (c *Crawler) processJob(ctx context.Context, job Job) int {
 	docMetadata := DocMetadata{
 		URL:            job.URL,
 		Depth:          job.Depth,
@@ -125,78 +529,342 @@ func (c *Crawler) processJob(job Job) {
 		Images:         []string{},
 	}
 	// get the html
-	body, err := fetch(job.URL)
+	start := time.Now()
+	result, err := c.fetcher.Fetch(ctx, job.URL, "", "")
+	c.scheduler.Done(job, err)
+	if err != nil && ctx.Err() != nil {
+		fmt.Println("Interrupted fetching", job.URL)
+		return JOB_INTERRUPTED
+	}
+	c.fetchLog.Record(NewFetchLog(job, result, err, start))
 	if err != nil {
+		if delay, ok := c.fetcher.RetryDelay(job, err); ok {
+			fmt.Println("Retrying", job.URL, "in", delay, "after", err)
+			job.Attempt++
+			c.scheduler.Retry(job, delay)
+			return JOB_RETRIED
+		}
 		fmt.Println("Error getting HTML from", job.URL, err)
-		return
+		c.errors.Add(1)
+		return JOB_DONE
+	}
+	body := result.Body
+
+	// the page may live under another url after redirects
+	docMetadata.RequestedURL = job.URL
+	docMetadata.FinalURL = job.URL
+	docMetadata.Redirects = result.Redirects
+	if len(result.Redirects) > 0 {
+		finalURL, err := c.scope.ResolveLink(nil, result.FinalURL)
+		if err != nil {
+			fmt.Println("Skipping", job.URL, "redirected out of scope:", err)
+			return JOB_DONE
+		}
+		if !c.claimURL(job.URL, finalURL) {
+			fmt.Println("Skipping", job.URL, "already crawled as", finalURL)
+			return JOB_DONE
+		}
+		docMetadata.FinalURL = finalURL
+		docMetadata.URL = finalURL
 	}
 
+	// validators of the response, set before the links are extracted since
+	// extractStructure falls back to LastModified for the last edit of the page
+	docMetadata.ETag = result.ETag
+	docMetadata.LastModified = result.LastModified
 	// extract the links from the html
-	links := extractLinks(body, &docMetadata)
+	links, text, images := extractLinks(body, &docMetadata, c.scope)
+	// <link rel="canonical"> may name another url for the same page
+	if !c.claimURL(docMetadata.FinalURL, docMetadata.URL) {
+		fmt.Println("Skipping", job.URL, "already crawled as", docMetadata.URL)
+		return JOB_DONE
+	}
 	docMetadata.ContentLength = len(body)
 	docMetadata.CrawledAt = time.Now()
-	// add new jobs to the queue
+	docMetadata.CheckedAt = docMetadata.CrawledAt
+	docMetadata.RecrawlInterval = c.config.RecrawlInterval
+	docMetadata.NextCrawlAt = docMetadata.CrawledAt.Add(c.config.RecrawlInterval)
+	// a focused crawl only follows the links of pages about its topic
+	follow := true
+	if focused, ok := c.strategy.(*focusedStrategy); ok {
+		docMetadata.Relevance, follow = focused.Score(job, text)
+		if !follow {
+			fmt.Println("Not following links of", job.URL, "relevance", docMetadata.Relevance)
+		}
+	}
+	// collect the new jobs
+	newJobs := make([]Job, 0, len(links))
 	for _, link := range links {
-		newJob := Job{URL: link, Depth: job.Depth + 1}
+		if !follow {
+			break
+		}
+		newJob := Job{URL: link.URL, Depth: job.Depth + 1, Seed: job.Seed, Anchor: link.Anchor}
 		// check if depth is too high
-		if newJob.Depth > c.config.MaxDepth {
+		if newJob.Depth > c.config.MaxDepth || !c.scope.AllowsDepth(newJob.URL, newJob.Depth) {
 			continue
 		}
-
-		select {
-		case c.jobs <- newJob:
-			c.wg.Add(1)
-		default:
-			c.skippedJobs.Add(newJob)
+		if c.visited.IsVisited(newJob.URL) || !c.budgets.Allows(newJob) {
+			continue
 		}
+		newJobs = append(newJobs, newJob)
 	}
+	c.strategy.Prioritize(job, text, newJobs)
+
+	// persist them before queueing so they survive a restart, the jobs
+	// of other instances stay pending until their owner has them
+	err = c.state.AddPending(newJobs)
+	if err != nil {
+		fmt.Println("Error saving crawl state", err)
+	}
+	newJobs = c.route(newJobs)
+
+	// add new jobs to the queue
+	c.addJobs(newJobs)
+
 	hash := sha256.Sum256(body)
 	hashString := hex.EncodeToString(hash[:])
+	docMetadata.Hash = hashString
+
+	// the same text under other chrome is stored once, or marked as a copy
+	if c.duplicates != nil {
+		originalHash, originalURL, duplicate := c.duplicates.Check(&docMetadata, text)
+		// metadata is stored by hash, an exact copy would replace the original
+		if duplicate && (c.config.NearDuplicates == DUPLICATES_SKIP || originalHash == hashString) {
+			fmt.Println("Skipping", docMetadata.URL, "near duplicate of", originalURL)
+			return JOB_DONE
+		}
+		if duplicate {
+			fmt.Println("Marking", docMetadata.URL, "as near duplicate of", originalURL)
+		}
+	}
 
+	// the page is counted before it is stored, so the pages stored at the same time can't exceed
+	// MaxBytes. A page that doesn't fit stays pending for a run with a larger budget.
+	if !c.reserveBytes(len(body)) {
+		fmt.Println("Not storing", docMetadata.URL, "over the byte budget")
+		c.stopWith(STOP_MAX_BYTES)
+		return JOB_INTERRUPTED
+	}
 	// save the html
 	err = c.storage.SaveHTML(hashString, body)
 	if err != nil {
 		fmt.Println("Error saving HTML", err)
-		return
+		c.bytes.Add(-int64(len(body)))
+		c.errors.Add(1)
+		return JOB_DONE
 	}
 
-	// save metadata
-	docMetadata.Hash = hashString
+	err = c.storage.SaveImages(hashString, withPage(images, docMetadata))
+	if err != nil {
+		fmt.Println("Error saving images", err)
+	}
+	// save the link graph, then the metadata with the link counts
+	docMetadata.OutLinks, docMetadata.InLinks = c.saveLinks(docMetadata, links)
 	err = c.storage.SaveMetadata(docMetadata)
 	if err != nil {
 		fmt.Println("Error saving metadata", err)
+	} else if c.duplicates != nil {
+		// only stored pages are matched, a page that failed to save isn't an original
+		c.duplicates.Add(docMetadata)
+	}
+	// the in-link count is updated at the end of the crawl if more links to the page are found
+	err = c.state.SaveStored(map[string]StoredPage{docMetadata.URL: {Hash: hashString, InLinks: docMetadata.InLinks}})
+	if err != nil {
+		fmt.Println("Error saving crawl state", err)
+	}
+	c.pages.Add(1)
+	host := hostOf(docMetadata.URL)
+	c.budgets.Add(job, host)
+	err = c.state.AddPage(host, job.Seed)
+	if err != nil {
+		fmt.Println("Error counting page", err)
+	}
+	c.mu.Lock()
+	c.hostPages[host]++
+	c.mu.Unlock()
+	if reason := c.budgetReached(); reason != "" {
+		c.stopWith(reason)
 	}
+	return JOB_DONE
 }
 
-// processSkippedJobs processes skipped jobs
-func (c *Crawler) processSkippedJobs() {
-	rounds := 0
-	for rounds < c.config.MaxRounds {
-		fmt.Printf("Waiting for round %d jobs to complete...\n", rounds+1)
-		c.wg.Wait()
-
-		if c.skippedJobs.IsEmpty() {
-			fmt.Println("No more skipped jobs, finishing...")
-			break
+// saveLinks saves the edges of the page and counts them as in-links of their targets,
+// it returns the number of distinct targets and the in-links of the page found so far
+func (c *Crawler) saveLinks(docMetadata DocMetadata, links []Outlink) (int, int) {
+	edges := make([]Link, 0, len(links))
+	seen := make(map[Outlink]bool)
+	targets := make([]string, 0, len(links))
+	counted := make(map[string]bool)
+	for _, link := range links {
+		if link.URL == docMetadata.URL || seen[link] {
+			continue
 		}
+		seen[link] = true
+		edges = append(edges, Link{SourceHash: docMetadata.Hash, SourceURL: docMetadata.URL, TargetURL: link.URL, Anchor: link.Anchor})
+		if !counted[link.URL] {
+			counted[link.URL] = true
+			targets = append(targets, link.URL)
+		}
+	}
 
-		prevSkippedJobs := c.skippedJobs.GetAllAndClear()
-		rounds++
-		fmt.Printf("Round %d: Processing %d skipped jobs\n", rounds, len(prevSkippedJobs))
-
-		processed := 0
-		for _, job := range prevSkippedJobs {
-			// Try to add to jobs
-			select {
-			case c.jobs <- job:
-				c.wg.Add(1)
-				processed++
-			default:
-				// Still can't send, add back to skipped
-				c.skippedJobs.Add(job)
+	err := c.links.SaveLinks(edges)
+	if err != nil {
+		fmt.Println("Error saving links", err)
+	}
+	err = c.state.AddInLinks(targets)
+	if err != nil {
+		fmt.Println("Error counting in-links", err)
+	}
+	inLinks, err := c.state.InLinks(docMetadata.URL)
+	if err != nil {
+		fmt.Println("Error reading in-links", err)
+	}
+	return len(targets), inLinks
+}
+
+// updateInLinks saves the final in-link counts of the pages of the crawl,
+// links found after a page was stored aren't in its metadata yet.
+// Only the pages whose count changed are written.
+func (c *Crawler) updateInLinks() error {
+	stale, err := c.state.StaleInLinks()
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int, len(stale))
+	for _, page := range stale {
+		counts[page.Hash] = page.InLinks
+	}
+	err = c.storage.UpdateInLinks(counts)
+	if err == nil {
+		err = c.storage.FlushMetadata()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Updated in-link counts of", len(stale), "pages")
+	return c.state.SaveStored(stale)
+}
+
+// claimURL marks alias as visited when a page fetched as url turns out to be alias,
+// it returns false when alias was already crawled by another job
+func (c *Crawler) claimURL(url string, alias string) bool {
+	if alias == url {
+		return true
+	}
+	if c.visited.CheckAndMark(alias) {
+		return false
+	}
+	err := c.state.Complete(Job{URL: alias})
+	if err != nil {
+		fmt.Println("Error saving crawl state", err)
+	}
+	return true
+}
+
+// restore loads the visited set and pending jobs of an interrupted crawl,
+// or seeds a new crawl from the start links
+func (c *Crawler) restore() ([]Job, error) {
+	checkpoint, found, err := c.state.LoadCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		seeds := make([]Job, 0, len(c.config.StartLinks))
+		for _, link := range c.config.StartLinks {
+			canonical, err := Canonicalize(link)
+			if err != nil {
+				fmt.Println("Skipping invalid start link", link, err)
+				continue
 			}
+			seeds = append(seeds, Job{URL: canonical, Depth: 0, Seed: canonical})
 		}
-		fmt.Printf("Round %d: Successfully queued %d jobs\n", rounds, processed)
+		c.strategy.Seed(seeds)
+		seeds = c.owned(seeds)
+		err = c.state.AddPending(seeds)
+		if err != nil {
+			return nil, err
+		}
+		// keep the effective config next to the state of the crawl
+		err = c.state.SaveConfig(c.config)
+		if err != nil {
+			return nil, err
+		}
+		c.checkpoint = Checkpoint{StartedAt: time.Now()}
+		return seeds, c.state.SaveCheckpoint(c.checkpoint)
+	}
+
+	c.checkpoint = checkpoint
+	c.checkpoint.StopReason = ""
+	c.pages.Store(int64(checkpoint.Pages))
+	c.bytes.Store(checkpoint.Bytes)
+	hosts, seeds, err := c.state.PageCounts()
+	if err != nil {
+		return nil, err
+	}
+	c.budgets.Load(checkpoint.Pages, hosts, seeds)
+	if focused, ok := c.strategy.(*focusedStrategy); ok {
+		profiles, err := c.state.LoadTopic()
+		if err != nil {
+			return nil, err
+		}
+		focused.LoadProfiles(profiles)
+	}
+	if checkpoint.Finished {
+		fmt.Println("Crawl", checkpoint.CrawlID, "already finished")
+		return []Job{}, nil
+	}
+
+	visited, err := c.state.VisitedURLs()
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range visited {
+		c.visited.Add(url)
+	}
+	pending, err := c.state.PendingJobs()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Resuming crawl", checkpoint.CrawlID, "with", len(visited), "visited and", len(pending), "pending urls")
+	// hosts move to another instance when the peers change
+	local := c.route(pending)
+	if len(local) < len(pending) {
+		fmt.Println("Sending", len(pending)-len(local), "pending urls to the peers that own them")
+	}
+	return local, nil
+}
+
+// checkpointLoop saves a checkpoint every CheckpointInterval until stop is closed
+func (c *Crawler) checkpointLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.config.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.saveCheckpoint(false)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// saveCheckpoint saves the progress of the crawl
+func (c *Crawler) saveCheckpoint(finished bool) {
+	c.checkpoint.Visited = c.visited.Count()
+	c.checkpoint.Queued = c.frontier.Len()
+	c.checkpoint.Pages = int(c.pages.Load())
+	c.checkpoint.Bytes = c.bytes.Load()
+	c.checkpoint.Finished = finished
+	if focused, ok := c.strategy.(*focusedStrategy); ok {
+		err := c.state.SaveTopic(focused.Profiles())
+		if err != nil {
+			fmt.Println("Error saving topic", err)
+		}
+	}
+	err := c.state.SaveCheckpoint(c.checkpoint)
+	if err != nil {
+		fmt.Println("Error saving checkpoint", err)
 	}
 }
 
@@ -204,5 +872,4 @@ func (c *Crawler) processSkippedJobs() {
 func (c *Crawler) printResults() {
 	fmt.Println("\n\n--------------------------------")
 	fmt.Println("Visited URLs:", len(c.visited.GetVisited()))
-	fmt.Println("Skipped jobs:", c.skippedJobs.Count())
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShutdownSavesPendingJobs(t *testing.T) {
+	// every page links to three more and to a page that answers once the request is canceled
+	slowStarted := make(chan struct{}, 1)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case slowStarted <- struct{}{}:
+			default:
+			}
+			<-r.Context().Done()
+			return
+		}
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/page/%d", &n); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head><title>Page %d</title></head><body><div id="mw-content-text"><p>page number %d</p>
+			<a href="/slow">slow</a><a href="/page/%d">a</a><a href="/page/%d">b</a><a href="/page/%d">c</a></div></body></html>`,
+			n, n, 3*n+1, 3*n+2, 3*n+3)
+	}))
+	defer site.Close()
+
+	config := NewConfig()
+	config.StartLinks = []string{site.URL + "/page/0"}
+	config.MaxDepth = 1000
+	config.HostDelay = 5 * time.Millisecond
+	config.NumWorkers = 2
+	config.Scope.AllowedHosts = []string{"127.0.0.1"}
+	config.Scope.Schemes = []string{"http"}
+	config.Scope.Include = nil
+	scope, err := NewScope(config.Scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	storage, err := NewFilesystemStorage(dir, config.PagesDir, config.MetadataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := NewFileLinkStore(dir, config.CrawlID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer links.Close()
+	stateDir := filepath.Join(dir, "state")
+	state, err := OpenCrawlState(stateDir, config.CrawlID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crawler := NewCrawler(storage, config, state, scope, links)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- crawler.Start(ctx)
+	}()
+	// shut down while the slow page is being fetched
+	select {
+	case <-slowStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow page wasn't fetched")
+	}
+	waitFor(t, "a few pages", func() bool { return crawler.Stats().Pages >= 3 })
+	cancel()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawl didn't shut down")
+	}
+	pages := crawler.Stats().Pages
+	err = state.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the state is read back the way the next run reads it
+	state, err = OpenCrawlState(stateDir, config.CrawlID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	checkpoint, found, err := state.LoadCheckpoint()
+	if err != nil || !found {
+		t.Fatalf("checkpoint not saved: %v", err)
+	}
+	if checkpoint.Finished || checkpoint.StopReason != STOP_SHUTDOWN || checkpoint.Pages != int(pages) {
+		t.Errorf("checkpoint %+v, want an unfinished crawl with %d pages stopped by %s", checkpoint, pages, STOP_SHUTDOWN)
+	}
+
+	pending, err := state.PendingJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	visited, err := state.VisitedURLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	isVisited := make(map[string]bool)
+	for _, url := range visited {
+		isVisited[url] = true
+	}
+	if len(visited) < int(pages) {
+		t.Errorf("%d urls visited, want at least the %d stored pages", len(visited), pages)
+	}
+	isPending := make(map[string]bool)
+	for _, job := range pending {
+		isPending[job.URL] = true
+		if isVisited[job.URL] {
+			t.Errorf("%s is pending and visited", job.URL)
+		}
+	}
+	// the interrupted fetch and the links of the stored pages are fetched by the next run
+	if !isPending[site.URL+"/slow"] {
+		t.Error("the interrupted job isn't pending")
+	}
+	docs, err := storage.ListMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != int(pages) {
+		t.Errorf("%d pages stored, the checkpoint counts %d", len(docs), pages)
+	}
+	for _, doc := range docs {
+		var n int
+		fmt.Sscanf(doc.URL, site.URL+"/page/%d", &n)
+		for i := 3*n + 1; i <= 3*n+3; i++ {
+			url := fmt.Sprintf("%s/page/%d", site.URL, i)
+			if !isPending[url] && !isVisited[url] {
+				t.Errorf("%s was found on %s but is neither pending nor visited", url, doc.URL)
+			}
+		}
+	}
+}
+
+func TestNearDuplicateOfUnsavedPage(t *testing.T) {
+	// both pages have the same text, the first one can't be saved
+	page := func(link string) string {
+		return `<html><head><title>Fox</title></head><body><div id="mw-content-text"><p>` + ORIGINAL_TEXT + `</p>` + link + `</div></body></html>`
+	}
+	first := page(`<a href="/copy">copy</a>`)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/page/0":
+			fmt.Fprint(w, first)
+		case "/copy":
+			fmt.Fprint(w, page(""))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer site.Close()
+
+	config := NewConfig()
+	config.StartLinks = []string{site.URL + "/page/0"}
+	config.MaxDepth = 1000
+	config.HostDelay = 10 * time.Millisecond
+	config.Scope.AllowedHosts = []string{"127.0.0.1"}
+	config.Scope.Schemes = []string{"http"}
+	config.Scope.Include = nil
+	config.NearDuplicates = DUPLICATES_SKIP
+	scope, err := NewScope(config.Scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := NewFileLinkStore(t.TempDir(), config.CrawlID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer links.Close()
+	hash := sha256.Sum256([]byte(first))
+	storage := &recordingStorage{failPages: map[string]bool{hex.EncodeToString(hash[:]): true}}
+	crawler := NewCrawler(storage, config, openTestState(t), scope, links)
+	err = crawler.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the copy isn't skipped as a near duplicate of a page that was never stored
+	hash = sha256.Sum256([]byte(page("")))
+	if !slices.Contains(storage.writes, "html "+hex.EncodeToString(hash[:])) {
+		t.Errorf("copy wasn't stored, writes %v", storage.writes)
+	}
+	if crawler.duplicates.Count() != 1 {
+		t.Errorf("%d pages indexed, want the copy", crawler.duplicates.Count())
+	}
+}
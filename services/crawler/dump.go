@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// article urls of dumps without a <base> in their siteinfo
+const DUMP_BASE_URL = "https://en.wikipedia.org/wiki/"
+
+// DumpPage is a <page> of a MediaWiki XML dump
+type DumpPage struct {
+	Title    string `xml:"title"`
+	NS       int    `xml:"ns"`
+	Redirect *struct {
+		Title string `xml:"title,attr"`
+	} `xml:"redirect"`
+	Revision struct {
+		Timestamp time.Time `xml:"timestamp"`
+		Text      string    `xml:"text"`
+	} `xml:"revision"`
+}
+
+/*
+DumpIngester reads the articles of a Wikipedia XML dump and saves them like crawled pages.
+The dump is streamed page by page, so memory doesn't grow with the size of the dump.
+
+baseURL: article url prefix, taken from the siteinfo of the dump
+*/
+type DumpIngester struct {
+	storage   Storage
+	config    *Config
+	baseURL   string
+	wg        *sync.WaitGroup
+	pages     atomic.Int64
+	skipped   atomic.Int64
+	failed    atomic.Int64
+	startedAt time.Time
+}
+
+// NewDumpIngester creates an ingester that saves the articles of the configured dump to storage
+func NewDumpIngester(storage Storage, config *Config) *DumpIngester {
+	return &DumpIngester{
+		storage: storage,
+		config:  config,
+		baseURL: DUMP_BASE_URL,
+		wg:      &sync.WaitGroup{},
+	}
+}
+
+// Start streams the dump and saves every article until the dump ends, a limit is reached or ctx is canceled
+func (d *DumpIngester) Start(ctx context.Context) error {
+	d.startedAt = time.Now()
+	file, err := os.Open(d.config.DumpFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = bufio.NewReaderSize(file, 1<<20)
+	if strings.HasSuffix(d.config.DumpFile, ".bz2") {
+		reader = bzip2.NewReader(reader)
+	}
+	d.storage.CreateMetadataDirectory(d.config.MetadataDir)
+
+	// the channel is small, reading the dump waits for the workers
+	pages := make(chan DumpPage, d.config.NumWorkers)
+	for i := 0; i < d.config.NumWorkers; i++ {
+		d.wg.Add(1)
+		go d.worker(pages)
+	}
+	err = d.read(ctx, reader, pages)
+	close(pages)
+	d.wg.Wait()
+
+	flushErr := d.storage.FlushMetadata()
+	if flushErr != nil {
+		fmt.Println("Error flushing metadata", flushErr)
+	}
+
+	fmt.Println("\n\n--------------------------------")
+	fmt.Println("Articles saved:", d.pages.Load())
+	fmt.Println("Pages skipped:", d.skipped.Load())
+	fmt.Println("Failed:", d.failed.Load())
+	fmt.Println("Dump ingested in", time.Since(d.startedAt))
+	return err
+}
+
+// read decodes the pages of the dump one at a time and sends the articles to the workers
+func (d *DumpIngester) read(ctx context.Context, reader io.Reader, pages chan<- DumpPage) error {
+	decoder := xml.NewDecoder(reader)
+	sent := 0
+	for {
+		if d.limitReached(sent) {
+			fmt.Println("Dump limit reached, stopping")
+			return nil
+		}
+		// the articles sent to the workers are still saved
+		if ctx.Err() != nil {
+			fmt.Println("Shutting down, saving the articles read so far")
+			return nil
+		}
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "base":
+			// <base> is the url of the main page, articles live next to it
+			var base string
+			err = decoder.DecodeElement(&base, &start)
+			if err != nil {
+				return err
+			}
+			if i := strings.LastIndex(base, "/"); i >= 0 {
+				d.baseURL = base[:i+1]
+			}
+		case "page":
+			var page DumpPage
+			err = decoder.DecodeElement(&page, &start)
+			if err != nil {
+				return err
+			}
+			// only articles, redirects point to another page of the dump
+			if page.NS != 0 || page.Redirect != nil {
+				d.skipped.Add(1)
+				continue
+			}
+			pages <- page
+			sent++
+		}
+	}
+}
+
+// limitReached reports whether MaxPages articles were read or MaxDuration passed
+func (d *DumpIngester) limitReached(sent int) bool {
+	if d.config.MaxPages > 0 && sent >= d.config.MaxPages {
+		return true
+	}
+	return d.config.MaxDuration > 0 && time.Since(d.startedAt) >= d.config.MaxDuration
+}
+
+// worker converts articles and saves them
+func (d *DumpIngester) worker(pages <-chan DumpPage) {
+	defer d.wg.Done()
+	for page := range pages {
+		err := d.save(page)
+		if err != nil {
+			fmt.Println("Error saving article", page.Title, err)
+			d.failed.Add(1)
+			continue
+		}
+		if n := d.pages.Add(1); n%1000 == 0 {
+			fmt.Println("Saved", n, "articles")
+		}
+	}
+}
+
+// save turns the wikitext into a html page and saves it with its metadata
+func (d *DumpIngester) save(page DumpPage) error {
+	pageURL, err := Canonicalize(d.baseURL + escapeTitle(page.Title))
+	if err != nil {
+		return err
+	}
+	article := ParseWikitext(page.Revision.Text)
+	body := renderArticle(page.Title, pageURL, article)
+	images := make([]Image, 0, len(article.Images))
+	for _, image := range article.Images {
+		images = append(images, Image{URL: image.URL, Alt: image.Alt, Caption: image.Caption})
+	}
+	hash := sha256.Sum256(body)
+	hashString := hex.EncodeToString(hash[:])
+
+	err = d.storage.SaveHTML(hashString, body)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	docMetadata := DocMetadata{
+		URL:             pageURL,
+		RequestedURL:    pageURL,
+		FinalURL:        pageURL,
+		Title:           page.Title,
+		Hash:            hashString,
+		ContentLength:   len(body),
+		CrawledAt:       now,
+		FirstParagraph:  article.FirstParagraph(),
+		Images:          imageURLs(images),
+		SimHash:         Fingerprint(article.Text()),
+		ClusterID:       hashString,
+		CheckedAt:       now,
+		RecrawlInterval: d.config.RecrawlInterval,
+		NextCrawlAt:     now.Add(d.config.RecrawlInterval),
+	}
+	if !page.Revision.Timestamp.IsZero() {
+		docMetadata.LastModified = page.Revision.Timestamp.UTC().Format(http.TimeFormat)
+	}
+	// the rendered page has the markup of a live article, so the structure is read the same way
+	err = parseStructure(body, &docMetadata)
+	if err != nil {
+		return err
+	}
+	err = d.storage.SaveImages(hashString, withPage(images, docMetadata))
+	if err != nil {
+		return err
+	}
+	return d.storage.SaveMetadata(docMetadata)
+}
+
+// renderArticle writes the article as a simple html page, the indexer reads pages as html.
+// It uses the ids and classes of Wikipedia's own markup for the parts extractStructure reads.
+func renderArticle(title string, pageURL string, article WikiArticle) []byte {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(title))
+	fmt.Fprintf(&b, "<link rel=\"canonical\" href=\"%s\">\n", html.EscapeString(pageURL))
+	b.WriteString("</head>\n<body>\n")
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(title))
+	b.WriteString("<div id=\"mw-content-text\">\n")
+	if article.ShortDescription != "" {
+		fmt.Fprintf(&b, "<div class=\"shortdescription\">%s</div>\n", html.EscapeString(article.ShortDescription))
+	}
+	if article.Disambiguation {
+		b.WriteString("<div id=\"disambigbox\"></div>\n")
+	}
+	if len(article.Infobox) > 0 {
+		b.WriteString("<table class=\"infobox\">\n")
+		for _, field := range article.Infobox {
+			fmt.Fprintf(&b, "<tr><th>%s</th><td>%s</td></tr>\n", html.EscapeString(field.Key), html.EscapeString(field.Value))
+		}
+		b.WriteString("</table>\n")
+	}
+	for _, image := range article.Images {
+		img := fmt.Sprintf("<img src=\"%s\" alt=\"%s\">", html.EscapeString(image.URL), html.EscapeString(image.Alt))
+		if image.Caption == "" {
+			fmt.Fprintf(&b, "%s\n", img)
+		} else {
+			fmt.Fprintf(&b, "<figure>%s<figcaption>%s</figcaption></figure>\n", img, html.EscapeString(image.Caption))
+		}
+	}
+	for _, block := range article.Blocks {
+		if block.Heading {
+			fmt.Fprintf(&b, "<h%d id=\"%s\">%s</h%d>\n", block.Level, html.EscapeString(strings.ReplaceAll(block.Text, " ", "_")), html.EscapeString(block.Text), block.Level)
+		} else {
+			fmt.Fprintf(&b, "<p>%s</p>\n", html.EscapeString(block.Text))
+		}
+	}
+	b.WriteString("</div>\n")
+	if len(article.Categories) > 0 {
+		b.WriteString("<div id=\"catlinks\"><div id=\"mw-normal-catlinks\"><ul>\n")
+		for _, category := range article.Categories {
+			fmt.Fprintf(&b, "<li>%s</li>\n", html.EscapeString(category))
+		}
+		b.WriteString("</ul></div></div>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+	return []byte(b.String())
+}
+
+// escapeTitle turns a page title into the path of its url
+func escapeTitle(title string) string {
+	u := url.URL{Path: strings.ReplaceAll(strings.TrimSpace(title), " ", "_")}
+	return u.EscapedPath()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dumpStorage keeps the pages and metadata saved by the ingester
+type dumpStorage struct {
+	recordingStorage
+	mu     sync.Mutex
+	html   map[string][]byte
+	docs   map[string]DocMetadata
+	images map[string][]Image
+}
+
+func newDumpStorage() *dumpStorage {
+	return &dumpStorage{html: make(map[string][]byte), docs: make(map[string]DocMetadata), images: make(map[string][]Image)}
+}
+
+func (s *dumpStorage) SaveHTML(hash string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.html[hash] = body
+	return nil
+}
+
+func (s *dumpStorage) SaveMetadata(docMetadata DocMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs[docMetadata.Title] = docMetadata
+	return nil
+}
+
+func (s *dumpStorage) SaveImages(pageHash string, images []Image) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[pageHash] = images
+	return nil
+}
+
+func testDumpIngester(storage Storage, dumpFile string, maxPages int) *DumpIngester {
+	config := NewConfig()
+	config.DumpFile = dumpFile
+	config.NumWorkers = 2
+	config.MaxPages = maxPages
+	config.MaxDuration = 0
+	config.RecrawlInterval = 24 * time.Hour
+	return NewDumpIngester(storage, config)
+}
+
+func TestDumpIngesterStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		maxPages int
+		want     []string
+	}{
+		{"xml", "testdata/dump.xml", 0, []string{"Gravity", "Isaac Newton", "Mercury"}},
+		{"bz2", "testdata/dump.xml.bz2", 0, []string{"Gravity", "Isaac Newton", "Mercury"}},
+		// redirects and talk pages don't count towards the limit
+		{"max pages", "testdata/dump.xml", 2, []string{"Isaac Newton", "Mercury"}},
+		{"max pages of a bz2 dump", "testdata/dump.xml.bz2", 1, []string{"Isaac Newton"}},
+	}
+	for _, test := range tests {
+		storage := newDumpStorage()
+		d := testDumpIngester(storage, test.file, test.maxPages)
+		err := d.Start(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		titles := []string{}
+		for title := range storage.docs {
+			titles = append(titles, title)
+		}
+		slices.Sort(titles)
+		if !slices.Equal(titles, test.want) {
+			t.Errorf("%s: saved %v, want %v", test.name, titles, test.want)
+		}
+		if d.pages.Load() != int64(len(test.want)) || d.failed.Load() != 0 {
+			t.Errorf("%s: %d saved and %d failed, want %d saved", test.name, d.pages.Load(), d.failed.Load(), len(test.want))
+		}
+		if test.maxPages == 0 && d.skipped.Load() != 2 {
+			t.Errorf("%s: %d pages skipped, want the redirect and the talk page", test.name, d.skipped.Load())
+		}
+	}
+}
+
+func TestDumpIngesterMetadata(t *testing.T) {
+	storage := newDumpStorage()
+	err := testDumpIngester(storage, "testdata/dump.xml", 0).Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, ok := storage.docs["Isaac Newton"]
+	if !ok {
+		t.Fatal("article wasn't saved")
+	}
+	body := storage.html[doc.Hash]
+	hash := sha256.Sum256(body)
+	if doc.Hash != hex.EncodeToString(hash[:]) || doc.ContentLength != len(body) {
+		t.Errorf("hash %s and length %d don't match the saved page", doc.Hash, doc.ContentLength)
+	}
+	// the article url is next to the <base> of the siteinfo
+	wantURL := "https://simple.wikipedia.org/wiki/Isaac_Newton"
+	if doc.URL != wantURL || doc.RequestedURL != wantURL || doc.FinalURL != wantURL {
+		t.Errorf("urls %s, %s, %s, want %s", doc.URL, doc.RequestedURL, doc.FinalURL, wantURL)
+	}
+	if doc.FirstParagraph != "Isaac Newton was an English physicist." {
+		t.Errorf("first paragraph %q", doc.FirstParagraph)
+	}
+	if doc.ShortDescription != "English physicist" {
+		t.Errorf("short description %q", doc.ShortDescription)
+	}
+	wantInfobox := []InfoboxField{{Key: "name", Value: "Isaac Newton"}, {Key: "field", Value: "Physics"}}
+	if !slices.Equal(doc.Infobox, wantInfobox) {
+		t.Errorf("infobox %+v, want %+v", doc.Infobox, wantInfobox)
+	}
+	if !slices.Equal(doc.Categories, []string{"Physicists"}) {
+		t.Errorf("categories %v", doc.Categories)
+	}
+	if len(doc.Sections) != 1 || doc.Sections[0].Title != "Work" {
+		t.Errorf("sections %+v, want Work", doc.Sections)
+	}
+	if doc.Disambiguation {
+		t.Error("article is a disambiguation page")
+	}
+	if !storage.docs["Mercury"].Disambiguation {
+		t.Error("disambiguation page isn't marked")
+	}
+
+	// the revision timestamp is the last edit of the article
+	edited := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if doc.LastModified != "Fri, 01 Mar 2024 12:00:00 GMT" || !doc.ModifiedAt.Equal(edited) {
+		t.Errorf("last modified %q, modified at %v, want %v", doc.LastModified, doc.ModifiedAt, edited)
+	}
+	if doc.SimHash == "" || doc.ClusterID != doc.Hash || doc.RecrawlInterval != 24*time.Hour || !doc.NextCrawlAt.After(doc.CrawledAt) {
+		t.Errorf("crawl fields %+v", doc)
+	}
+
+	image := commonsImageURL("Portrait of Newton.jpg")
+	if !slices.Equal(doc.Images, []string{image}) {
+		t.Errorf("images %v, want %s", doc.Images, image)
+	}
+	images := storage.images[doc.Hash]
+	if len(images) != 1 || images[0].URL != image || images[0].PageURL != wantURL {
+		t.Errorf("saved images %+v", images)
+	}
+	if !strings.Contains(string(body), "<h2 id=\"Work\">Work</h2>") {
+		t.Errorf("rendered page has no Work heading:\n%s", body)
+	}
+}
+
+func TestDumpIngesterCanceled(t *testing.T) {
+	storage := newDumpStorage()
+	d := testDumpIngester(storage, "testdata/dump.xml", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := d.Start(ctx)
+	if err != nil || len(storage.docs) != 0 {
+		t.Errorf("canceled ingest saved %d articles (%v), want none", len(storage.docs), err)
+	}
+}
+
+func TestDumpIngesterMissingFile(t *testing.T) {
+	err := testDumpIngester(newDumpStorage(), "testdata/missing.xml", 0).Start(context.Background())
+	if !os.IsNotExist(err) {
+		t.Errorf("missing dump: %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// longest infobox value kept, in bytes
+const MAX_INFOBOX_VALUE = 300
+
+// "This page was last edited on 5 January 2024, at 12:00 (UTC)."
+var lastEditedPattern = regexp.MustCompile(`(\d{1,2} \p{L}+ \d{4}), at (\d{1,2}:\d{2})`)
+
+// Section is a heading of the article
+type Section struct {
+	// 2 for <h2> up to 6
+	Level  int
+	Title  string
+	Anchor string
+}
+
+// InfoboxField is a row of the infobox, in the order of the page
+type InfoboxField struct {
+	Key   string
+	Value string
+}
+
+// Coordinates of the place the article is about
+type Coordinates struct {
+	Lat float64
+	Lon float64
+}
+
+// parseStructure parses the html body and extracts its structure
+func parseStructure(body []byte, docMetadata *DocMetadata) error {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	extractStructure(doc, docMetadata)
+	return nil
+}
+
+// extractStructure fills the structured fields of a Wikipedia article:
+// short description, sections, infobox, categories, coordinates, last edit and disambiguation
+func extractStructure(doc *html.Node, docMetadata *DocMetadata) {
+	docMetadata.ShortDescription = ""
+	docMetadata.Sections = []Section{}
+	docMetadata.Infobox = []InfoboxField{}
+	docMetadata.Categories = []string{}
+	docMetadata.Coordinates = nil
+	docMetadata.ModifiedAt = time.Time{}
+	docMetadata.Disambiguation = false
+	hiddenCategories := []string{}
+	infoboxDone := false
+
+	var walk func(n *html.Node, inContent bool)
+	walk = func(n *html.Node, inContent bool) {
+		if n.Type == html.ElementNode {
+			id := getAttr(n, "id")
+			switch {
+			case id == "mw-content-text":
+				inContent = true
+			case hasClass(n, "shortdescription") && docMetadata.ShortDescription == "":
+				docMetadata.ShortDescription = cleanText(n)
+				return
+			case inContent && isHeading(n) && id != "mw-toc-heading":
+				if section, ok := extractSection(n); ok {
+					docMetadata.Sections = append(docMetadata.Sections, section)
+				}
+				return
+			case inContent && n.Data == "table" && hasClass(n, "infobox") && !infoboxDone:
+				docMetadata.Infobox = extractInfobox(n)
+				infoboxDone = true
+			case id == "mw-normal-catlinks":
+				docMetadata.Categories = extractCategories(n)
+				return
+			case id == "mw-hidden-catlinks":
+				hiddenCategories = extractCategories(n)
+				return
+			case n.Data == "span" && hasClass(n, "geo") && docMetadata.Coordinates == nil:
+				docMetadata.Coordinates = parseCoordinates(cleanText(n))
+			case id == "footer-info-lastmod":
+				docMetadata.ModifiedAt = parseLastEdited(cleanText(n))
+				return
+			case id == "disambigbox" || hasClass(n, "dmbox-disambig"):
+				docMetadata.Disambiguation = true
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, inContent)
+		}
+	}
+	walk(doc, false)
+
+	for _, category := range append(hiddenCategories, docMetadata.Categories...) {
+		if strings.Contains(strings.ToLower(category), "disambiguation pages") {
+			docMetadata.Disambiguation = true
+		}
+	}
+	// pages without the footer get the date of the response
+	if docMetadata.ModifiedAt.IsZero() && docMetadata.LastModified != "" {
+		modifiedAt, err := http.ParseTime(docMetadata.LastModified)
+		if err == nil {
+			docMetadata.ModifiedAt = modifiedAt.UTC()
+		}
+	}
+}
+
+// extractSection reads the title and anchor of <h2> to <h6>, both for the current skin
+// (<h2 id="History">) and the old one (<h2><span class="mw-headline" id="History">)
+func extractSection(n *html.Node) (Section, bool) {
+	section := Section{Level: int(n.Data[1] - '0'), Title: cleanText(n), Anchor: getAttr(n, "id")}
+	if section.Anchor == "" {
+		if headline := findNode(n, func(c *html.Node) bool { return hasClass(c, "mw-headline") }); headline != nil {
+			section.Anchor = getAttr(headline, "id")
+		}
+	}
+	return section, section.Title != ""
+}
+
+// extractInfobox returns the label and data cells of the rows of the infobox
+func extractInfobox(table *html.Node) []InfoboxField {
+	fields := []InfoboxField{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "tr" {
+			var key, value string
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type != html.ElementNode {
+					continue
+				}
+				if c.Data == "th" && key == "" {
+					key = cleanText(c)
+				} else if c.Data == "td" && value == "" {
+					value = truncateUTF8(cleanText(c), MAX_INFOBOX_VALUE)
+				}
+			}
+			if key != "" && value != "" {
+				fields = append(fields, InfoboxField{Key: key, Value: value})
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(table)
+	return fields
+}
+
+// extractCategories returns the names of the category links in the list of the catlinks box
+func extractCategories(n *html.Node) []string {
+	categories := []string{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "li" {
+			if text := cleanText(n); text != "" {
+				categories = append(categories, text)
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return categories
+}
+
+// parseCoordinates reads the decimal coordinates of <span class="geo">, "51.507; -0.128"
+func parseCoordinates(text string) *Coordinates {
+	latText, lonText, ok := strings.Cut(text, ";")
+	if !ok {
+		return nil
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latText), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return nil
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonText), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return nil
+	}
+	return &Coordinates{Lat: lat, Lon: lon}
+}
+
+// parseLastEdited reads the date of the "last edited" footer, zero if it has another format
+func parseLastEdited(text string) time.Time {
+	match := lastEditedPattern.FindStringSubmatch(text)
+	if match == nil {
+		return time.Time{}
+	}
+	modifiedAt, err := time.Parse("2 January 2006 15:04", match[1]+" "+match[2])
+	if err != nil {
+		return time.Time{}
+	}
+	return modifiedAt
+}
+
+// cleanText returns the text of the node with collapsed whitespace, without
+// reference markers, edit links, styles and scripts
+func cleanText(n *html.Node) string {
+	var text strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && isNoise(n) {
+			return
+		}
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		// cells and list items are separate words
+		if n.Type == html.ElementNode && (n.Data == "br" || n.Data == "li" || n.Data == "td" || n.Data == "th") {
+			text.WriteString(" ")
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// isNoise reports whether the element holds no text of the article
+func isNoise(n *html.Node) bool {
+	switch n.Data {
+	case "style", "script", "noscript":
+		return true
+	case "sup":
+		return hasClass(n, "reference") || hasClass(n, "noprint")
+	case "span":
+		return hasClass(n, "mw-editsection")
+	}
+	return false
+}
+
+// isBoilerplate reports whether the node is inside a part of the article that isn't prose:
+// tables, hatnotes, the short description, notices and the coordinates
+func isBoilerplate(n *html.Node) bool {
+	for p := n; p != nil; p = p.Parent {
+		if p.Type != html.ElementNode {
+			continue
+		}
+		if getAttr(p, "id") == "mw-content-text" {
+			return false
+		}
+		if p.Data == "table" || getAttr(p, "id") == "coordinates" || hasClass(p, "hatnote") || hasClass(p, "shortdescription") ||
+			hasClass(p, "mw-empty-elt") || hasClass(p, "ambox") || hasClass(p, "navbox") || hasClass(p, "thumb") || p.Data == "figure" {
+			return true
+		}
+	}
+	return false
+}
+
+func isHeading(n *html.Node) bool {
+	return len(n.Data) == 2 && n.Data[0] == 'h' && n.Data[1] >= '2' && n.Data[1] <= '6'
+}
+
+// hasClass reports whether the class attribute of the node contains class
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(getAttr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// findNode returns the first descendant of n matching the predicate
+func findNode(n *html.Node, match func(*html.Node) bool) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && match(c) {
+			return c
+		}
+		if found := findNode(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
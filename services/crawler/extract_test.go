@@ -0,0 +1,155 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ARTICLE_HTML is the markup of a Wikipedia article with every part extractStructure reads
+const ARTICLE_HTML = `<!DOCTYPE html>
+<html><head><title>London - Wikipedia</title></head>
+<body>
+<h1 id="firstHeading">London</h1>
+<div id="mw-content-text">
+<div class="shortdescription nomobile">Capital city of England</div>
+<table class="infobox ib-settlement">
+<tr><th colspan="2">London</th></tr>
+<tr><th>Country</th><td>United Kingdom<sup class="reference">[1]</sup></td></tr>
+<tr><th>Area</th><td>1,572 km<sup>2</sup></td></tr>
+<tr><th>Website</th></tr>
+</table>
+<table class="infobox"><tr><th>Second</th><td>ignored</td></tr></table>
+<span id="coordinates"><span class="geo">51.507; -0.128</span></span>
+<p>London is the capital.</p>
+<h2 id="mw-toc-heading">Contents</h2>
+<div class="mw-heading mw-heading2"><h2 id="History">History<span class="mw-editsection">[edit]</span></h2></div>
+<p>Old.</p>
+<h3><span class="mw-headline" id="Roman_London">Roman London</span></h3>
+<h2></h2>
+</div>
+<div id="catlinks">
+<div id="mw-normal-catlinks"><ul><li><a href="/wiki/Category:Capitals">Capitals</a></li><li>Port cities</li></ul></div>
+<div id="mw-hidden-catlinks"><ul><li>Articles with short description</li></ul></div>
+</div>
+<footer><ul><li id="footer-info-lastmod"> This page was last edited on 5 January 2024, at 12:30<span class="anonymous-show">&#160;(UTC)</span>.</li></ul></footer>
+</body></html>`
+
+func TestParseStructure(t *testing.T) {
+	doc := DocMetadata{
+		// the footer wins over the date of the response
+		LastModified: "Mon, 01 Jan 2024 00:00:00 GMT",
+		// fields of an earlier crawl of the page are replaced
+		Categories:     []string{"Old"},
+		Disambiguation: true,
+	}
+	err := parseStructure([]byte(ARTICLE_HTML), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.ShortDescription != "Capital city of England" {
+		t.Errorf("short description %q", doc.ShortDescription)
+	}
+	wantSections := []Section{
+		{Level: 2, Title: "History", Anchor: "History"},
+		{Level: 3, Title: "Roman London", Anchor: "Roman_London"},
+	}
+	if !slices.Equal(doc.Sections, wantSections) {
+		t.Errorf("sections %+v, want %+v", doc.Sections, wantSections)
+	}
+	wantInfobox := []InfoboxField{{Key: "Country", Value: "United Kingdom"}, {Key: "Area", Value: "1,572 km2"}}
+	if !slices.Equal(doc.Infobox, wantInfobox) {
+		t.Errorf("infobox %+v, want %+v", doc.Infobox, wantInfobox)
+	}
+	if !slices.Equal(doc.Categories, []string{"Capitals", "Port cities"}) {
+		t.Errorf("categories %v", doc.Categories)
+	}
+	if doc.Coordinates == nil || *doc.Coordinates != (Coordinates{Lat: 51.507, Lon: -0.128}) {
+		t.Errorf("coordinates %+v", doc.Coordinates)
+	}
+	if want := time.Date(2024, 1, 5, 12, 30, 0, 0, time.UTC); !doc.ModifiedAt.Equal(want) {
+		t.Errorf("modified at %v, want %v", doc.ModifiedAt, want)
+	}
+	if doc.Disambiguation {
+		t.Error("article is a disambiguation page")
+	}
+}
+
+func TestParseStructureDisambiguation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"disambigbox", `<div id="mw-content-text"><div id="disambigbox">This page lists articles</div></div>`, true},
+		{"dmbox", `<div id="mw-content-text"><table class="metadata dmbox dmbox-disambig"></table></div>`, true},
+		{"hidden category", `<div id="mw-hidden-catlinks"><ul><li>All disambiguation pages</li></ul></div>`, true},
+		{"normal category", `<div id="mw-normal-catlinks"><ul><li>Place name disambiguation pages</li></ul></div>`, true},
+		{"article", ARTICLE_HTML, false},
+	}
+	for _, test := range tests {
+		doc := DocMetadata{}
+		err := parseStructure([]byte(test.body), &doc)
+		if err != nil || doc.Disambiguation != test.want {
+			t.Errorf("%s: disambiguation %v (%v), want %v", test.name, doc.Disambiguation, err, test.want)
+		}
+	}
+}
+
+func TestParseStructureModifiedAt(t *testing.T) {
+	footer := func(text string) string {
+		return `<body><li id="footer-info-lastmod">` + text + `</li></body>`
+	}
+	tests := []struct {
+		name         string
+		body         string
+		lastModified string
+		want         time.Time
+	}{
+		{"footer", footer("This page was last edited on 29 February 2024, at 09:05 (UTC)."), "", time.Date(2024, 2, 29, 9, 5, 0, 0, time.UTC)},
+		{"no footer, Last-Modified header", "<body><p>text</p></body>", "Tue, 02 Jan 2024 03:04:05 GMT", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"unreadable footer, Last-Modified header", footer("Zuletzt bearbeitet am 5. Januar 2024"), "Tue, 02 Jan 2024 03:04:05 GMT", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"no footer, invalid header", "<body></body>", "yesterday", time.Time{}},
+		{"neither", "<body></body>", "", time.Time{}},
+	}
+	for _, test := range tests {
+		doc := DocMetadata{LastModified: test.lastModified, ModifiedAt: time.Now()}
+		err := parseStructure([]byte(test.body), &doc)
+		if err != nil || !doc.ModifiedAt.Equal(test.want) {
+			t.Errorf("%s: modified at %v (%v), want %v", test.name, doc.ModifiedAt, err, test.want)
+		}
+	}
+}
+
+func TestParseCoordinates(t *testing.T) {
+	tests := []struct {
+		text string
+		want *Coordinates
+	}{
+		{"51.507; -0.128", &Coordinates{Lat: 51.507, Lon: -0.128}},
+		{"-33.9;151.2", &Coordinates{Lat: -33.9, Lon: 151.2}},
+		{"91; 0", nil},
+		{"0; 181", nil},
+		{"51.507 -0.128", nil},
+		{"north; east", nil},
+	}
+	for _, test := range tests {
+		got := parseCoordinates(test.text)
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("parseCoordinates(%q) = %+v, want %+v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestInfoboxValueLimit(t *testing.T) {
+	long := strings.Repeat("é", MAX_INFOBOX_VALUE)
+	doc := DocMetadata{}
+	err := parseStructure([]byte(`<div id="mw-content-text"><table class="infobox"><tr><th>Key</th><td>`+long+`</td></tr></table></div>`), &doc)
+	if err != nil || len(doc.Infobox) != 1 {
+		t.Fatalf("infobox %+v (%v)", doc.Infobox, err)
+	}
+	if value := doc.Infobox[0].Value; len(value) > MAX_INFOBOX_VALUE || !strings.HasPrefix(long, value) {
+		t.Errorf("value of %d bytes isn't cut at a rune boundary under %d", len(value), MAX_INFOBOX_VALUE)
+	}
+}
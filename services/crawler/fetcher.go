@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// default user agent sent with every request
+const USER_AGENT = "GoogleClone-Crawler/1.0 (Educational Project)"
+
+// maximum number of redirects followed by Fetch
+const MAX_REDIRECTS = 10
+
+// bytes of a failed response read so the connection can be reused
+const DRAIN_LIMIT = 4 << 10
+
+// errors that won't go away by fetching again
+var ErrBodyTooLarge = errors.New("response body is larger than the maximum body size")
+var ErrTooManyRedirects = fmt.Errorf("stopped after %d redirects", MAX_REDIRECTS)
+
+// ErrReadTimeout is returned when the server stops sending the body for longer than the read timeout
+var ErrReadTimeout = errors.New("timed out reading the response body")
+
+// StatusError is returned by Fetch when the server doesn't answer with 200
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status code: %d", e.StatusCode)
+}
+
+// ContentTypeError is returned by Fetch for responses that aren't html
+type ContentTypeError struct {
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("content type %q is not html", e.ContentType)
+}
+
+// EncodingError is returned by Fetch for a Content-Encoding it can't decode
+type EncodingError struct {
+	Encoding string
+}
+
+func (e *EncodingError) Error() string {
+	return fmt.Sprintf("unsupported content encoding %q", e.Encoding)
+}
+
+// FetchResult is a 200 or 304 response with the validators used for conditional requests.
+// Fetch also returns it next to an error once the response arrived, without a body.
+type FetchResult struct {
+	StatusCode   int
+	Body         []byte
+	ETag         string
+	LastModified string
+	// url of the last response and the urls redirected to on the way, in order
+	FinalURL  string
+	Redirects []string
+}
+
+// NotModified reports whether the page didn't change since the validators were issued
+func (r *FetchResult) NotModified() bool {
+	return r.StatusCode == http.StatusNotModified
+}
+
+/*
+Fetcher downloads pages.
+
+client: http client with the timeouts from the config, it doesn't decompress on its own
+userAgent: sent with every request
+readTimeout: longest pause allowed while reading a body
+maxBodySize: maximum size of a decoded body
+maxRetries: how often a job is retried after a transient error
+retryBackoff: delay before the first retry, it doubles with every attempt
+maxBackoff: upper bound for the retry delay
+*/
+type Fetcher struct {
+	client       *http.Client
+	userAgent    string
+	readTimeout  time.Duration
+	maxBodySize  int64
+	maxRetries   int
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+}
+
+// NewFetcher creates a fetcher with the user agent, timeouts, limits and retries from the config
+func NewFetcher(config *Config) *Fetcher {
+	dialer := &net.Dialer{Timeout: config.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   config.ConnectTimeout,
+		ResponseHeaderTimeout: config.ReadTimeout,
+		MaxIdleConnsPerHost:   config.MaxPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		ForceAttemptHTTP2:     true,
+		// bodies are decoded by Fetch, so br works too and the size limit applies to the decoded body
+		DisableCompression: true,
+	}
+	return &Fetcher{
+		client:       &http.Client{Timeout: config.RequestTimeout, Transport: transport},
+		userAgent:    config.UserAgent,
+		readTimeout:  config.ReadTimeout,
+		maxBodySize:  config.MaxBodySize,
+		maxRetries:   config.MaxRetries,
+		retryBackoff: config.RetryBackoff,
+		maxBackoff:   config.MaxBackoff,
+	}
+}
+
+// Client returns the http client used by the fetcher
+func (f *Fetcher) Client() *http.Client {
+	return f.client
+}
+
+// Fetch returns the page, the request is conditional when etag or lastModified are set.
+// Canceling ctx aborts the request.
+func (f *Fetcher) Fetch(ctx context.Context, url string, etag string, lastModified string) (*FetchResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+	req.Header.Set("Accept-Encoding", "gzip, br, deflate")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	// record the redirect chain
+	redirects := []string{}
+	client := *f.client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= MAX_REDIRECTS {
+			return ErrTooManyRedirects
+		}
+		redirects = append(redirects, req.URL.String())
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &FetchResult{
+		StatusCode:   resp.StatusCode,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FinalURL:     resp.Request.URL.String(),
+		Redirects:    redirects,
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return result, nil
+	}
+	// Check if the response is successful
+	if resp.StatusCode != 200 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, DRAIN_LIMIT))
+		return result, &StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	if f.maxBodySize > 0 && resp.ContentLength > f.maxBodySize {
+		return result, ErrBodyTooLarge
+	}
+
+	// a server that stops sending the body cancels the request after readTimeout
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(f.readTimeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+	body, err := f.readBody(resp, &idleReader{r: resp.Body, timer: timer, timeout: f.readTimeout})
+	if err != nil && timedOut.Load() {
+		return result, ErrReadTimeout
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Body = body
+	return result, nil
+}
+
+// readBody decodes the body, checks that it is html and reads at most maxBodySize bytes of it
+func (f *Fetcher) readBody(resp *http.Response, body io.Reader) ([]byte, error) {
+	var decoded io.Reader
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		decoded = body
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		decoded = gz
+	case "br":
+		decoded = brotli.NewReader(body)
+	case "deflate":
+		zr, err := zlib.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		decoded = zr
+	default:
+		return nil, &EncodingError{Encoding: encoding}
+	}
+
+	reader := bufio.NewReader(decoded)
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		// no header, look at the start of the body
+		start, err := reader.Peek(512)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		contentType = http.DetectContentType(start)
+	}
+	if !isHTML(contentType) {
+		return nil, &ContentTypeError{ContentType: contentType}
+	}
+
+	if f.maxBodySize <= 0 {
+		return io.ReadAll(reader)
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, f.maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > f.maxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	return data, nil
+}
+
+// RetryDelay returns how long to wait before the job is fetched again,
+// false if the error is permanent or the job ran out of retries
+func (f *Fetcher) RetryDelay(job Job, err error) (time.Duration, bool) {
+	if job.Attempt >= f.maxRetries || !IsTransient(err) {
+		return 0, false
+	}
+	// exponential backoff with jitter so retries of many jobs don't line up
+	delay := f.retryBackoff << job.Attempt
+	delay = delay/2 + rand.N(delay/2+1)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+		delay = statusErr.RetryAfter
+	}
+	return min(delay, f.maxBackoff), true
+}
+
+// error classes of the fetch log
+const CLASS_OK = "ok"
+const CLASS_NOT_MODIFIED = "not_modified"
+const CLASS_CLIENT_ERROR = "client_error"
+const CLASS_SERVER_ERROR = "server_error"
+const CLASS_THROTTLED = "throttled"
+const CLASS_TIMEOUT = "timeout"
+const CLASS_NETWORK = "network"
+const CLASS_DNS = "dns"
+const CLASS_CONTENT_TYPE = "content_type"
+const CLASS_TOO_LARGE = "too_large"
+const CLASS_REDIRECTS = "redirects"
+const CLASS_ENCODING = "encoding"
+const CLASS_OTHER = "other"
+
+// ErrorClass sorts the result of a fetch into one of the error classes
+func ErrorClass(result *FetchResult, err error) string {
+	if err == nil {
+		if result != nil && result.NotModified() {
+			return CLASS_NOT_MODIFIED
+		}
+		return CLASS_OK
+	}
+
+	var statusErr *StatusError
+	var contentTypeErr *ContentTypeError
+	var encodingErr *EncodingError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr) && isThrottled(statusErr.StatusCode):
+		return CLASS_THROTTLED
+	case errors.As(err, &statusErr) && statusErr.StatusCode >= 500:
+		return CLASS_SERVER_ERROR
+	case errors.As(err, &statusErr):
+		return CLASS_CLIENT_ERROR
+	case errors.As(err, &contentTypeErr):
+		return CLASS_CONTENT_TYPE
+	case errors.As(err, &encodingErr):
+		return CLASS_ENCODING
+	case errors.Is(err, ErrBodyTooLarge):
+		return CLASS_TOO_LARGE
+	case errors.Is(err, ErrTooManyRedirects):
+		return CLASS_REDIRECTS
+	case errors.As(err, &dnsErr):
+		return CLASS_DNS
+	case errors.Is(err, ErrReadTimeout) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return CLASS_TIMEOUT
+	case errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET):
+		return CLASS_NETWORK
+	}
+	return CLASS_OTHER
+}
+
+// IsTransient reports whether fetching again may succeed: timeouts, dropped connections,
+// temporary dns failures and 408, 425, 429 and 5xx responses except 501
+func IsTransient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+			return true
+		case http.StatusNotImplemented:
+			return false
+		}
+		return statusErr.StatusCode >= 500
+	}
+
+	var contentTypeErr *ContentTypeError
+	var encodingErr *EncodingError
+	if errors.As(err, &contentTypeErr) || errors.As(err, &encodingErr) ||
+		errors.Is(err, ErrBodyTooLarge) || errors.Is(err, ErrTooManyRedirects) {
+		return false
+	}
+	if errors.Is(err, ErrReadTimeout) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// isHTML reports whether the content type is html or xhtml
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// idleReader pushes the timer back on every read, so only a stalled body times out
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// robotsToken returns the product token of a user agent, "Foo-Bot/1.0 (info)" gives "Foo-Bot"
+func robotsToken(userAgent string) string {
+	token, _, _ := strings.Cut(strings.TrimSpace(userAgent), "/")
+	token, _, _ = strings.Cut(token, " ")
+	return token
+}
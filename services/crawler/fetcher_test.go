@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// body limit of the test fetcher
+const TEST_MAX_BODY = 1 << 10
+
+func testFetcher() *Fetcher {
+	config := NewConfig()
+	config.ConnectTimeout = 100 * time.Millisecond
+	config.ReadTimeout = 100 * time.Millisecond
+	config.RequestTimeout = 5 * time.Second
+	config.MaxBodySize = TEST_MAX_BODY
+	config.MaxRetries = 3
+	config.RetryBackoff = time.Second
+	config.MaxBackoff = time.Minute
+	return NewFetcher(config)
+}
+
+// encode compresses the body with the content encoding
+func encode(t *testing.T, encoding string, body []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "", "identity":
+		return body
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		return body
+	}
+	_, err := w.Write(body)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetchBody(t *testing.T) {
+	page := []byte("<html><body>page</body></html>")
+	// compresses to a few bytes and decodes to more than the limit
+	bomb := bytes.Repeat([]byte("a"), 100*TEST_MAX_BODY)
+	tests := []struct {
+		name        string
+		encoding    string
+		contentType string
+		body        []byte
+		want        string
+		class       string
+	}{
+		{"plain", "", "text/html; charset=utf-8", page, string(page), CLASS_OK},
+		{"gzip", "gzip", "text/html", page, string(page), CLASS_OK},
+		{"brotli", "br", "text/html", page, string(page), CLASS_OK},
+		{"deflate", "deflate", "text/html", page, string(page), CLASS_OK},
+		{"xhtml", "", "application/xhtml+xml", page, string(page), CLASS_OK},
+		{"sniffed html", "", "", page, string(page), CLASS_OK},
+		{"body at the limit", "", "text/html", bytes.Repeat([]byte("a"), TEST_MAX_BODY), strings.Repeat("a", TEST_MAX_BODY), CLASS_OK},
+		{"body over the limit", "", "text/html", bytes.Repeat([]byte("a"), TEST_MAX_BODY+1), "", CLASS_TOO_LARGE},
+		{"gzip bomb", "gzip", "text/html", bomb, "", CLASS_TOO_LARGE},
+		{"brotli bomb", "br", "text/html", bomb, "", CLASS_TOO_LARGE},
+		{"deflate bomb", "deflate", "text/html", bomb, "", CLASS_TOO_LARGE},
+		{"json", "", "application/json", []byte(`{"a":1}`), "", CLASS_CONTENT_TYPE},
+		{"pdf", "gzip", "application/pdf", page, "", CLASS_CONTENT_TYPE},
+		{"sniffed image", "", "", []byte("\x89PNG\r\n\x1a\n"), "", CLASS_CONTENT_TYPE},
+		{"unknown encoding", "zstd", "text/html", page, "", CLASS_ENCODING},
+	}
+	for _, test := range tests {
+		body := encode(t, test.encoding, test.body)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.encoding != "" {
+				w.Header().Set("Content-Encoding", test.encoding)
+			}
+			// an empty Content-Type header isn't sniffed by the server
+			w.Header()["Content-Type"] = nil
+			if test.contentType != "" {
+				w.Header().Set("Content-Type", test.contentType)
+			}
+			w.Write(body)
+		}))
+		result, err := testFetcher().Fetch(context.Background(), server.URL, "", "")
+		server.Close()
+		if class := ErrorClass(result, err); class != test.class {
+			t.Errorf("%s: class %s (%v), want %s", test.name, class, err, test.class)
+			continue
+		}
+		if err == nil && string(result.Body) != test.want {
+			t.Errorf("%s: body of %d bytes, want %d", test.name, len(result.Body), len(test.want))
+		}
+		if err != nil && IsTransient(err) {
+			t.Errorf("%s: %v is transient", test.name, err)
+		}
+	}
+}
+
+func TestFetchStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		class      string
+		transient  bool
+	}{
+		{"not found", http.StatusNotFound, "", CLASS_CLIENT_ERROR, false},
+		{"request timeout", http.StatusRequestTimeout, "", CLASS_CLIENT_ERROR, true},
+		{"too many requests", http.StatusTooManyRequests, "120", CLASS_THROTTLED, true},
+		{"unavailable", http.StatusServiceUnavailable, "", CLASS_THROTTLED, true},
+		{"server error", http.StatusInternalServerError, "", CLASS_SERVER_ERROR, true},
+		{"not implemented", http.StatusNotImplemented, "", CLASS_SERVER_ERROR, false},
+		{"not modified", http.StatusNotModified, "", CLASS_NOT_MODIFIED, false},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.retryAfter != "" {
+				w.Header().Set("Retry-After", test.retryAfter)
+			}
+			w.WriteHeader(test.status)
+		}))
+		result, err := testFetcher().Fetch(context.Background(), server.URL, `"etag"`, "")
+		server.Close()
+		if class := ErrorClass(result, err); class != test.class {
+			t.Errorf("%s: class %s (%v), want %s", test.name, class, err, test.class)
+		}
+		if err != nil && IsTransient(err) != test.transient {
+			t.Errorf("%s: transient %v, want %v", test.name, IsTransient(err), test.transient)
+		}
+		var statusErr *StatusError
+		if test.retryAfter != "" && (!errors.As(err, &statusErr) || statusErr.RetryAfter != 120*time.Second) {
+			t.Errorf("%s: Retry-After not read from %v", test.name, err)
+		}
+	}
+}
+
+func TestFetchTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"headers too late", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}},
+		{"body stalls", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(test.handler)
+		start := time.Now()
+		result, err := testFetcher().Fetch(context.Background(), server.URL, "", "")
+		elapsed := time.Since(start)
+		server.CloseClientConnections()
+		server.Close()
+		if class := ErrorClass(result, err); class != CLASS_TIMEOUT || !IsTransient(err) {
+			t.Errorf("%s: class %s, transient %v (%v), want a transient timeout", test.name, class, IsTransient(err), err)
+		}
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("%s: gave up after %v, want the read timeout", test.name, elapsed)
+		}
+	}
+}
+
+func TestFetchConnect(t *testing.T) {
+	// accepts connections but never answers the TLS handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name      string
+		url       string
+		class     string
+		transient bool
+	}{
+		{"handshake timeout", "https://" + listener.Addr().String() + "/", CLASS_TIMEOUT, true},
+		{"connection refused", closed.URL, CLASS_NETWORK, true},
+	}
+	for _, test := range tests {
+		start := time.Now()
+		result, err := testFetcher().Fetch(context.Background(), test.url, "", "")
+		if class := ErrorClass(result, err); class != test.class || IsTransient(err) != test.transient {
+			t.Errorf("%s: class %s, transient %v (%v), want %s, %v", test.name, class, IsTransient(err), err, test.class, test.transient)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("%s: gave up after %v, want the connect timeout", test.name, elapsed)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	fetcher := testFetcher()
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		min, max time.Duration
+		retry    bool
+	}{
+		{"first retry", 0, &StatusError{StatusCode: http.StatusBadGateway}, 500 * time.Millisecond, time.Second, true},
+		{"third retry", 2, ErrReadTimeout, 2 * time.Second, 4 * time.Second, true},
+		{"retry after is longer", 0, &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 30 * time.Second}, 30 * time.Second, 30 * time.Second, true},
+		{"retry after is capped", 0, &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}, time.Minute, time.Minute, true},
+		{"out of retries", 3, &StatusError{StatusCode: http.StatusBadGateway}, 0, 0, false},
+		{"permanent", 0, &StatusError{StatusCode: http.StatusNotFound}, 0, 0, false},
+		{"too large", 0, ErrBodyTooLarge, 0, 0, false},
+		{"wrapped reset", 0, fmt.Errorf("read: %w", syscall.ECONNRESET), 500 * time.Millisecond, time.Second, true},
+	}
+	for _, test := range tests {
+		delay, retry := fetcher.RetryDelay(Job{Attempt: test.attempt}, test.err)
+		if retry != test.retry || delay < test.min || delay > test.max {
+			t.Errorf("%s: RetryDelay = %v, %v, want %v to %v, %v", test.name, delay, retry, test.min, test.max, test.retry)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"5", 5 * time.Second, 5 * time.Second},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+		{"soon", 0, 0},
+	}
+	for _, test := range tests {
+		if got := parseRetryAfter(test.value); got < test.min || got > test.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v to %v", test.value, got, test.min, test.max)
+		}
+	}
+}
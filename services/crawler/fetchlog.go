@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// number of fetch log entries saved at once
+const FETCH_LOG_BATCH = 500
+
+// FetchLog is one fetch attempt
+type FetchLog struct {
+	CrawlID    string
+	URL        string
+	Host       string
+	StatusCode int
+	ErrorClass string
+	Error      string
+	Latency    time.Duration
+	// size of the body, 0 for failed fetches
+	Bytes int
+	// url the request was redirected to, empty without redirects
+	RedirectTarget string
+	Attempt        int
+	Timestamp      time.Time
+}
+
+// NewFetchLog describes the fetch of the job that started at start
+func NewFetchLog(job Job, result *FetchResult, err error, start time.Time) FetchLog {
+	entry := FetchLog{
+		URL:        job.URL,
+		Host:       hostOf(job.URL),
+		ErrorClass: ErrorClass(result, err),
+		Latency:    time.Since(start),
+		Attempt:    job.Attempt,
+		Timestamp:  start,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		entry.StatusCode = statusErr.StatusCode
+	}
+	if result != nil {
+		entry.StatusCode = result.StatusCode
+		entry.Bytes = len(result.Body)
+		if len(result.Redirects) > 0 {
+			entry.RedirectTarget = result.FinalURL
+		}
+	}
+	return entry
+}
+
+/*
+FetchLogger collects fetch log entries and saves them in batches.
+
+queue: entries not saved yet
+*/
+type FetchLogger struct {
+	mu      sync.Mutex
+	storage Storage
+	crawlID string
+	queue   []FetchLog
+}
+
+// NewFetchLogger creates a logger saving the entries of the crawl to storage
+func NewFetchLogger(storage Storage, crawlID string) *FetchLogger {
+	return &FetchLogger{
+		storage: storage,
+		crawlID: crawlID,
+		queue:   make([]FetchLog, 0, FETCH_LOG_BATCH),
+	}
+}
+
+// Record adds an entry, a full batch is saved right away
+func (l *FetchLogger) Record(entry FetchLog) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.CrawlID = l.crawlID
+	l.queue = append(l.queue, entry)
+	if len(l.queue) >= FETCH_LOG_BATCH {
+		err := l.flush()
+		if err != nil {
+			fmt.Println("Error saving fetch log", err)
+		}
+	}
+}
+
+// Flush saves the entries that are left
+func (l *FetchLogger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.flush()
+}
+
+func (l *FetchLogger) flush() error {
+	if len(l.queue) == 0 {
+		return nil
+	}
+	err := l.storage.SaveFetchLogs(l.queue)
+	// drop the batch even on errors, the log must not grow without bound
+	l.queue = make([]FetchLog, 0, FETCH_LOG_BATCH)
+	return err
+}
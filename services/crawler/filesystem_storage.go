@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+FilesystemStorage saves everything under a local directory, so a crawl needs no external services.
+
+	<dir>/pages/ab/cd/abcd...html  raw html, content addressed by its hash
+	<dir>/metadata.jsonl           one DocMetadata per line, a later line for the same hash wins
+	<dir>/changes.jsonl            one DocChange per line
+	<dir>/fetch_log.jsonl          one FetchLog per fetch attempt
+	<dir>/images.jsonl             one PageImages per line, a later line for the same page wins
+
+docs: latest metadata by hash, used to rewrite the metadata file after deletions
+metadataQueue: metadata not yet appended to the file
+compact: a document was deleted, the metadata file has to be rewritten on flush
+*/
+type FilesystemStorage struct {
+	mu              sync.Mutex
+	dir             string
+	pagesDir        string
+	metadataFile    string
+	docs            map[string]DocMetadata
+	metadataQueue   []DocMetadata
+	maxMetadataJobs int
+	compact         bool
+}
+
+// NewFilesystemStorage creates a storage in dir, loading the metadata saved there before
+func NewFilesystemStorage(dir string, pagesDir string, metadataDir string) (*FilesystemStorage, error) {
+	s := &FilesystemStorage{
+		dir:             dir,
+		pagesDir:        filepath.Join(dir, pagesDir),
+		metadataFile:    filepath.Join(dir, metadataDir+".jsonl"),
+		docs:            make(map[string]DocMetadata),
+		metadataQueue:   make([]DocMetadata, 0),
+		maxMetadataJobs: 300,
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = readJSONL(s.metadataFile, func(decoder *json.Decoder) error {
+		var doc DocMetadata
+		err := decoder.Decode(&doc)
+		if err == nil {
+			s.docs[doc.Hash] = doc
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FilesystemStorage) CreateMetadataDirectory(name string) error {
+	// metadata lives in a single file in the storage directory
+	return os.MkdirAll(s.dir, 0755)
+}
+
+func (s *FilesystemStorage) CreateHTMLDirectory(name string) error {
+	return os.MkdirAll(s.pagesDir, 0755)
+}
+
+// SaveHTML writes the page to its content addressed path, pages that already exist are skipped
+func (s *FilesystemStorage) SaveHTML(hash string, body []byte) error {
+	path := htmlPath(s.pagesDir, hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first so readers never see half a page
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FilesystemStorage) SaveMetadata(docMetadata DocMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a full queue is written first, when that fails the document isn't queued and can be saved again
+	if len(s.metadataQueue) >= s.maxMetadataJobs {
+		err := s.flush()
+		if err != nil {
+			return err
+		}
+	}
+	s.docs[docMetadata.Hash] = docMetadata
+	s.metadataQueue = append(s.metadataQueue, docMetadata)
+	return nil
+}
+
+func (s *FilesystemStorage) FlushMetadata() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// flush appends the queued metadata, or rewrites the whole file if documents were deleted
+func (s *FilesystemStorage) flush() error {
+	if s.compact {
+		docs := make([]any, 0, len(s.docs))
+		for _, doc := range s.docs {
+			docs = append(docs, doc)
+		}
+		err := writeJSONL(s.metadataFile, docs, false)
+		if err != nil {
+			return err
+		}
+		s.compact = false
+		s.metadataQueue = s.metadataQueue[:0]
+		return nil
+	}
+
+	if len(s.metadataQueue) == 0 {
+		return nil
+	}
+	docs := make([]any, 0, len(s.metadataQueue))
+	for _, doc := range s.metadataQueue {
+		docs = append(docs, doc)
+	}
+	err := writeJSONL(s.metadataFile, docs, true)
+	if err != nil {
+		return err
+	}
+	s.metadataQueue = s.metadataQueue[:0]
+	return nil
+}
+
+// UpdateInLinks appends the updated documents, a later line for the same hash wins
+func (s *FilesystemStorage) UpdateInLinks(counts map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, inLinks := range counts {
+		doc, ok := s.docs[hash]
+		if !ok {
+			continue
+		}
+		doc.InLinks = inLinks
+		s.docs[hash] = doc
+		s.metadataQueue = append(s.metadataQueue, doc)
+	}
+	return s.flush()
+}
+
+func (s *FilesystemStorage) ListMetadata() ([]DocMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs := make([]DocMetadata, 0, len(s.docs))
+	for _, doc := range s.docs {
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// DeleteMetadata drops the document, the metadata file is rewritten on the next flush
+func (s *FilesystemStorage) DeleteMetadata(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.docs[hash]; !ok {
+		return nil
+	}
+	delete(s.docs, hash)
+	s.compact = true
+	return nil
+}
+
+func (s *FilesystemStorage) SaveChanges(changes []DocChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	docs := make([]any, 0, len(changes))
+	for _, change := range changes {
+		docs = append(docs, change)
+	}
+	return writeJSONL(filepath.Join(s.dir, "changes.jsonl"), docs, true)
+}
+
+func (s *FilesystemStorage) SaveFetchLogs(logs []FetchLog) error {
+	return appendFetchLogs(filepath.Join(s.dir, "fetch_log.jsonl"), logs)
+}
+
+func (s *FilesystemStorage) ListFetchLogs(crawlID string) ([]FetchLog, error) {
+	return readFetchLogs(filepath.Join(s.dir, "fetch_log.jsonl"), crawlID)
+}
+
+func (s *FilesystemStorage) SaveImages(pageHash string, images []Image) error {
+	return appendImages(filepath.Join(s.dir, "images.jsonl"), pageHash, images)
+}
+
+func (s *FilesystemStorage) DeleteImages(pageHash string) error {
+	return appendImages(filepath.Join(s.dir, "images.jsonl"), pageHash, []Image{})
+}
+
+// appendImages appends the images of the page to an images file, no images removes the earlier ones
+func appendImages(path string, pageHash string, images []Image) error {
+	return writeJSONL(path, []any{PageImages{PageHash: pageHash, Images: images}}, true)
+}
+
+// appendFetchLogs appends the entries to a fetch log file
+func appendFetchLogs(path string, logs []FetchLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	docs := make([]any, 0, len(logs))
+	for _, entry := range logs {
+		docs = append(docs, entry)
+	}
+	return writeJSONL(path, docs, true)
+}
+
+// readFetchLogs returns the entries of the crawl from a fetch log file
+func readFetchLogs(path string, crawlID string) ([]FetchLog, error) {
+	logs := []FetchLog{}
+	err := readJSONL(path, func(decoder *json.Decoder) error {
+		var entry FetchLog
+		err := decoder.Decode(&entry)
+		if err == nil && entry.CrawlID == crawlID {
+			logs = append(logs, entry)
+		}
+		return err
+	})
+	return logs, err
+}
+
+// htmlPath spreads pages over two levels of directories named after the start of the hash
+func htmlPath(dir string, hash string) string {
+	if len(hash) < 4 {
+		return filepath.Join(dir, hash+".html")
+	}
+	return filepath.Join(dir, hash[:2], hash[2:4], hash+".html")
+}
+
+// writeJSONL appends the values to the file, or replaces the file when appendMode is false
+func writeJSONL(path string, values []any, appendMode bool) error {
+	var file *os.File
+	var err error
+	if appendMode {
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	} else {
+		file, err = os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	}
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, value := range values {
+		err = encoder.Encode(value)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if appendMode {
+		return err
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// readJSONL calls decode for every line of the file, a missing file has no lines
+func readJSONL(path string, decode func(decoder *json.Decoder) error) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		err = decode(decoder)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilesystemStorageMetadata(t *testing.T) {
+	type step struct {
+		action string // save, delete, inlinks or flush
+		hash   string
+		value  int // depth of a saved document or its in-links
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// depth and in-links by hash after reopening the storage
+		want map[string][2]int
+	}{
+		{"saved documents", []step{
+			{"save", "a", 1}, {"save", "b", 2}, {"flush", "", 0},
+		}, map[string][2]int{"a": {1, 0}, "b": {2, 0}}},
+		{"a later save wins", []step{
+			{"save", "a", 1}, {"flush", "", 0}, {"save", "a", 3}, {"flush", "", 0},
+		}, map[string][2]int{"a": {3, 0}}},
+		{"unflushed documents are lost", []step{
+			{"save", "a", 1}, {"flush", "", 0}, {"save", "b", 2},
+		}, map[string][2]int{"a": {1, 0}}},
+		{"deleted documents", []step{
+			{"save", "a", 1}, {"save", "b", 2}, {"flush", "", 0}, {"delete", "a", 0}, {"flush", "", 0},
+		}, map[string][2]int{"b": {2, 0}}},
+		{"deleted before the first flush", []step{
+			{"save", "a", 1}, {"save", "b", 2}, {"delete", "b", 0}, {"flush", "", 0},
+		}, map[string][2]int{"a": {1, 0}}},
+		{"in-links are written at once", []step{
+			{"save", "a", 1}, {"inlinks", "a", 5}, {"inlinks", "missing", 3},
+		}, map[string][2]int{"a": {1, 5}}},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		storage, err := NewFilesystemStorage(dir, "pages", "metadata")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, step := range test.steps {
+			switch step.action {
+			case "save":
+				err = storage.SaveMetadata(DocMetadata{Hash: step.hash, Depth: step.value})
+			case "delete":
+				err = storage.DeleteMetadata(step.hash)
+			case "inlinks":
+				err = storage.UpdateInLinks(map[string]int{step.hash: step.value})
+			case "flush":
+				err = storage.FlushMetadata()
+			}
+			if err != nil {
+				t.Fatalf("%s: %s %s: %v", test.name, step.action, step.hash, err)
+			}
+		}
+
+		reopened, err := NewFilesystemStorage(dir, "pages", "metadata")
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs, err := reopened.ListMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string][2]int)
+		for _, doc := range docs {
+			got[doc.Hash] = [2]int{doc.Depth, doc.InLinks}
+		}
+		if !maps.Equal(got, test.want) {
+			t.Errorf("%s: stored %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFilesystemStorageHTML(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFilesystemStorage(dir, "pages", "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		hash string
+		body string
+		want string
+		path string
+	}{
+		{"abcdef", "first", "first", "pages/ab/cd/abcdef.html"},
+		// content addressed, an existing page isn't written again
+		{"abcdef", "second", "first", "pages/ab/cd/abcdef.html"},
+		{"ab", "short", "short", "pages/ab.html"},
+	}
+	for _, test := range tests {
+		err = storage.SaveHTML(test.hash, []byte(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := os.ReadFile(filepath.Join(dir, test.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != test.want {
+			t.Errorf("%s: stored %q, want %q", test.path, body, test.want)
+		}
+	}
+	// no temporary files are left behind
+	tmp, _ := filepath.Glob(filepath.Join(dir, "pages", "ab", "cd", ".tmp-*"))
+	if len(tmp) != 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// the idf weights of the topic profiles are recomputed after this many new pages
+const FOCUS_REWEIGHT_PAGES = 50
+
+// TopicProfile is the term frequencies of a page or of the keywords that define the topic of a focused crawl
+type TopicProfile map[string]float64
+
+/*
+focusedStrategy crawls the pages about the topic of the seeds. The topic is the TF-IDF vectors of the
+seed pages, or of the keywords of the config, and the relevance of a page is the cosine similarity of
+its TF-IDF vector to the closest of them. Only the links of pages at least as relevant as the threshold
+are followed. A link's priority is the mean of its page's relevance and the relevance of the words of
+its anchor and url, so the links that look on topic are fetched first.
+
+profiles: term frequencies of the seed pages or the keywords
+keywords: the topic was given as keywords, the seed pages don't change it
+docs, df: pages scored so far and the number of them containing every term, for the idf
+weighted: profiles as unit TF-IDF vectors, recomputed every FOCUS_REWEIGHT_PAGES pages
+scores: relevance of pages whose links weren't prioritized yet, by url
+*/
+type focusedStrategy struct {
+	mu         sync.Mutex
+	threshold  float64
+	profiles   []TopicProfile
+	keywords   bool
+	docs       int
+	df         map[string]int
+	weighted   []TopicProfile
+	weightedAt int
+	scores     map[string]float64
+}
+
+func newFocusedStrategy(keywords []string, threshold float64) *focusedStrategy {
+	s := &focusedStrategy{
+		threshold: threshold,
+		profiles:  []TopicProfile{},
+		df:        make(map[string]int),
+		scores:    make(map[string]float64),
+	}
+	if len(keywords) > 0 {
+		s.profiles = append(s.profiles, TopicProfile(termVector(strings.Join(keywords, " "))))
+		s.keywords = true
+	}
+	return s
+}
+
+func (s *focusedStrategy) Seed(jobs []Job) {
+	for i := range jobs {
+		jobs[i].Priority = 1
+	}
+}
+
+// Score returns the relevance of the page of job and whether its links are followed,
+// the links of seed pages always are
+func (s *focusedStrategy) Score(job Job, text string) (float64, bool) {
+	terms := termVector(text)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs++
+	for term := range terms {
+		s.df[term]++
+	}
+	if job.Depth == 0 && !s.keywords && len(terms) > 0 {
+		s.profiles = append(s.profiles, TopicProfile(terms))
+		s.weighted = nil
+	}
+	score := s.similarity(terms)
+	s.scores[job.URL] = score
+	return score, job.Depth == 0 || score >= s.threshold
+}
+
+func (s *focusedStrategy) Prioritize(job Job, text string, links []Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	score, ok := s.scores[job.URL]
+	if !ok {
+		score = s.similarity(termVector(text))
+	}
+	delete(s.scores, job.URL)
+	for i := range links {
+		links[i].Priority = (score + s.similarity(linkTerms(links[i]))) / 2
+	}
+}
+
+// Profiles returns the term frequencies the topic was learned from, saved with the crawl state
+func (s *focusedStrategy) Profiles() []TopicProfile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.profiles
+}
+
+// LoadProfiles restores the topic of a resumed crawl, the keywords of the config win over the saved seed pages
+func (s *focusedStrategy) LoadProfiles(profiles []TopicProfile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keywords {
+		return
+	}
+	s.profiles = profiles
+	s.weighted = nil
+}
+
+// similarity returns the cosine similarity of the terms to the closest profile, with TF-IDF weights
+func (s *focusedStrategy) similarity(terms map[string]float64) float64 {
+	if s.weighted == nil || s.docs-s.weightedAt >= FOCUS_REWEIGHT_PAGES {
+		s.weighted = make([]TopicProfile, 0, len(s.profiles))
+		for _, profile := range s.profiles {
+			s.weighted = append(s.weighted, s.unit(profile))
+		}
+		s.weightedAt = s.docs
+	}
+	vector := s.unit(terms)
+	best := 0.0
+	for _, profile := range s.weighted {
+		dot := 0.0
+		for term, weight := range vector {
+			dot += weight * profile[term]
+		}
+		best = max(best, dot)
+	}
+	return best
+}
+
+// unit returns the TF-IDF vector of the term frequencies scaled to length 1
+func (s *focusedStrategy) unit(terms map[string]float64) TopicProfile {
+	vector := make(TopicProfile, len(terms))
+	norm := 0.0
+	for term, count := range terms {
+		// terms of every page weigh little, terms no page had yet weigh the most
+		weight := count * (math.Log(float64(s.docs+1)/float64(s.df[term]+1)) + 1)
+		vector[term] = weight
+		norm += weight * weight
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for term := range vector {
+		vector[term] /= norm
+	}
+	return vector
+}
+
+// linkTerms returns the words of the anchor of the link and of the last segment of its path,
+// "/wiki/Quantum_mechanics" is "quantum mechanics"
+func linkTerms(job Job) map[string]float64 {
+	text := job.Anchor
+	if u, err := url.Parse(job.URL); err == nil {
+		if segment, err := url.PathUnescape(path.Base(u.Path)); err == nil {
+			text += " " + segment
+		}
+	}
+	return termVector(text)
+}
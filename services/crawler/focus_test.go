@@ -0,0 +1,98 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+const PHYSICS_TEXT = "physics studies matter energy motion forces quantum mechanics relativity particles"
+const COOKING_TEXT = "cooking recipes bake bread butter flour oven kitchen dinner dessert"
+
+func TestFocusedScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		keywords  []string
+		threshold float64
+		job       Job
+		text      string
+		follow    bool
+	}{
+		{"seed pages are always followed", nil, 0.9, Job{URL: "https://a.com/cooking"}, COOKING_TEXT, true},
+		{"on topic page", nil, 0.3, Job{URL: "https://a.com/physics2", Depth: 1}, PHYSICS_TEXT + " experiments", true},
+		{"off topic page", nil, 0.3, Job{URL: "https://a.com/cooking", Depth: 1}, COOKING_TEXT, false},
+		{"no threshold", nil, 0, Job{URL: "https://a.com/cooking", Depth: 1}, COOKING_TEXT, true},
+		{"keywords define the topic", []string{"bread", "butter", "oven"}, 0.1, Job{URL: "https://a.com/cooking", Depth: 1}, COOKING_TEXT, true},
+		{"keywords instead of the seed pages", []string{"bread", "butter", "oven"}, 0.1, Job{URL: "https://a.com/physics2", Depth: 1}, PHYSICS_TEXT, false},
+	}
+	for _, test := range tests {
+		s := newFocusedStrategy(test.keywords, test.threshold)
+		// the seed page is about physics
+		s.Score(Job{URL: "https://a.com/physics"}, PHYSICS_TEXT)
+		score, follow := s.Score(test.job, test.text)
+		if follow != test.follow {
+			t.Errorf("%s: relevance %v, follow %v, want %v", test.name, score, follow, test.follow)
+		}
+	}
+}
+
+func TestFocusedPrioritize(t *testing.T) {
+	s := newFocusedStrategy(nil, 0)
+	seed := Job{URL: "https://a.com/wiki/Physics"}
+	s.Score(seed, PHYSICS_TEXT)
+	links := []Job{
+		{URL: "https://a.com/wiki/Bread", Anchor: "bread"},
+		{URL: "https://a.com/wiki/Quantum_mechanics"},
+		{URL: "https://a.com/wiki/Other", Anchor: "forces and motion"},
+	}
+	s.Prioritize(seed, PHYSICS_TEXT, links)
+	// words of the url and of the anchor count
+	if links[1].Priority <= links[0].Priority || links[2].Priority <= links[0].Priority {
+		t.Errorf("priorities %v, %v, %v, want the off topic link last", links[0].Priority, links[1].Priority, links[2].Priority)
+	}
+	if len(s.scores) != 0 {
+		t.Errorf("scores of prioritized pages are kept: %v", s.scores)
+	}
+}
+
+func TestFocusedLoadProfiles(t *testing.T) {
+	saved := []TopicProfile{TopicProfile(termVector(COOKING_TEXT))}
+	tests := []struct {
+		name     string
+		keywords []string
+		want     []TopicProfile
+	}{
+		{"resumed crawl keeps the seed topic", nil, saved},
+		{"keywords of the config win", []string{"physics"}, []TopicProfile{{"physics": 1}}},
+	}
+	for _, test := range tests {
+		s := newFocusedStrategy(test.keywords, 0.5)
+		s.LoadProfiles(saved)
+		profiles := s.Profiles()
+		if len(profiles) != len(test.want) {
+			t.Fatalf("%s: %d profiles, want %d", test.name, len(profiles), len(test.want))
+		}
+		for i := range profiles {
+			if !maps.Equal(profiles[i], test.want[i]) {
+				t.Errorf("%s: profile %v, want %v", test.name, profiles[i], test.want[i])
+			}
+		}
+	}
+}
+
+func TestLinkTerms(t *testing.T) {
+	tests := []struct {
+		job  Job
+		want []string
+	}{
+		{Job{URL: "https://a.com/wiki/Quantum_mechanics"}, []string{"quantum", "mechanics"}},
+		{Job{URL: "https://a.com/wiki/Caf%C3%A9", Anchor: "Coffee house"}, []string{"coffee", "house", "café"}},
+	}
+	for _, test := range tests {
+		terms := linkTerms(test.job)
+		for _, want := range test.want {
+			if terms[want] == 0 {
+				t.Errorf("linkTerms(%s) = %v, missing %q", test.job.URL, terms, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// number of spilled jobs read back from disk at once
+const SPILL_BATCH = 1000
+
+// errSpilledMissing is returned by refill when the spilled jobs aren't on disk anymore
+var errSpilledMissing = errors.New("spilled jobs are missing")
+
+// queuedJob is a job in a priority queue, seq keeps jobs with the same priority in FIFO order
+type queuedJob struct {
+	job Job
+	seq uint64
+}
+
+// before reports whether a is handed out before b
+func (a queuedJob) before(b queuedJob) bool {
+	if a.job.Priority != b.job.Priority {
+		return a.job.Priority > b.job.Priority
+	}
+	return a.seq < b.seq
+}
+
+// jobHeap is a container/heap of jobs, highest priority first
+type jobHeap []queuedJob
+
+func (h jobHeap) Len() int           { return len(h) }
+func (h jobHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+func (h jobHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x any)        { *h = append(*h, x.(queuedJob)) }
+func (h *jobHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+/*
+Frontier is an unbounded priority queue of jobs waiting to be dispatched, jobs with the
+same priority come out in FIFO order. Up to memoryLimit jobs are kept in memory, the rest
+spill to the crawl state on disk and are read back in order once the memory drains,
+so priorities are only compared between the jobs in memory. Pushing never blocks.
+
+memory: jobs of the queue that are in memory
+spilled: number of jobs on disk, they are always newer than the jobs in memory
+nextSeq: sequence number of the next spilled job
+pushed: sequence number of the next job put into memory
+lost: called without the lock with the number of spilled jobs that couldn't be read back
+*/
+type Frontier struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	memory      jobHeap
+	memoryLimit int
+	spilled     int
+	nextSeq     uint64
+	pushed      uint64
+	state       *CrawlState
+	closed      bool
+	lost        func(n int)
+}
+
+// NewFrontier creates an empty frontier that spills to the given crawl state
+func NewFrontier(state *CrawlState, memoryLimit int) *Frontier {
+	// spilled jobs of a previous run are restored from the pending jobs instead
+	err := state.ClearSpilled()
+	if err != nil {
+		fmt.Println("Error clearing spilled jobs", err)
+	}
+	f := &Frontier{
+		memory:      make(jobHeap, 0),
+		memoryLimit: memoryLimit,
+		state:       state,
+	}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// PushAll adds jobs to the queue
+func (f *Frontier) PushAll(jobs []Job) {
+	if len(jobs) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// fill the memory first, but only while nothing is on disk so the order is kept
+	if f.spilled == 0 {
+		n := min(max(f.memoryLimit-len(f.memory), 0), len(jobs))
+		f.push(jobs[:n])
+		jobs = jobs[n:]
+	}
+	if len(jobs) > 0 {
+		err := f.state.SaveSpilled(f.nextSeq, jobs)
+		if err != nil {
+			// keep them in memory rather than losing them
+			fmt.Println("Error spilling jobs to disk", err)
+			f.push(jobs)
+		} else {
+			f.spilled += len(jobs)
+			f.nextSeq += uint64(len(jobs))
+		}
+	}
+	f.cond.Broadcast()
+}
+
+// Pop blocks until a job is available and removes the job with the highest priority.
+// It returns false once the frontier is closed and empty.
+func (f *Frontier) Pop() (Job, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		if len(f.memory) == 0 && f.spilled > 0 {
+			err := f.refill()
+			if errors.Is(err, badger.ErrConflict) {
+				// the jobs are still on disk, try again later
+				fmt.Println("Error reading spilled jobs", err)
+				f.mu.Unlock()
+				time.Sleep(time.Second)
+				f.mu.Lock()
+				continue
+			}
+			if err != nil {
+				// retrying can't bring them back, they are still pending for the next run
+				lost := f.spilled
+				fmt.Println("Error reading spilled jobs, giving up on", lost, "of them", err)
+				f.spilled = 0
+				err = f.state.ClearSpilled()
+				if err != nil {
+					fmt.Println("Error clearing spilled jobs", err)
+				}
+				if f.lost != nil {
+					f.mu.Unlock()
+					f.lost(lost)
+					f.mu.Lock()
+				}
+				continue
+			}
+		}
+		if len(f.memory) > 0 {
+			return heap.Pop(&f.memory).(queuedJob).job, true
+		}
+		if f.closed {
+			return Job{}, false
+		}
+		f.cond.Wait()
+	}
+}
+
+// refill moves the oldest spilled jobs back into memory
+func (f *Frontier) refill() error {
+	jobs, err := f.state.LoadSpilled(max(min(SPILL_BATCH, f.memoryLimit), 1))
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("%d %w", f.spilled, errSpilledMissing)
+	}
+	f.push(jobs)
+	f.spilled -= len(jobs)
+	return nil
+}
+
+// push puts the jobs into memory
+func (f *Frontier) push(jobs []Job) {
+	for _, job := range jobs {
+		heap.Push(&f.memory, queuedJob{job: job, seq: f.pushed})
+		f.pushed++
+	}
+}
+
+// Len returns the number of queued jobs in memory and on disk
+func (f *Frontier) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.memory) + f.spilled
+}
+
+// Close makes Pop return false once the queue is empty
+func (f *Frontier) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	f.cond.Broadcast()
+}
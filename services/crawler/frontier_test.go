@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFrontierOrder(t *testing.T) {
+	// jobs 0 to 9, the priority of job i is priority(i)
+	tests := []struct {
+		name        string
+		memoryLimit int
+		batches     []int
+		priority    func(i int) float64
+		want        []int
+	}{
+		{"priorities in memory", 100, []int{10}, func(i int) float64 { return float64(i % 3) },
+			[]int{2, 5, 8, 1, 4, 7, 0, 3, 6, 9}},
+		{"same priority spilled in fifo order", 3, []int{4, 4, 2}, func(i int) float64 { return 0 },
+			[]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		// the first 2 jobs fill the memory, the rest is read back in batches of 2
+		{"priorities of spilled jobs are compared per batch", 2, []int{10}, func(i int) float64 { return float64(i) },
+			[]int{1, 0, 3, 2, 5, 4, 7, 6, 9, 8}},
+	}
+	for _, test := range tests {
+		frontier := NewFrontier(openTestState(t), test.memoryLimit)
+		i := 0
+		for _, size := range test.batches {
+			var jobs []Job
+			for range size {
+				jobs = append(jobs, Job{URL: fmt.Sprint(i), Priority: test.priority(i)})
+				i++
+			}
+			frontier.PushAll(jobs)
+		}
+		if frontier.Len() != i {
+			t.Errorf("%s: Len() = %d, want %d", test.name, frontier.Len(), i)
+		}
+		frontier.Close()
+
+		var got []int
+		for {
+			job, ok := frontier.Pop()
+			if !ok {
+				break
+			}
+			var n int
+			fmt.Sscan(job.URL, &n)
+			got = append(got, n)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: popped %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFrontierClearsSpilled(t *testing.T) {
+	state := openTestState(t)
+	frontier := NewFrontier(state, 1)
+	frontier.PushAll([]Job{{URL: "a"}, {URL: "b"}, {URL: "c"}})
+
+	// a new run restores its jobs from the pending jobs, not from the spilled ones
+	frontier = NewFrontier(state, 1)
+	frontier.PushAll([]Job{{URL: "d"}, {URL: "e"}})
+	frontier.Close()
+	var got []string
+	for {
+		job, ok := frontier.Pop()
+		if !ok {
+			break
+		}
+		got = append(got, job.URL)
+	}
+	if !slices.Equal(got, []string{"d", "e"}) {
+		t.Errorf("popped %v, want [d e]", got)
+	}
+}
+
+func TestFrontierSpilledMissing(t *testing.T) {
+	state := openTestState(t)
+	frontier := NewFrontier(state, 1)
+	lost := 0
+	frontier.lost = func(n int) { lost += n }
+	frontier.PushAll([]Job{{URL: "a"}, {URL: "b"}, {URL: "c"}})
+	// the spilled jobs disappear from disk
+	err := state.ClearSpilled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frontier.Close()
+
+	done := make(chan []string)
+	go func() {
+		var got []string
+		for {
+			job, ok := frontier.Pop()
+			if !ok {
+				break
+			}
+			got = append(got, job.URL)
+		}
+		done <- got
+	}()
+	select {
+	case got := <-done:
+		if !slices.Equal(got, []string{"a"}) {
+			t.Errorf("popped %v, want [a]", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pop retries the missing jobs")
+	}
+	if lost != 2 || frontier.Len() != 0 {
+		t.Errorf("%d jobs lost and %d queued, want 2 lost", lost, frontier.Len())
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// number of edges written at once
+const LINK_BATCH = 5000
+
+// longest anchor text kept, in bytes
+const MAX_ANCHOR_LENGTH = 200
+
+// Link is an edge of the link graph, from a stored page to the url it links to
+type Link struct {
+	SourceHash string
+	SourceURL  string
+	TargetURL  string
+	Anchor     string
+}
+
+// LinkStore saves the link graph, separately from the pages because it has far more entries
+type LinkStore interface {
+	// SaveLinks queues the edges, they are written in batches
+	SaveLinks(links []Link) error
+	// Flush writes the queued edges
+	Flush() error
+	// Close writes the queued edges and releases the store
+	Close() error
+}
+
+/*
+linkQueue batches edges for a LinkStore. A batch that fails to write stays queued
+and is written again by the next flush.
+
+write: writes a full batch, called with the lock held
+*/
+type linkQueue struct {
+	mu    sync.Mutex
+	queue []Link
+	write func(links []Link) error
+}
+
+func (q *linkQueue) SaveLinks(links []Link) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// a full queue is written first, when that fails the edges aren't queued and can be saved again
+	if len(q.queue) >= LINK_BATCH {
+		err := q.flush()
+		if err != nil {
+			return err
+		}
+	}
+	q.queue = append(q.queue, links...)
+	return nil
+}
+
+func (q *linkQueue) Flush() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.flush()
+}
+
+func (q *linkQueue) flush() error {
+	if len(q.queue) == 0 {
+		return nil
+	}
+	err := q.write(q.queue)
+	if err != nil {
+		return err
+	}
+	q.queue = make([]Link, 0, LINK_BATCH)
+	return nil
+}
+
+// MongoLinkStore writes the edges to the links collection, indexed by source and target
+type MongoLinkStore struct {
+	linkQueue
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+// NewMongoLinkStore creates the links collection and its indexes if they don't exist
+func NewMongoLinkStore(client *mongo.Client) (*MongoLinkStore, error) {
+	coll := client.Database("crawler").Collection("links")
+	_, err := coll.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sourcehash", Value: 1}}},
+		{Keys: bson.D{{Key: "targeturl", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &MongoLinkStore{client: client, coll: coll}
+	s.write = s.insert
+	return s, nil
+}
+
+// Close writes the queued edges and disconnects the client of the store
+func (s *MongoLinkStore) Close() error {
+	err := s.Flush()
+	if disconnectErr := s.client.Disconnect(context.Background()); err == nil {
+		err = disconnectErr
+	}
+	return err
+}
+
+func (s *MongoLinkStore) insert(links []Link) error {
+	docs := make([]interface{}, 0, len(links))
+	for _, link := range links {
+		docs = append(docs, link)
+	}
+	_, err := s.coll.InsertMany(context.Background(), docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+/*
+FileLinkStore appends the edges of a crawl to a gzipped TSV file, every batch is its own gzip member:
+
+	<dir>/links/<crawl id>.tsv.gz   source hash, source url, target url, anchor text
+
+Tabs and line breaks in anchors are replaced by spaces.
+*/
+type FileLinkStore struct {
+	linkQueue
+	path string
+}
+
+// NewFileLinkStore creates a store writing to dir/links
+func NewFileLinkStore(dir string, crawlID string) (*FileLinkStore, error) {
+	err := os.MkdirAll(filepath.Join(dir, "links"), 0755)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileLinkStore{path: filepath.Join(dir, "links", crawlID+".tsv.gz")}
+	s.write = s.append
+	return s, nil
+}
+
+func (s *FileLinkStore) Close() error {
+	return s.Flush()
+}
+
+func (s *FileLinkStore) append(links []Link) error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	gz := gzip.NewWriter(writer)
+	for _, link := range links {
+		fmt.Fprintf(gz, "%s\t%s\t%s\t%s\n", link.SourceHash, link.SourceURL, link.TargetURL, link.Anchor)
+	}
+	err = gz.Close()
+	if err == nil {
+		err = writer.Flush()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// newLinkStore creates the link store that goes with the storage backend of the config
+func newLinkStore(config *Config) (LinkStore, error) {
+	if config.StorageBackend == STORAGE_MINIO {
+		client, err := newMongoConnection(config.MongoUri, context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return NewMongoLinkStore(client)
+	}
+	return NewFileLinkStore(config.DataDir, config.CrawlID)
+}
+
+// normalizeAnchor collapses the whitespace of the anchor text and cuts it at MAX_ANCHOR_LENGTH
+func normalizeAnchor(anchor string) string {
+	return truncateUTF8(strings.Join(strings.Fields(anchor), " "), MAX_ANCHOR_LENGTH)
+}
+
+// truncateUTF8 cuts the text at n bytes without cutting a utf-8 sequence in half
+func truncateUTF8(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
+
+// countTargets returns the number of distinct urls the page at url links to
+func countTargets(url string, links []Outlink) int {
+	targets := make(map[string]bool)
+	for _, link := range links {
+		if link.URL != url {
+			targets[link.URL] = true
+		}
+	}
+	return len(targets)
+}
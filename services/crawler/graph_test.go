@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLinkQueueRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+	}{
+		{"no failures", 0},
+		{"one failed flush", 1},
+		{"several failed flushes", 3},
+	}
+	for _, test := range tests {
+		var written []Link
+		failures := test.failures
+		q := &linkQueue{}
+		q.write = func(links []Link) error {
+			if failures > 0 {
+				failures--
+				return errors.New("store unavailable")
+			}
+			written = append(written, links...)
+			return nil
+		}
+
+		var saved []Link
+		for i := range 3 * LINK_BATCH {
+			link := Link{SourceURL: "a", TargetURL: fmt.Sprint(i)}
+			// a save that fails is repeated, like the storage writer does
+			for q.SaveLinks([]Link{link}) != nil {
+			}
+			saved = append(saved, link)
+		}
+		for q.Flush() != nil {
+		}
+		if !slices.Equal(written, saved) {
+			t.Errorf("%s: wrote %d edges, want the %d saved ones once in order", test.name, len(written), len(saved))
+		}
+	}
+}
+
+func TestFileLinkStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileLinkStore(dir, "crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := []Link{
+		{SourceHash: "h1", SourceURL: "https://a.com/", TargetURL: "https://b.com/", Anchor: "b"},
+		{SourceHash: "h1", SourceURL: "https://a.com/", TargetURL: "https://c.com/", Anchor: ""},
+	}
+	// two flushes write two gzip members of one file
+	for _, link := range links {
+		err = store.SaveLinks([]Link{link})
+		if err == nil {
+			err = store.Flush()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	want := []string{"h1\thttps://a.com/\thttps://b.com/\tb", "h1\thttps://a.com/\thttps://c.com/\t"}
+	if !slices.Equal(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
+
+func TestNormalizeAnchor(t *testing.T) {
+	tests := []struct {
+		anchor string
+		want   string
+	}{
+		{"Quantum  mechanics", "Quantum mechanics"},
+		{"\n\tline\nbreaks\t", "line breaks"},
+		{strings.Repeat("a", MAX_ANCHOR_LENGTH+10), strings.Repeat("a", MAX_ANCHOR_LENGTH)},
+		// a multi-byte rune at the limit is left out instead of cut in half
+		{strings.Repeat("a", MAX_ANCHOR_LENGTH-1) + "é", strings.Repeat("a", MAX_ANCHOR_LENGTH-1)},
+	}
+	for _, test := range tests {
+		if got := normalizeAnchor(test.anchor); got != test.want {
+			t.Errorf("normalizeAnchor(%q) = %q, want %q", test.anchor, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// images with a smaller width or height are icons and decorations, not content
+const MIN_IMAGE_SIZE = 50
+
+// longest alt text and caption kept, in bytes
+const MAX_IMAGE_TEXT = 500
+
+// Image is an image shown on a stored page, with the text around it that describes it
+type Image struct {
+	// resolved url of the image
+	URL     string
+	Alt     string
+	Caption string
+	// size the page shows the image at, 0 when the page doesn't say
+	Width  int
+	Height int
+	// heading of the section the image is in, empty before the first heading
+	Section string
+	// page the image was found on
+	PageURL   string
+	PageHash  string
+	CrawledAt time.Time
+}
+
+// PageImages are the images of a page, a later entry for the same page replaces the earlier one
+type PageImages struct {
+	PageHash string
+	Images   []Image
+}
+
+// extractImages returns the images in the content that are in scope, once per url
+func extractImages(content *html.Node, scope *Scope, base *url.URL) []Image {
+	images := []Image{}
+	seen := make(map[string]bool)
+	section := ""
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if isHeading(n) {
+				section = cleanText(n)
+				return
+			}
+			if n.Data == "img" {
+				image, ok := readImage(n, scope, base)
+				if ok && !seen[image.URL] {
+					seen[image.URL] = true
+					image.Section = section
+					images = append(images, image)
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(content)
+	return images
+}
+
+// readImage reads an <img>, it returns false for images out of scope and images too small to be content
+func readImage(n *html.Node, scope *Scope, base *url.URL) (Image, bool) {
+	imageURL, err := scope.ResolveImage(base, getAttr(n, "src"))
+	if err != nil || imageURL == "" {
+		// lazy loaded images have a placeholder in src and the real urls in srcset
+		imageURL = srcsetImage(getAttr(n, "srcset"), scope, base)
+	}
+	if imageURL == "" {
+		return Image{}, false
+	}
+	image := Image{
+		URL:     imageURL,
+		Alt:     truncateUTF8(strings.Join(strings.Fields(getAttr(n, "alt")), " "), MAX_IMAGE_TEXT),
+		Caption: truncateUTF8(imageCaption(n), MAX_IMAGE_TEXT),
+		Width:   imageSize(getAttr(n, "width")),
+		Height:  imageSize(getAttr(n, "height")),
+	}
+	if (image.Width > 0 && image.Width < MIN_IMAGE_SIZE) || (image.Height > 0 && image.Height < MIN_IMAGE_SIZE) {
+		return Image{}, false
+	}
+	return image, true
+}
+
+// srcsetImage returns the largest candidate of a srcset that is in scope, "a.jpg 1x, b.jpg 2x" or "a.jpg 220w, b.jpg 440w"
+func srcsetImage(srcset string, scope *Scope, base *url.URL) string {
+	best := ""
+	bestSize := 0.0
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		// a candidate without a descriptor is 1x
+		size := 1.0
+		if len(fields) > 1 {
+			parsed, err := strconv.ParseFloat(strings.TrimRight(fields[1], "xw"), 64)
+			if err != nil {
+				continue
+			}
+			size = parsed
+		}
+		imageURL, err := scope.ResolveImage(base, fields[0])
+		if err == nil && imageURL != "" && size > bestSize {
+			best = imageURL
+			bestSize = size
+		}
+	}
+	return best
+}
+
+// imageCaption returns the caption of the figure, thumbnail or gallery box the image is in
+func imageCaption(n *html.Node) string {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type != html.ElementNode {
+			continue
+		}
+		if getAttr(p, "id") == "mw-content-text" || p.Data == "body" {
+			return ""
+		}
+		var caption *html.Node
+		switch {
+		case p.Data == "figure":
+			caption = findNode(p, func(c *html.Node) bool { return c.Data == "figcaption" })
+		case hasClass(p, "thumbinner") || hasClass(p, "thumb"):
+			caption = findNode(p, func(c *html.Node) bool { return hasClass(c, "thumbcaption") })
+		case hasClass(p, "gallerybox"):
+			caption = findNode(p, func(c *html.Node) bool { return hasClass(c, "gallerytext") })
+		}
+		if caption != nil {
+			return cleanText(caption)
+		}
+	}
+	return ""
+}
+
+// imageSize parses a width or height attribute, "220" and "220px" are both 220
+func imageSize(value string) int {
+	size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "px"))
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+// imageURLs returns the urls of the first images, the ones kept in the page's metadata
+func imageURLs(images []Image) []string {
+	urls := make([]string, 0, min(len(images), MAX_IMAGES))
+	for _, image := range images[:min(len(images), MAX_IMAGES)] {
+		urls = append(urls, image.URL)
+	}
+	return urls
+}
+
+// withPage sets the page the images were found on
+func withPage(images []Image, docMetadata DocMetadata) []Image {
+	for i := range images {
+		images[i].PageURL = docMetadata.URL
+		images[i].PageHash = docMetadata.Hash
+		images[i].CrawledAt = docMetadata.CrawledAt
+	}
+	return images
+}
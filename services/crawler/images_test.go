@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// pageImages returns the images extractLinks finds in the content of the page
+func pageImages(t *testing.T, content string) ([]Image, DocMetadata) {
+	scope, err := NewScope(WikipediaScope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := DocMetadata{URL: "https://en.wikipedia.org/wiki/London"}
+	body := `<html><body><div id="mw-content-text">` + content + `</div></body></html>`
+	_, _, images := extractLinks([]byte(body), &doc, scope)
+	return images, doc
+}
+
+func TestExtractImages(t *testing.T) {
+	const upload = "https://upload.wikimedia.org/wikipedia/commons/"
+	tests := []struct {
+		name    string
+		content string
+		want    []Image
+	}{
+		{"alt and figcaption",
+			`<figure><a href="/wiki/File:Tower.jpg"><img src="//upload.wikimedia.org/wikipedia/commons/a/ab/Tower.jpg" alt=" Tower
+			of  London " width="220" height="147px"></a><figcaption>The <a href="/wiki/Tower">Tower</a><sup class="reference">[1]</sup></figcaption></figure>`,
+			[]Image{{URL: upload + "a/ab/Tower.jpg", Alt: "Tower of London", Caption: "The Tower", Width: 220, Height: 147}}},
+		{"thumbcaption of the old skin",
+			`<div class="thumb tright"><div class="thumbinner"><img src="https://upload.wikimedia.org/a.jpg"><div class="thumbcaption">Old caption</div></div></div>`,
+			[]Image{{URL: "https://upload.wikimedia.org/a.jpg", Caption: "Old caption"}}},
+		{"gallery text",
+			`<ul class="gallery"><li class="gallerybox"><img src="https://upload.wikimedia.org/g.jpg"><div class="gallerytext">In the gallery</div></li></ul>`,
+			[]Image{{URL: "https://upload.wikimedia.org/g.jpg", Caption: "In the gallery"}}},
+		{"no caption outside a figure",
+			`<p><img src="https://upload.wikimedia.org/a.jpg" alt="a"></p><figcaption>not this one</figcaption>`,
+			[]Image{{URL: "https://upload.wikimedia.org/a.jpg", Alt: "a"}}},
+		{"relative src is resolved against the page",
+			`<img src="/static/images/logo.png"><img src="//upload.wikimedia.org/wikipedia/commons/thumb/b.png">`,
+			[]Image{{URL: upload + "thumb/b.png"}}},
+		{"largest srcset candidate of a lazy loaded image",
+			`<img src="data:image/gif;base64,R0lGODlhAQABAAAAACH5BAEKAAEALAAAAAABAAEAAAICTAEAOw==" srcset="//upload.wikimedia.org/c-220.jpg 1x, //upload.wikimedia.org/c-440.jpg 2x,  //upload.wikimedia.org/c-330.jpg 1.5x">`,
+			[]Image{{URL: "https://upload.wikimedia.org/c-440.jpg"}}},
+		{"srcset with widths and hosts out of scope",
+			`<img srcset="https://example.com/d-800.jpg 800w, //upload.wikimedia.org/d-440.jpg 440w, //upload.wikimedia.org/d-220.jpg 220w">`,
+			[]Image{{URL: "https://upload.wikimedia.org/d-440.jpg"}}},
+		{"src wins over srcset",
+			`<img src="//upload.wikimedia.org/e.jpg" srcset="//upload.wikimedia.org/e-2x.jpg 2x">`,
+			[]Image{{URL: "https://upload.wikimedia.org/e.jpg"}}},
+		{"duplicates are kept once",
+			`<img src="https://upload.wikimedia.org/a.jpg" alt="first"><img src="//upload.wikimedia.org/a.jpg#x" alt="second">`,
+			[]Image{{URL: "https://upload.wikimedia.org/a.jpg", Alt: "first"}}},
+		{"icons are skipped",
+			`<img src="https://upload.wikimedia.org/icon.png" width="20" height="20"><img src="https://upload.wikimedia.org/flag.png" height="30">`,
+			[]Image{}},
+		{"section of the image",
+			`<img src="https://upload.wikimedia.org/1.jpg"><h2 id="History">History<span class="mw-editsection">[edit]</span></h2><img src="https://upload.wikimedia.org/2.jpg">`,
+			[]Image{{URL: "https://upload.wikimedia.org/1.jpg"}, {URL: "https://upload.wikimedia.org/2.jpg", Section: "History"}}},
+	}
+	for _, test := range tests {
+		images, doc := pageImages(t, test.content)
+		if !slices.Equal(images, test.want) {
+			t.Errorf("%s: images %+v, want %+v", test.name, images, test.want)
+		}
+		if !slices.Equal(doc.Images, imageURLs(test.want)) {
+			t.Errorf("%s: metadata images %v", test.name, doc.Images)
+		}
+	}
+}
+
+func TestImageTextLimit(t *testing.T) {
+	long := strings.Repeat("ü", MAX_IMAGE_TEXT)
+	images, _ := pageImages(t, `<figure><img src="https://upload.wikimedia.org/a.jpg" alt="`+long+`"><figcaption>`+long+`</figcaption></figure>`)
+	if len(images) != 1 {
+		t.Fatalf("images %+v", images)
+	}
+	for _, text := range []string{images[0].Alt, images[0].Caption} {
+		if len(text) > MAX_IMAGE_TEXT || !strings.HasPrefix(long, text) {
+			t.Errorf("text of %d bytes isn't cut at a rune boundary under %d", len(text), MAX_IMAGE_TEXT)
+		}
+	}
+}
+
+func TestImageCap(t *testing.T) {
+	var content strings.Builder
+	for i := range MAX_IMAGES + 3 {
+		fmt.Fprintf(&content, `<img src="https://upload.wikimedia.org/%d.jpg">`, i)
+	}
+	images, doc := pageImages(t, content.String())
+	// every image goes to the image collection, the metadata keeps the first ones
+	if len(images) != MAX_IMAGES+3 {
+		t.Errorf("%d images, want %d", len(images), MAX_IMAGES+3)
+	}
+	if len(doc.Images) != MAX_IMAGES || doc.Images[0] != "https://upload.wikimedia.org/0.jpg" {
+		t.Errorf("metadata images %v, want the first %d", doc.Images, MAX_IMAGES)
+	}
+}
+
+func TestWithPage(t *testing.T) {
+	doc := DocMetadata{URL: "https://en.wikipedia.org/wiki/London", Hash: "hash"}
+	images := withPage([]Image{{URL: "a"}, {URL: "b"}}, doc)
+	for _, image := range images {
+		if image.PageURL != doc.URL || image.PageHash != doc.Hash {
+			t.Errorf("image %+v doesn't have its page", image)
+		}
+	}
+}
@@ -3,15 +3,123 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	config := NewConfig()
-	storage := NewMinioMongoStorage(config.MongoUri, config.MinioClient, context.Background())
-	crawler := NewCrawler(storage, config)
-	err := crawler.Start()
+	config, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	scope, err := NewScope(config.Scope)
+	if err != nil {
+		log.Fatalf("Invalid crawl scope: %v", err)
+	}
+	backend, err := newStorage(config)
+	if err != nil {
+		log.Fatalf("Failed to create storage: %v", err)
+	}
+	// pages and metadata are written in the background, closing the writer waits for them
+	// and closes the storages writing to files
+	storage := NewStorageWriter(backend, config)
+	defer func() {
+		err := storage.Close()
+		if err != nil {
+			fmt.Println("Error closing storage", err)
+		}
+	}()
+
+	// the first SIGINT or SIGTERM shuts down gracefully, a second one exits at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	// revisit the stored pages instead of discovering new ones
+	if config.Mode == MODE_RECRAWL {
+		err := NewRecrawler(storage, config, scope).Start(ctx)
+		if err != nil {
+			fmt.Println("Error recrawling", err)
+		}
+		return
+	}
+
+	// read articles from a dump instead of fetching them
+	if config.Mode == MODE_DUMP {
+		err := NewDumpIngester(storage, config).Start(ctx)
+		if err != nil {
+			fmt.Println("Error ingesting dump", err)
+		}
+		return
+	}
+
+	// summarize the fetch log of a finished crawl
+	if config.Mode == MODE_REPORT {
+		err := PrintReport(storage, config.CrawlID, os.Stdout)
+		if err != nil {
+			fmt.Println("Error creating report", err)
+		}
+		return
+	}
+
+	state, err := OpenCrawlState(config.StateDir, config.CrawlID)
+	if err != nil {
+		log.Fatalf("Failed to open crawl state: %v", err)
+	}
+	defer state.Close()
+
+	links, err := newLinkStore(config)
+	if err != nil {
+		log.Fatalf("Failed to create link store: %v", err)
+	}
+	// edges are written in the background with the pages
+	links = storage.Links(links)
+	// deferred after the storage, so it is closed while the writer still runs
+	defer func() {
+		err := links.Close()
+		if err != nil {
+			fmt.Println("Error closing link store", err)
+		}
+	}()
+
+	crawler := NewCrawler(storage, config, state, scope, links)
+	// instances of a distributed crawl send each other the links they don't own
+	if len(config.Peers) > 0 {
+		peers := NewPeerServer(config.Node, crawler)
+		peers.Start()
+		defer peers.Close()
+	}
+	// the admin api watches and controls the crawl while it runs
+	if config.AdminAddr != "" {
+		admin := NewAdminServer(config.AdminAddr, crawler)
+		admin.Start()
+		defer admin.Close()
+	}
+	err = crawler.Start(ctx)
 	if err != nil {
 		fmt.Println("Error starting crawler", err)
 		return
 	}
 }
+
+// newStorage creates the storage backend named in the config
+func newStorage(config *Config) (Storage, error) {
+	switch config.StorageBackend {
+	case STORAGE_MINIO:
+		minioClient, err := newR2Client()
+		if err != nil {
+			return nil, err
+		}
+		return NewMinioMongoStorage(config.MongoUri, minioClient, context.Background()), nil
+	case STORAGE_FILESYSTEM:
+		return NewFilesystemStorage(config.DataDir, config.PagesDir, config.MetadataDir)
+	case STORAGE_WARC:
+		return NewWarcStorage(config.DataDir, config.CrawlID, config.UserAgent)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", config.StorageBackend)
+}
@@ -2,98 +2,49 @@ package main
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"net/url"
 	"strings"
 
 	"golang.org/x/net/html"
 )
 
-// Returns the body of the page
-func fetch(url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("User-Agent", "GoogleClone-Crawler/1.0 (Educational Project)")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	// Check if the response is successful
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	return body, nil
-}
-
-func handleHref(href string) (string, error) {
-	if strings.Contains(href, "Special:") || strings.Contains(href, "File:") || strings.Contains(href, "User:") || strings.Contains(href, "Help:") || strings.Contains(href, "Portal:") || strings.Contains(href, "Wikipedia:") || strings.Contains(href, "Template_talk:") || strings.Contains(href, "User_talk:") || strings.Contains(href, "Talk:") || strings.Contains(href, "Category:") || strings.Contains(href, "Template:") || strings.Contains(href, ":") {
-		return "", errors.New("href is a special page")
-	}
-	if strings.HasPrefix(href, "#") {
-		msg := fmt.Sprintf("href %s is a fragment", href)
-		return "", errors.New(msg)
-	}
-	res := ""
-	if strings.HasPrefix(href, "/") {
-		res = "https://en.wikipedia.org" + href
-	}
-	return res, nil
-}
-
-func handleImageSrc(src string) (string, error) {
-	// only accept images from wikipedia and skip the wikipedia logo
-	if !strings.Contains(src, "upload.wikimedia.org") {
-		return "", errors.New("image src is not from wikipedia or is the wikipedia logo")
-	}
-	if strings.HasPrefix(src, "//") {
-		src = "https:" + src
-	} else if strings.HasPrefix(src, "/") {
-		src = "https://en.wikipedia.org" + src
+// Returns the value of the attribute, empty if the node doesn't have it
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
 	}
-	return src, nil
+	return ""
 }
 
-// Helper function to extract all text content from a node
-func getTextContent(n *html.Node) string {
-	if n.Type == html.TextNode {
-		return n.Data
-	}
-
+// visibleText returns the text of the node without scripts and styles, with the words of
+// different elements separated
+func visibleText(n *html.Node) string {
 	var text strings.Builder
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		text.WriteString(getTextContent(c))
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style" || n.Data == "noscript") {
+			return
+		}
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+			text.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
 	}
+	walk(n)
 	return text.String()
 }
 
 func processBodyOfDoc(n *html.Node, docMetadata *DocMetadata) {
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		// Extract first paragraph with proper text handling
-		if c.Type == html.ElementNode && c.Data == "p" && docMetadata.FirstParagraph == "" {
-			paragraphText := getTextContent(c)
-			if len(strings.TrimSpace(paragraphText)) > 0 {
-				docMetadata.FirstParagraph = strings.TrimSpace(paragraphText)
-			}
-		}
-
-		// Extract images
-		if c.Type == html.ElementNode && c.Data == "img" && len(docMetadata.Images) < 5 {
-			for _, attr := range c.Attr {
-				if attr.Key == "src" {
-					imageUrl, _ := handleImageSrc(attr.Val)
-					docMetadata.Images = append(docMetadata.Images, imageUrl)
-					break
-				}
-			}
+		// Extract the first paragraph of prose, hatnotes, infoboxes and empty paragraphs are skipped
+		if c.Type == html.ElementNode && c.Data == "p" && docMetadata.FirstParagraph == "" && !isBoilerplate(c) {
+			docMetadata.FirstParagraph = cleanText(c)
 		}
 
 		// Recursively process child nodes
@@ -101,34 +52,60 @@ func processBodyOfDoc(n *html.Node, docMetadata *DocMetadata) {
 	}
 }
 
-// Returns the links on the page
-func extractLinks(body []byte, docMetadata *DocMetadata) []string {
+// Outlink is a link on a page with the text of its anchor
+type Outlink struct {
+	URL    string
+	Anchor string
+}
 
-	var links []string
+// Returns the links on the page that are in scope, the text of its content and the images in it,
+// the text and images of the whole body when there is no article content.
+// A <link rel="canonical"> in scope replaces the url in the metadata.
+func extractLinks(body []byte, docMetadata *DocMetadata, scope *Scope) ([]Outlink, string, []Image) {
+	// links and images are resolved against the page's url
+	base, err := url.Parse(docMetadata.URL)
+	if err != nil {
+		fmt.Println("Error parsing url", err)
+		return []Outlink{}, "", []Image{}
+	}
+
+	var links []Outlink
+	var content, bodyNode *html.Node
 	var traverse func(*html.Node)
 	traverse = func(n *html.Node) {
 		if n.Type == html.ElementNode && n.Data == "a" {
 			for _, attr := range n.Attr {
 				if attr.Key == "href" {
-					href, err := handleHref(attr.Val)
+					href, err := scope.ResolveLink(base, attr.Val)
 					if err != nil {
 						continue
 					}
 					if href == "" {
 						continue
 					}
-					links = append(links, href)
+					links = append(links, Outlink{URL: href, Anchor: normalizeAnchor(visibleText(n))})
 				}
 			}
 		}
+		// handle the canonical url of the page
+		if n.Type == html.ElementNode && n.Data == "link" && getAttr(n, "rel") == "canonical" {
+			canonical, err := scope.ResolveLink(base, getAttr(n, "href"))
+			if err == nil {
+				docMetadata.URL = canonical
+			}
+		}
 		// process the body of the document
 		if n.Type == html.ElementNode && n.Data == "div" {
 			for _, attr := range n.Attr {
 				if attr.Key == "id" && attr.Val == "mw-content-text" {
 					processBodyOfDoc(n, docMetadata)
+					content = n
 				}
 			}
 		}
+		if n.Type == html.ElementNode && n.Data == "body" {
+			bodyNode = n
+		}
 		// handle title
 		if n.Type == html.ElementNode && n.Data == "title" && n.FirstChild != nil {
 			docMetadata.Title = n.FirstChild.Data
@@ -140,8 +117,17 @@ func extractLinks(body []byte, docMetadata *DocMetadata) []string {
 	doc, err := html.Parse(bytes.NewReader(body))
 	if err != nil {
 		fmt.Println("Error parsing", err)
-		return []string{}
+		return []Outlink{}, "", []Image{}
 	}
 	traverse(doc)
-	return links
+	extractStructure(doc, docMetadata)
+	if content == nil {
+		content = bodyNode
+	}
+	if content == nil {
+		return links, "", []Image{}
+	}
+	images := extractImages(content, scope, base)
+	docMetadata.Images = imageURLs(images)
+	return links, visibleText(content), images
 }
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// kinds of document changes
+const CHANGE_CHANGED = "changed"
+const CHANGE_REMOVED = "removed"
+
+// DocChange tells the indexer that a stored document changed or disappeared
+type DocChange struct {
+	URL        string
+	Kind       string
+	OldHash    string
+	NewHash    string
+	DetectedAt time.Time
+}
+
+/*
+Recrawler revisits stored pages whose recrawl time has come, using conditional requests.
+
+jobs: pages not revisited yet, including the ones waiting for a retry
+
+docs: stored metadata of the pages being revisited, by url
+changes: changed and removed documents found so far
+duplicates: fingerprints of the stored pages, nil when near duplicates aren't detected
+*/
+type Recrawler struct {
+	storage   Storage
+	config    *Config
+	fetcher   *Fetcher
+	fetchLog  *FetchLogger
+	robots    *Robots
+	scheduler *Scheduler
+	wg        *sync.WaitGroup
+	jobs      *sync.WaitGroup
+	mu        sync.Mutex
+	scope     *Scope
+	docs      map[string]DocMetadata
+	changes   []DocChange
+	unchanged int
+	failed    int
+
+	duplicates *NearDuplicates
+}
+
+// NewRecrawler creates a recrawler with the given storage, config and scope
+func NewRecrawler(storage Storage, config *Config, scope *Scope) *Recrawler {
+	fetcher := NewFetcher(config)
+	robots := NewRobots(fetcher.Client(), config.UserAgent)
+	r := &Recrawler{
+		storage:   storage,
+		config:    config,
+		fetcher:   fetcher,
+		fetchLog:  NewFetchLogger(storage, config.CrawlID),
+		robots:    robots,
+		scheduler: NewScheduler(config, robots),
+		wg:        &sync.WaitGroup{},
+		jobs:      &sync.WaitGroup{},
+		scope:     scope,
+		docs:      make(map[string]DocMetadata),
+		changes:   make([]DocChange, 0),
+	}
+	if config.NearDuplicates != DUPLICATES_OFF {
+		r.duplicates = NewNearDuplicates(config.NearDuplicateDistance)
+	}
+	return r
+}
+
+// Start revisits every page that is due and saves the changes it found.
+// Canceling ctx ends the recrawl early, the pages not revisited stay due.
+func (r *Recrawler) Start(ctx context.Context) error {
+	t := time.Now()
+	docs, err := r.storage.ListMetadata()
+	if err != nil {
+		return err
+	}
+
+	// only pages whose recrawl time has come, in a distributed crawl only the ones of this instance's hosts
+	var ring *Ring
+	if len(r.config.Peers) > 0 {
+		ring = NewRing(r.config.Peers)
+	}
+	due := make([]DocMetadata, 0)
+	for _, doc := range docs {
+		if doc.NextCrawlAt.After(t) {
+			continue
+		}
+		if ring != nil && ring.Owner(hostOf(doc.URL)) != r.config.Node {
+			continue
+		}
+		r.docs[doc.URL] = doc
+		due = append(due, doc)
+	}
+	fmt.Println("Recrawling", len(due), "of", len(docs), "pages")
+	// changed pages are checked against all stored pages
+	if r.duplicates != nil {
+		r.duplicates.Load(docs)
+	}
+
+	for i := 0; i < r.config.NumWorkers; i++ {
+		r.wg.Add(1)
+		go r.worker(ctx, i)
+	}
+	// a shutdown releases the queued pages and the ones waiting for a retry
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Shutting down, saving the changes found so far")
+			for range r.scheduler.Drain() {
+				r.jobs.Done()
+			}
+		case <-finished:
+		}
+	}()
+	for _, doc := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		if !r.robots.Allowed(doc.URL) {
+			fmt.Println("Skipping page disallowed by robots.txt", doc.URL)
+			continue
+		}
+		r.jobs.Add(1)
+		r.scheduler.Add(Job{URL: doc.URL, Depth: doc.Depth})
+	}
+	// retries go back into the scheduler, close it once every page is done
+	r.jobs.Wait()
+	r.scheduler.Close()
+	r.wg.Wait()
+
+	// flush metadata
+	err = r.storage.FlushMetadata()
+	if err != nil {
+		fmt.Println("Error flushing metadata", err)
+	}
+	err = r.fetchLog.Flush()
+	if err != nil {
+		fmt.Println("Error saving fetch log", err)
+	}
+	err = r.storage.SaveChanges(r.changes)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\n\n--------------------------------")
+	fmt.Println("Changed or removed:", len(r.changes))
+	fmt.Println("Unchanged:", r.unchanged)
+	fmt.Println("Failed:", r.failed)
+	fmt.Println("Recrawl finished in", time.Since(t))
+	return nil
+}
+
+// worker revisits pages until the scheduler is closed
+func (r *Recrawler) worker(ctx context.Context, id int) {
+	defer r.wg.Done()
+	for {
+		job, ok := r.scheduler.Next()
+		if !ok {
+			return
+		}
+		fmt.Println("Worker", id, "revisiting", job.URL)
+		r.mu.Lock()
+		doc := r.docs[job.URL]
+		r.mu.Unlock()
+		if !r.revisit(ctx, job, doc) {
+			r.jobs.Done()
+		}
+	}
+}
+
+// revisit fetches the page again and updates its metadata and recrawl schedule,
+// it returns true if the page was scheduled for a retry
+func (r *Recrawler) revisit(ctx context.Context, job Job, doc DocMetadata) bool {
+	start := time.Now()
+	result, err := r.fetcher.Fetch(ctx, doc.URL, doc.ETag, doc.LastModified)
+	r.scheduler.Done(job, err)
+	// interrupted by a shutdown, the page is still due at the next recrawl
+	if err != nil && ctx.Err() != nil {
+		return false
+	}
+	r.fetchLog.Record(NewFetchLog(job, result, err, start))
+	now := time.Now()
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone) {
+		// the page is gone
+		if r.duplicates != nil {
+			r.duplicates.Remove(doc)
+		}
+		r.remove(doc, now)
+		return false
+	}
+	if err != nil {
+		if delay, ok := r.fetcher.RetryDelay(job, err); ok {
+			fmt.Println("Retrying", doc.URL, "in", delay, "after", err)
+			job.Attempt++
+			r.scheduler.Retry(job, delay)
+			return true
+		}
+		// try again at the next recrawl
+		fmt.Println("Error getting HTML from", doc.URL, err)
+		r.mu.Lock()
+		r.failed++
+		r.mu.Unlock()
+		return false
+	}
+
+	// the page may have moved since it was stored
+	doc.Redirects = result.Redirects
+	if len(result.Redirects) > 0 {
+		finalURL, err := r.scope.ResolveLink(nil, result.FinalURL)
+		if err != nil {
+			fmt.Println("Error revisiting", doc.URL, "redirected out of scope:", err)
+			r.mu.Lock()
+			r.failed++
+			r.mu.Unlock()
+			return false
+		}
+		doc.FinalURL = finalURL
+	} else {
+		doc.FinalURL = doc.URL
+	}
+
+	changed := false
+	if !result.NotModified() {
+		// validators may change even when the content doesn't
+		doc.ETag = result.ETag
+		doc.LastModified = result.LastModified
+		hash := sha256.Sum256(result.Body)
+		hashString := hex.EncodeToString(hash[:])
+		changed = hashString != doc.Hash
+		if changed {
+			old := doc
+			doc.Title = ""
+			doc.FirstParagraph = ""
+			doc.Images = []string{}
+			links, text, images := extractLinks(result.Body, &doc, r.scope)
+			doc.OutLinks = countTargets(doc.URL, links)
+			doc.Hash = hashString
+			doc.ContentLength = len(result.Body)
+			doc.CrawledAt = now
+			doc.SimHash = Fingerprint(text)
+			// the new text may belong to another cluster, or make the page a copy of another one
+			if r.duplicates != nil {
+				r.duplicates.Remove(old)
+				originalHash, originalURL, duplicate := r.duplicates.Check(&doc, text)
+				// metadata is stored by hash, an exact copy would replace the original
+				if duplicate && (r.config.NearDuplicates == DUPLICATES_SKIP || originalHash == hashString) {
+					fmt.Println("Removing", doc.URL, "now a near duplicate of", originalURL)
+					r.remove(old, now)
+					return false
+				}
+			}
+
+			err = r.storage.SaveHTML(hashString, result.Body)
+			if err != nil {
+				fmt.Println("Error saving HTML", err)
+				// the old version is still stored
+				if r.duplicates != nil {
+					r.duplicates.Add(old)
+				}
+				return false
+			}
+			// metadata and images are stored by hash, drop the entries of the old version
+			err = r.storage.DeleteMetadata(old.Hash)
+			if err != nil {
+				fmt.Println("Error deleting metadata", err)
+			}
+			err = r.storage.DeleteImages(old.Hash)
+			if err != nil {
+				fmt.Println("Error deleting images", err)
+			}
+			r.addChange(DocChange{URL: doc.URL, Kind: CHANGE_CHANGED, OldHash: old.Hash, NewHash: hashString, DetectedAt: now})
+			err = r.storage.SaveImages(hashString, withPage(images, doc))
+			if err != nil {
+				fmt.Println("Error saving images", err)
+			}
+		}
+	}
+	if !changed {
+		r.mu.Lock()
+		r.unchanged++
+		r.mu.Unlock()
+	}
+
+	r.reschedule(&doc, changed, now)
+	err = r.storage.SaveMetadata(doc)
+	if err != nil {
+		fmt.Println("Error saving metadata", err)
+	} else if changed && r.duplicates != nil {
+		r.duplicates.Add(doc)
+	}
+	return false
+}
+
+// remove deletes the stored page and tells the indexer
+func (r *Recrawler) remove(doc DocMetadata, now time.Time) {
+	err := r.storage.DeleteMetadata(doc.Hash)
+	if err != nil {
+		fmt.Println("Error deleting metadata", err)
+	}
+	err = r.storage.DeleteImages(doc.Hash)
+	if err != nil {
+		fmt.Println("Error deleting images", err)
+	}
+	r.addChange(DocChange{URL: doc.URL, Kind: CHANGE_REMOVED, OldHash: doc.Hash, DetectedAt: now})
+}
+
+// reschedule halves the recrawl interval of pages that changed and doubles it for pages that didn't
+func (r *Recrawler) reschedule(doc *DocMetadata, changed bool, now time.Time) {
+	interval := doc.RecrawlInterval
+	if interval == 0 {
+		interval = r.config.RecrawlInterval
+	}
+	if changed {
+		doc.ChangeCount++
+		interval /= 2
+	} else {
+		interval *= 2
+	}
+	doc.RecrawlInterval = min(max(interval, r.config.MinRecrawlInterval), r.config.MaxRecrawlInterval)
+	doc.CheckedAt = now
+	doc.NextCrawlAt = now.Add(doc.RecrawlInterval)
+}
+
+// addChange records a change for the indexer
+func (r *Recrawler) addChange(change DocChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, change)
+	fmt.Println("Page", change.Kind, change.URL)
+}
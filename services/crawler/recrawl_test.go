@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// article texts far apart from each other, long enough for a fingerprint
+const ORIGINAL_TEXT = "the quick brown fox jumps over the lazy dog near the quiet river bank every morning"
+const OLD_TEXT = "an old version of the page about medieval castles and the people who built them long ago"
+const NEW_TEXT = "a new version of the page describing modern bridges their engineering and the materials used today"
+const MOVED_TEXT = "this page moved to a new home where it explains how glaciers shape valleys over thousands of years"
+
+func articleHTML(title string, text string) string {
+	return fmt.Sprintf(`<html><head><title>%s</title></head><body><div id="mw-content-text"><p>%s</p></div></body></html>`, title, text)
+}
+
+func TestRecrawlChanges(t *testing.T) {
+	pages := map[string]string{
+		"/changed":  articleHTML("Changed", NEW_TEXT),
+		"/copy":     articleHTML("Copy", ORIGINAL_TEXT),
+		"/was-copy": articleHTML("Was copy", NEW_TEXT+" with a twist"),
+		"/new-home": articleHTML("Moved", MOVED_TEXT),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/new-home", http.StatusMovedPermanently)
+			return
+		}
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	config := NewConfig()
+	config.Mode = MODE_RECRAWL
+	config.HostDelay = time.Millisecond
+	config.NearDuplicates = DUPLICATES_MARK
+	config.Scope.AllowedHosts = []string{"127.0.0.1"}
+	config.Scope.Schemes = []string{"http"}
+	config.Scope.Include = nil
+	scope, err := NewScope(config.Scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := NewFilesystemStorage(t.TempDir(), config.PagesDir, config.MetadataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due := time.Now().Add(-time.Hour)
+	stored := []DocMetadata{
+		// the page the copy matches, not due
+		{URL: server.URL + "/original", Hash: "original", ClusterID: "original", SimHash: Fingerprint(ORIGINAL_TEXT), NextCrawlAt: time.Now().Add(time.Hour)},
+		{URL: server.URL + "/changed", Hash: "changed", ClusterID: "changed", SimHash: Fingerprint(OLD_TEXT), NextCrawlAt: due},
+		{URL: server.URL + "/copy", Hash: "copy", ClusterID: "copy", SimHash: Fingerprint(OLD_TEXT + " copy"), NextCrawlAt: due},
+		{URL: server.URL + "/was-copy", Hash: "was-copy", ClusterID: "original", DuplicateOf: "original", SimHash: Fingerprint(ORIGINAL_TEXT), NextCrawlAt: due},
+		{URL: server.URL + "/moved", Hash: "moved", ClusterID: "moved", NextCrawlAt: due},
+		{URL: server.URL + "/gone", Hash: "gone", ClusterID: "gone", NextCrawlAt: due},
+	}
+	for _, doc := range stored {
+		err = backend.SaveMetadata(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage := NewStorageWriter(backend, config)
+	err = NewRecrawler(storage, config, scope).Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := backend.ListMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byURL := make(map[string]DocMetadata)
+	hashes := make(map[string]bool)
+	for _, doc := range docs {
+		byURL[doc.URL] = doc
+		hashes[doc.Hash] = true
+	}
+	// the old versions are deleted before the new ones are saved, never after
+	for _, hash := range []string{"changed", "copy", "was-copy", "moved", "gone"} {
+		if hashes[hash] {
+			t.Errorf("metadata of the old version %s is still stored", hash)
+		}
+	}
+	if len(docs) != 5 {
+		t.Errorf("%d documents stored, want 5", len(docs))
+	}
+
+	tests := []struct {
+		path        string
+		duplicateOf string
+		clusterID   string
+		finalURL    string
+	}{
+		{"/changed", "", "", server.URL + "/changed"},
+		{"/copy", "original", "original", server.URL + "/copy"},
+		{"/was-copy", "", "", server.URL + "/was-copy"},
+		{"/moved", "", "", server.URL + "/new-home"},
+	}
+	for _, test := range tests {
+		doc, ok := byURL[server.URL+test.path]
+		if !ok {
+			t.Errorf("%s: not stored", test.path)
+			continue
+		}
+		clusterID := test.clusterID
+		if clusterID == "" {
+			clusterID = doc.Hash
+		}
+		if doc.DuplicateOf != test.duplicateOf || doc.ClusterID != clusterID {
+			t.Errorf("%s: duplicate of %q in cluster %q, want %q in %q", test.path, doc.DuplicateOf, doc.ClusterID, test.duplicateOf, clusterID)
+		}
+		if doc.FinalURL != test.finalURL {
+			t.Errorf("%s: final url %q, want %q", test.path, doc.FinalURL, test.finalURL)
+		}
+	}
+	if doc := byURL[server.URL+"/moved"]; len(doc.Redirects) == 0 {
+		t.Errorf("redirects of the moved page weren't saved")
+	}
+	if _, ok := byURL[server.URL+"/gone"]; ok {
+		t.Errorf("page that is gone is still stored")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// number of rows in every table of the report
+const REPORT_ROWS = 20
+
+// HostStats are the fetch attempts of a host
+type HostStats struct {
+	Host     string
+	Attempts int
+	Errors   int
+}
+
+// ErrorRate is the share of failed attempts
+func (h HostStats) ErrorRate() float64 {
+	if h.Attempts == 0 {
+		return 0
+	}
+	return float64(h.Errors) / float64(h.Attempts)
+}
+
+/*
+Report summarizes the fetch log of a crawl.
+
+classes: attempts by error class
+deadLinks: urls whose last attempt answered 404 or 410, or whose host doesn't resolve
+hosts: attempts and errors by host, highest error rate first
+slowest: successful fetches, slowest first
+*/
+type Report struct {
+	CrawlID   string
+	Attempts  int
+	Errors    int
+	classes   map[string]int
+	deadLinks []FetchLog
+	hosts     []HostStats
+	slowest   []FetchLog
+}
+
+// NewReport builds the report from the entries of one crawl
+func NewReport(crawlID string, logs []FetchLog) *Report {
+	r := &Report{CrawlID: crawlID, Attempts: len(logs), classes: make(map[string]int)}
+
+	// entries are appended as attempts finish, the last attempt of a url decides if it is dead
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].Timestamp.Before(logs[j].Timestamp) })
+	last := make(map[string]FetchLog)
+	hosts := make(map[string]*HostStats)
+	for _, entry := range logs {
+		r.classes[entry.ErrorClass]++
+		failed := entry.ErrorClass != CLASS_OK && entry.ErrorClass != CLASS_NOT_MODIFIED
+		if failed {
+			r.Errors++
+		}
+		stats, ok := hosts[entry.Host]
+		if !ok {
+			stats = &HostStats{Host: entry.Host}
+			hosts[entry.Host] = stats
+		}
+		stats.Attempts++
+		if failed {
+			stats.Errors++
+		}
+		if !failed {
+			r.slowest = append(r.slowest, entry)
+		}
+		last[entry.URL] = entry
+	}
+
+	for _, entry := range last {
+		if isDeadLink(entry) {
+			r.deadLinks = append(r.deadLinks, entry)
+		}
+	}
+	sort.Slice(r.deadLinks, func(i, j int) bool { return r.deadLinks[i].URL < r.deadLinks[j].URL })
+	for _, stats := range hosts {
+		r.hosts = append(r.hosts, *stats)
+	}
+	sort.Slice(r.hosts, func(i, j int) bool {
+		if r.hosts[i].ErrorRate() != r.hosts[j].ErrorRate() {
+			return r.hosts[i].ErrorRate() > r.hosts[j].ErrorRate()
+		}
+		return r.hosts[i].Attempts > r.hosts[j].Attempts
+	})
+	sort.Slice(r.slowest, func(i, j int) bool { return r.slowest[i].Latency > r.slowest[j].Latency })
+	return r
+}
+
+// isDeadLink reports whether the page won't come back by crawling again
+func isDeadLink(entry FetchLog) bool {
+	return entry.StatusCode == http.StatusNotFound || entry.StatusCode == http.StatusGone || entry.ErrorClass == CLASS_DNS
+}
+
+// PrintReport reads the fetch log of the crawl from storage and writes the report to w
+func PrintReport(storage Storage, crawlID string, w io.Writer) error {
+	logs, err := storage.ListFetchLogs(crawlID)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf("no fetch log for crawl %s", crawlID)
+	}
+	return NewReport(crawlID, logs).Print(w)
+}
+
+// Print writes the report as plain text tables
+func (r *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Fetch report for crawl %s\n", r.CrawlID)
+	fmt.Fprintf(tw, "Attempts: %d, errors: %d (%.1f%%)\n\n", r.Attempts, r.Errors, percent(r.Errors, r.Attempts))
+
+	fmt.Fprintln(tw, "ERROR CLASS\tATTEMPTS")
+	classes := make([]string, 0, len(r.classes))
+	for class := range r.classes {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if r.classes[classes[i]] != r.classes[classes[j]] {
+			return r.classes[classes[i]] > r.classes[classes[j]]
+		}
+		return classes[i] < classes[j]
+	})
+	for _, class := range classes {
+		fmt.Fprintf(tw, "%s\t%d\n", class, r.classes[class])
+	}
+
+	fmt.Fprintf(tw, "\nDead links: %d\n", len(r.deadLinks))
+	fmt.Fprintln(tw, "URL\tSTATUS\tERROR")
+	for _, entry := range first(r.deadLinks, REPORT_ROWS) {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", entry.URL, entry.StatusCode, entry.ErrorClass)
+	}
+
+	fmt.Fprintln(tw, "\nError rate by host")
+	fmt.Fprintln(tw, "HOST\tATTEMPTS\tERRORS\tRATE")
+	for _, stats := range first(r.hosts, REPORT_ROWS) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\n", stats.Host, stats.Attempts, stats.Errors, 100*stats.ErrorRate())
+	}
+
+	fmt.Fprintln(tw, "\nSlowest pages")
+	fmt.Fprintln(tw, "URL\tLATENCY\tBYTES")
+	for _, entry := range first(r.slowest, REPORT_ROWS) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", entry.URL, entry.Latency.Round(time.Microsecond), entry.Bytes)
+	}
+	return tw.Flush()
+}
+
+func percent(n int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// first returns at most n elements of the slice
+func first[T any](values []T, n int) []T {
+	if len(values) > n {
+		return values[:n]
+	}
+	return values
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewReport(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(url string, status int, class string, latency time.Duration, at int) FetchLog {
+		return FetchLog{URL: url, Host: hostOf(url), StatusCode: status, ErrorClass: class, Latency: latency, Timestamp: start.Add(time.Duration(at) * time.Second)}
+	}
+	tests := []struct {
+		name      string
+		logs      []FetchLog
+		errors    int
+		deadLinks []string
+		hosts     []string
+		slowest   []string
+	}{
+		{"empty log", nil, 0, nil, nil, nil},
+		{"dead links are decided by the last attempt", []FetchLog{
+			// logged out of order, the later 200 brings the page back
+			entry("https://a.com/back", 200, CLASS_OK, 2*time.Millisecond, 2),
+			entry("https://a.com/back", 404, CLASS_CLIENT_ERROR, time.Millisecond, 1),
+			entry("https://a.com/gone", 200, CLASS_OK, time.Millisecond, 1),
+			entry("https://a.com/gone", 410, CLASS_CLIENT_ERROR, time.Millisecond, 2),
+			entry("https://nowhere.com/", 0, CLASS_DNS, 0, 3),
+			entry("https://a.com/busy", 503, CLASS_SERVER_ERROR, 0, 4),
+		}, 4, []string{"https://a.com/gone", "https://nowhere.com/"}, []string{"nowhere.com", "a.com"}, []string{"https://a.com/back", "https://a.com/gone"}},
+		{"not modified isn't an error", []FetchLog{
+			entry("https://a.com/1", 304, CLASS_NOT_MODIFIED, time.Millisecond, 1),
+			entry("https://a.com/2", 200, CLASS_OK, 3*time.Millisecond, 2),
+			entry("https://b.com/1", 200, CLASS_OK, 2*time.Millisecond, 3),
+			entry("https://b.com/2", 200, CLASS_OK, time.Millisecond, 4),
+			entry("https://b.com/3", 0, CLASS_TIMEOUT, time.Second, 5),
+		}, 1, nil, []string{"b.com", "a.com"}, []string{"https://a.com/2", "https://b.com/1", "https://a.com/1", "https://b.com/2"}},
+	}
+	for _, test := range tests {
+		report := NewReport("crawl", test.logs)
+		if report.Attempts != len(test.logs) || report.Errors != test.errors {
+			t.Errorf("%s: %d attempts, %d errors, want %d, %d", test.name, report.Attempts, report.Errors, len(test.logs), test.errors)
+		}
+		var deadLinks, hosts, slowest []string
+		for _, entry := range report.deadLinks {
+			deadLinks = append(deadLinks, entry.URL)
+		}
+		for _, stats := range report.hosts {
+			hosts = append(hosts, stats.Host)
+		}
+		for _, entry := range report.slowest {
+			slowest = append(slowest, entry.URL)
+		}
+		if !slices.Equal(deadLinks, test.deadLinks) {
+			t.Errorf("%s: dead links %v, want %v", test.name, deadLinks, test.deadLinks)
+		}
+		if !slices.Equal(hosts, test.hosts) {
+			t.Errorf("%s: hosts %v, want %v", test.name, hosts, test.hosts)
+		}
+		if !slices.Equal(slowest, test.slowest) {
+			t.Errorf("%s: slowest %v, want %v", test.name, slowest, test.slowest)
+		}
+	}
+}
+
+func TestReportPrint(t *testing.T) {
+	logs := []FetchLog{
+		{URL: "https://a.com/1", Host: "a.com", StatusCode: 200, ErrorClass: CLASS_OK, Latency: 1500 * time.Microsecond, Bytes: 100},
+		{URL: "https://a.com/2", Host: "a.com", StatusCode: 404, ErrorClass: CLASS_CLIENT_ERROR},
+	}
+	var out strings.Builder
+	err := NewReport("crawl", logs).Print(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []string{
+		"Fetch report for crawl crawl",
+		"Attempts: 2, errors: 1 (50.0%)",
+		// classes with the same count are sorted by name
+		"client_error  1\nok            1",
+		"Dead links: 1",
+		"https://a.com/2  404",
+		"a.com  2         1       50.0%",
+		"https://a.com/1  1.5ms    100",
+	}
+	for _, want := range tests {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robots.txt files larger than this are truncated (RFC 9309 asks for at least 500 KiB)
+const ROBOTS_MAX_SIZE = 500 * 1024
+
+// how long a parsed robots.txt is kept before it is downloaded again
+const ROBOTS_TTL = 24 * time.Hour
+
+// an unreachable robots.txt is downloaded again after this delay, doubling up to ROBOTS_MAX_RETRY
+const ROBOTS_RETRY = time.Minute
+const ROBOTS_MAX_RETRY = time.Hour
+
+// RobotsRule is a single Allow/Disallow line
+type RobotsRule struct {
+	Allow bool
+	Path  string
+}
+
+// RobotsRules are the rules of a robots.txt that apply to our user agent
+type RobotsRules struct {
+	Rules      []RobotsRule
+	CrawlDelay time.Duration
+}
+
+// allowAll is used when robots.txt doesn't exist (4xx)
+var allowAll = &RobotsRules{}
+
+// disallowAll is used while robots.txt can't be reached (5xx, network errors)
+var disallowAll = &RobotsRules{Rules: []RobotsRule{{Allow: false, Path: "/"}}}
+
+/*
+robotsEntry is the robots.txt of a host, downloaded again once it expires.
+failures: downloads in a row that failed, the entry of an unreachable robots.txt
+expires sooner and its rules disallow everything until then
+*/
+type robotsEntry struct {
+	ready    chan struct{}
+	rules    *RobotsRules
+	expires  time.Time
+	failures int
+}
+
+/*
+client: http client used to download robots.txt
+userAgent: sent when downloading robots.txt, its product token is matched against the User-agent lines
+cache: parsed robots.txt per scheme://host
+*/
+type Robots struct {
+	mu        sync.Mutex
+	client    *http.Client
+	userAgent string
+	cache     map[string]*robotsEntry
+}
+
+// NewRobots creates a robots.txt cache for the given user agent
+func NewRobots(client *http.Client, userAgent string) *Robots {
+	return &Robots{
+		client:    client,
+		userAgent: userAgent,
+		cache:     make(map[string]*robotsEntry),
+	}
+}
+
+// Allowed reports whether the robots.txt of the url's host lets us fetch it,
+// urls of hosts whose robots.txt can't be reached aren't allowed
+func (r *Robots) Allowed(rawURL string) bool {
+	allowed, _ := r.Check(rawURL)
+	return allowed
+}
+
+// Check is Allowed, and returns how long until the robots.txt is downloaded again
+// when it couldn't be reached. Those urls may be allowed later.
+func (r *Robots) Check(rawURL string) (bool, time.Duration) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false, 0
+	}
+	// robots.txt itself is always allowed
+	if u.Path == "/robots.txt" {
+		return true, 0
+	}
+	entry := r.get(u)
+	if entry.failures > 0 {
+		return false, max(time.Until(entry.expires), time.Millisecond)
+	}
+	return entry.rules.Allowed(robotsPath(u)), 0
+}
+
+// CrawlDelay returns the Crawl-delay of the url's host, 0 if there is none
+func (r *Robots) CrawlDelay(rawURL string) time.Duration {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0
+	}
+	return r.get(u).rules.CrawlDelay
+}
+
+// get returns the cached robots.txt of the url's host, downloading it if needed.
+// Concurrent callers for the same host wait for a single download.
+func (r *Robots) get(u *url.URL) *robotsEntry {
+	key := u.Scheme + "://" + strings.ToLower(u.Host)
+
+	r.mu.Lock()
+	previous, ok := r.cache[key]
+	if ok {
+		select {
+		case <-previous.ready:
+			if time.Now().After(previous.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if ok {
+		r.mu.Unlock()
+		<-previous.ready
+		return previous
+	}
+	entry := &robotsEntry{ready: make(chan struct{})}
+	r.cache[key] = entry
+	r.mu.Unlock()
+
+	rules, reached := r.download(key + "/robots.txt")
+	entry.rules = rules
+	entry.expires = time.Now().Add(ROBOTS_TTL)
+	if !reached {
+		if previous != nil {
+			entry.failures = previous.failures
+		}
+		entry.failures++
+		retry := min(ROBOTS_RETRY<<min(entry.failures-1, 16), ROBOTS_MAX_RETRY)
+		entry.expires = time.Now().Add(retry)
+		fmt.Println("robots.txt of", key, "unreachable, downloading it again in", retry)
+	}
+	close(entry.ready)
+	return entry
+}
+
+// download fetches and parses a robots.txt, false when it couldn't be reached
+func (r *Robots) download(robotsURL string) (*RobotsRules, bool) {
+	req, err := http.NewRequest("GET", robotsURL, nil)
+	if err != nil {
+		return disallowAll, false
+	}
+	req.Header.Set("User-Agent", r.userAgent)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		fmt.Println("Error fetching", robotsURL, err)
+		return disallowAll, false
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return ParseRobots(io.LimitReader(resp.Body, ROBOTS_MAX_SIZE), robotsToken(r.userAgent)), true
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return allowAll, true
+	default:
+		return disallowAll, false
+	}
+}
+
+// ParseRobots parses a robots.txt and returns the rules for the given user agent token.
+// Groups naming the user agent win over the "*" group; multiple matching groups are merged.
+func ParseRobots(body io.Reader, userAgent string) *RobotsRules {
+	agent := strings.ToLower(userAgent)
+	specific := &RobotsRules{}
+	generic := &RobotsRules{}
+	foundSpecific := false
+
+	// agents of the group currently being read
+	var groupAgents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "user-agent" {
+			// a user-agent line after rules starts a new group
+			if inRules {
+				groupAgents = groupAgents[:0]
+				inRules = false
+			}
+			// only the product token counts, "Foo-Bot/1.0" matches like "Foo-Bot"
+			name, _, _ := strings.Cut(strings.ToLower(value), "/")
+			if name = strings.TrimSpace(name); name != "" {
+				groupAgents = append(groupAgents, name)
+			}
+			continue
+		}
+
+		inRules = true
+		for _, groupAgent := range groupAgents {
+			var target *RobotsRules
+			if groupAgent == "*" {
+				target = generic
+			} else if groupAgent == agent {
+				target = specific
+				foundSpecific = true
+			} else {
+				continue
+			}
+
+			switch key {
+			case "allow", "disallow":
+				// an empty Disallow means everything is allowed
+				if value == "" {
+					continue
+				}
+				target.Rules = append(target.Rules, RobotsRule{Allow: key == "allow", Path: value})
+			case "crawl-delay":
+				seconds, err := strconv.ParseFloat(value, 64)
+				if err == nil && seconds > 0 {
+					target.CrawlDelay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	if foundSpecific {
+		return specific
+	}
+	return generic
+}
+
+// Allowed reports whether the path is allowed.
+// The longest matching rule wins, Allow wins ties.
+func (rr *RobotsRules) Allowed(path string) bool {
+	allowed := true
+	longest := -1
+	for _, rule := range rr.Rules {
+		if !robotsMatch(rule.Path, path) {
+			continue
+		}
+		if len(rule.Path) > longest || (len(rule.Path) == longest && rule.Allow) {
+			longest = len(rule.Path)
+			allowed = rule.Allow
+		}
+	}
+	return allowed
+}
+
+// robotsPath returns the path and query that rules are matched against
+func robotsPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+// robotsMatch matches a path against a rule supporting "*" wildcards and a trailing "$"
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+
+	parts := strings.Split(pattern, "*")
+	// the first part must be a prefix
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i := 1; i < len(parts); i++ {
+		part := parts[i]
+		// last part of an anchored pattern has to be at the end
+		if anchored && i == len(parts)-1 {
+			return strings.HasSuffix(rest, part)
+		}
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+	if anchored && len(parts) == 1 {
+		return rest == ""
+	}
+	return true
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	robots := `
+User-agent: *
+Disallow: /private/
+Crawl-delay: 2
+
+User-agent: OtherBot
+Disallow: /
+
+User-agent: GoogleClone-Crawler
+Disallow: /wiki/Special:
+Allow: /wiki/Special:Random
+Disallow: /*.pdf$
+Disallow: /search*q=
+Crawl-delay: 0.5
+`
+	rules := ParseRobots(strings.NewReader(robots), "googleclone-crawler")
+	if rules.CrawlDelay != 500*time.Millisecond {
+		t.Errorf("crawl delay = %s, want 500ms", rules.CrawlDelay)
+	}
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		// the specific group replaces the * group
+		{"/private/page", true},
+		{"/wiki/Physics", true},
+		{"/wiki/Special:Search", false},
+		// the longest match wins
+		{"/wiki/Special:Random", true},
+		{"/wiki/Special:RandomPage", true},
+		{"/files/paper.pdf", false},
+		{"/files/paper.pdf?download=1", true},
+		{"/search?lang=en&q=go", false},
+		{"/search?lang=en", true},
+	}
+	for _, test := range tests {
+		if got := rules.Allowed(test.path); got != test.allowed {
+			t.Errorf("Allowed(%q) = %v, want %v", test.path, got, test.allowed)
+		}
+	}
+
+	generic := ParseRobots(strings.NewReader(robots), "unknown-bot")
+	if generic.Allowed("/private/page") || !generic.Allowed("/wiki/Physics") {
+		t.Errorf("unknown agent should get the * group, got %+v", generic.Rules)
+	}
+	if generic.CrawlDelay != 2*time.Second {
+		t.Errorf("crawl delay = %s, want 2s", generic.CrawlDelay)
+	}
+}
+
+func TestRobotsAllowedTies(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []RobotsRule
+		path    string
+		allowed bool
+	}{
+		{"no rules", nil, "/a", true},
+		{"allow wins a tie", []RobotsRule{{false, "/a"}, {true, "/a"}}, "/a/b", true},
+		{"longer disallow wins", []RobotsRule{{true, "/a"}, {false, "/a/b"}}, "/a/b/c", false},
+		{"anchored pattern", []RobotsRule{{false, "/a$"}}, "/a/b", true},
+		{"anchored match", []RobotsRule{{false, "/a$"}}, "/a", false},
+	}
+	for _, test := range tests {
+		rules := &RobotsRules{Rules: test.rules}
+		if got := rules.Allowed(test.path); got != test.allowed {
+			t.Errorf("%s: Allowed(%q) = %v, want %v", test.name, test.path, got, test.allowed)
+		}
+	}
+}
+
+// robotsServer serves the body with the current status and counts the requests
+func robotsServer(t *testing.T, status *atomic.Int32, body string) (*httptest.Server, *atomic.Int32) {
+	requests := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestRobotsStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int32
+		allowed   bool
+		retryable bool
+	}{
+		{"ok", http.StatusOK, false, false},
+		{"not found allows all", http.StatusNotFound, true, false},
+		{"forbidden allows all", http.StatusForbidden, true, false},
+		{"server error waits", http.StatusInternalServerError, false, true},
+		{"unavailable waits", http.StatusServiceUnavailable, false, true},
+	}
+	for _, test := range tests {
+		status := &atomic.Int32{}
+		status.Store(test.status)
+		server, _ := robotsServer(t, status, "User-agent: *\nDisallow: /private\n")
+		robots := NewRobots(server.Client(), "test-bot/1.0")
+		allowed, retryIn := robots.Check(server.URL + "/private/page")
+		if allowed != test.allowed || (retryIn > 0) != test.retryable {
+			t.Errorf("%s: Check = %v, %s, want %v, retryable %v", test.name, allowed, retryIn, test.allowed, test.retryable)
+		}
+	}
+}
+
+func TestRobotsUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	robots := NewRobots(server.Client(), "test-bot")
+	allowed, retryIn := robots.Check(server.URL + "/page")
+	if allowed || retryIn <= 0 || retryIn > ROBOTS_RETRY {
+		t.Errorf("Check = %v, %s, want false and a retry within %s", allowed, retryIn, ROBOTS_RETRY)
+	}
+}
+
+func TestRobotsRetryBackoff(t *testing.T) {
+	status := &atomic.Int32{}
+	status.Store(http.StatusInternalServerError)
+	server, requests := robotsServer(t, status, "")
+	robots := NewRobots(server.Client(), "test-bot")
+	expire := func() {
+		robots.mu.Lock()
+		defer robots.mu.Unlock()
+		for _, entry := range robots.cache {
+			entry.expires = time.Now().Add(-time.Second)
+		}
+	}
+
+	_, first := robots.Check(server.URL + "/page")
+	// cached until the retry
+	robots.Check(server.URL + "/page")
+	if requests.Load() != 1 {
+		t.Fatalf("requests = %d, want 1", requests.Load())
+	}
+	expire()
+	_, second := robots.Check(server.URL + "/page")
+	if requests.Load() != 2 || second <= first {
+		t.Errorf("second failure: requests = %d, retry %s after %s, want a longer retry", requests.Load(), second, first)
+	}
+
+	// once robots.txt is back the host is crawled again
+	status.Store(http.StatusNotFound)
+	expire()
+	allowed, retryIn := robots.Check(server.URL + "/page")
+	if !allowed || retryIn != 0 {
+		t.Errorf("Check after recovery = %v, %s, want true, 0", allowed, retryIn)
+	}
+}
+
+func TestRobotsTTL(t *testing.T) {
+	status := &atomic.Int32{}
+	status.Store(http.StatusOK)
+	server, requests := robotsServer(t, status, "User-agent: *\nDisallow: /private\n")
+	robots := NewRobots(server.Client(), "test-bot")
+
+	for range 3 {
+		if robots.Allowed(server.URL + "/private/a") {
+			t.Fatal("disallowed path allowed")
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("requests = %d, want 1 while cached", requests.Load())
+	}
+	robots.mu.Lock()
+	for _, entry := range robots.cache {
+		if d := time.Until(entry.expires); d < ROBOTS_TTL-time.Minute || d > ROBOTS_TTL {
+			t.Errorf("entry expires in %s, want %s", d, ROBOTS_TTL)
+		}
+		entry.expires = time.Now().Add(-time.Second)
+	}
+	robots.mu.Unlock()
+	robots.Allowed(server.URL + "/private/a")
+	if requests.Load() != 2 {
+		t.Errorf("requests = %d, want 2 after expiry", requests.Load())
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// first backoff applied to a host after a 429/503 without Retry-After
+const MIN_BACKOFF = time.Second
+
+/*
+jobs: jobs waiting for this host, highest priority first
+inFlight: number of requests currently running against this host
+nextFetch: earliest time the next request may start
+backoff: extra delay added after the host asked us to slow down
+crawlDelay: Crawl-delay from the host's robots.txt
+*/
+type hostQueue struct {
+	jobs       jobHeap
+	inFlight   int
+	nextFetch  time.Time
+	backoff    time.Duration
+	crawlDelay time.Duration
+}
+
+/*
+Scheduler sits between the frontier and the workers and hands out jobs
+only when their host can take another request. Of the hosts that are ready,
+the one whose next job has the highest priority goes first.
+
+hosts: queue of waiting jobs per host
+queued: number of jobs in all host queues
+seq: sequence number of the next added job
+capacity: maximum number of queued jobs, Add blocks above it
+minDelay: minimum delay between two requests to the same host
+maxPerHost: maximum number of concurrent requests per host
+maxBackoff: upper bound for the backoff of a throttling host
+retries: jobs waiting for their retry delay, by the timer that queues them
+wakeTimer: wakes up Next when the earliest waiting host is ready
+wakeAt: time wakeTimer fires at, zero when it isn't running
+*/
+type Scheduler struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	hosts      map[string]*hostQueue
+	queued     int
+	seq        uint64
+	capacity   int
+	closed     bool
+	minDelay   time.Duration
+	maxPerHost int
+	maxBackoff time.Duration
+	robots     *Robots
+	retries    map[*time.Timer]Job
+
+	wakeTimer *time.Timer
+	wakeAt    time.Time
+}
+
+// NewScheduler creates a scheduler with the politeness settings from the config
+func NewScheduler(config *Config, robots *Robots) *Scheduler {
+	s := &Scheduler{
+		hosts:      make(map[string]*hostQueue),
+		capacity:   config.JobsBuffer,
+		minDelay:   config.HostDelay,
+		maxPerHost: config.MaxPerHost,
+		maxBackoff: config.MaxBackoff,
+		robots:     robots,
+		retries:    make(map[*time.Timer]Job),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Add queues a job with the other jobs of its host, blocks while the scheduler is full
+func (s *Scheduler) Add(job Job) {
+	host := hostOf(job.URL)
+	// read before locking, the first call for a host downloads robots.txt
+	crawlDelay := s.robots.CrawlDelay(job.URL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.queued >= s.capacity && !s.closed {
+		s.cond.Wait()
+	}
+
+	hq, ok := s.hosts[host]
+	if !ok {
+		hq = &hostQueue{}
+		s.hosts[host] = hq
+	}
+	hq.crawlDelay = crawlDelay
+	heap.Push(&hq.jobs, queuedJob{job: job, seq: s.seq})
+	s.seq++
+	s.queued++
+	s.cond.Broadcast()
+}
+
+// Next blocks until some host is ready and returns the job with the highest priority of the ready hosts.
+// It returns false once the scheduler is closed and empty.
+func (s *Scheduler) Next() (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		now := time.Now()
+		wait := time.Duration(-1)
+		var best *hostQueue
+		for host, hq := range s.hosts {
+			if len(hq.jobs) == 0 {
+				// forget idle hosts once their delay and backoff are over
+				if hq.idle(now) {
+					delete(s.hosts, host)
+				}
+				continue
+			}
+			if hq.inFlight >= s.maxPerHost {
+				continue
+			}
+			if d := hq.nextFetch.Sub(now); d > 0 {
+				if wait < 0 || d < wait {
+					wait = d
+				}
+				continue
+			}
+			if best == nil || hq.jobs[0].before(best.jobs[0]) {
+				best = hq
+			}
+		}
+		if best != nil {
+			job := heap.Pop(&best.jobs).(queuedJob).job
+			best.inFlight++
+			best.nextFetch = now.Add(s.delay(best))
+			s.queued--
+			// wake up Add if it was waiting for space
+			s.cond.Broadcast()
+			return job, true
+		}
+
+		if s.closed && s.queued == 0 {
+			return Job{}, false
+		}
+		// no host is ready yet, sleep until the closest one is
+		if wait > 0 {
+			s.wakeIn(now, wait)
+		}
+		s.cond.Wait()
+	}
+}
+
+// Done releases the host slot taken by Next and adapts the host's rate to the result of the fetch.
+// 429 and 503 responses double the host's backoff (or use Retry-After), successes halve it.
+func (s *Scheduler) Done(job Job, err error) {
+	host := hostOf(job.URL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hq, ok := s.hosts[host]
+	if !ok {
+		return
+	}
+	hq.inFlight--
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && isThrottled(statusErr.StatusCode) {
+		hq.backoff = min(max(hq.backoff*2, MIN_BACKOFF), s.maxBackoff)
+		wait := hq.backoff
+		if statusErr.RetryAfter > wait {
+			wait = min(statusErr.RetryAfter, s.maxBackoff)
+		}
+		hq.nextFetch = time.Now().Add(wait)
+		fmt.Println("Host", host, "is throttling us, backing off for", wait)
+	} else if err == nil {
+		hq.backoff /= 2
+		if hq.backoff < MIN_BACKOFF {
+			hq.backoff = 0
+		}
+	}
+
+	// forget idle hosts so the map doesn't grow with every host ever seen
+	if len(hq.jobs) == 0 && hq.idle(time.Now()) {
+		delete(s.hosts, host)
+	}
+	s.cond.Broadcast()
+}
+
+// Retry queues the job again once the delay has passed, the host's other jobs don't wait for it.
+// The caller keeps the job counted as unfinished until it is handed out again.
+func (s *Scheduler) Retry(job Job, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		delete(s.retries, timer)
+		s.mu.Unlock()
+		s.Add(job)
+	})
+	s.retries[timer] = job
+}
+
+// Len returns the number of jobs waiting in the host queues
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queued
+}
+
+// Drain removes all queued jobs and jobs waiting for a retry and returns them.
+// Jobs being fetched keep their host slots and hosts keep their delay and backoff.
+func (s *Scheduler) Drain() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, s.queued+len(s.retries))
+	// a timer that already fired adds its job anyway
+	for timer, job := range s.retries {
+		if timer.Stop() {
+			jobs = append(jobs, job)
+		}
+		delete(s.retries, timer)
+	}
+	for host, hq := range s.hosts {
+		for _, queued := range hq.jobs {
+			jobs = append(jobs, queued.job)
+		}
+		hq.jobs = hq.jobs[:0]
+		if hq.idle(time.Now()) {
+			delete(s.hosts, host)
+		}
+	}
+	s.queued = 0
+	s.cond.Broadcast()
+	return jobs
+}
+
+// Close makes Next return false once all queued jobs are handed out
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// wakeIn makes the timer wake up Next after wait, unless it already fires earlier.
+// Called with the lock held.
+func (s *Scheduler) wakeIn(now time.Time, wait time.Duration) {
+	at := now.Add(wait)
+	if !s.wakeAt.IsZero() && !at.Before(s.wakeAt) {
+		return
+	}
+	s.wakeAt = at
+	if s.wakeTimer == nil {
+		s.wakeTimer = time.AfterFunc(wait, s.wake)
+		return
+	}
+	s.wakeTimer.Reset(wait)
+}
+
+// wake is called by the timer and wakes up the waiting Next calls
+func (s *Scheduler) wake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wakeAt = time.Time{}
+	s.cond.Broadcast()
+}
+
+// idle reports whether the host has no requests running and nothing left to wait for
+func (hq *hostQueue) idle(now time.Time) bool {
+	return hq.inFlight == 0 && hq.backoff == 0 && now.After(hq.nextFetch)
+}
+
+// delay returns the time to wait between two requests to the host
+func (s *Scheduler) delay(hq *hostQueue) time.Duration {
+	return max(s.minDelay, hq.crawlDelay) + hq.backoff
+}
+
+// isThrottled reports whether the status code asks us to slow down
+func isThrottled(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// hostOf returns the lowercased host of the url
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
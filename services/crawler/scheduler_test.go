@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// testScheduler returns a scheduler for hosts without a robots.txt
+func testScheduler(delay time.Duration, maxBackoff time.Duration) *Scheduler {
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+	})}
+	config := NewConfig()
+	config.HostDelay = delay
+	config.MaxPerHost = 1
+	config.MaxBackoff = maxBackoff
+	return NewScheduler(config, NewRobots(client, "test-bot"))
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []Job
+		want []string
+	}{
+		{"highest priority of all hosts first", []Job{
+			{URL: "https://a.com/1", Priority: 1},
+			{URL: "https://b.com/1", Priority: 3},
+			{URL: "https://c.com/1", Priority: 2},
+		}, []string{"https://b.com/1", "https://c.com/1", "https://a.com/1"}},
+		{"same priority in the order added", []Job{
+			{URL: "https://a.com/1"},
+			{URL: "https://b.com/1"},
+			{URL: "https://a.com/2"},
+		}, []string{"https://a.com/1", "https://b.com/1", "https://a.com/2"}},
+	}
+	for _, test := range tests {
+		s := testScheduler(0, time.Second)
+		for _, job := range test.jobs {
+			s.Add(job)
+		}
+		s.Close()
+		var got []string
+		for {
+			job, ok := s.Next()
+			if !ok {
+				break
+			}
+			got = append(got, job.URL)
+			s.Done(job, nil)
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: order %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSchedulerHostDelay(t *testing.T) {
+	delay := 50 * time.Millisecond
+	s := testScheduler(delay, time.Second)
+	for _, url := range []string{"https://a.com/1", "https://a.com/2", "https://a.com/3"} {
+		s.Add(Job{URL: url})
+	}
+	s.Close()
+	var last time.Time
+	for {
+		job, ok := s.Next()
+		if !ok {
+			break
+		}
+		now := time.Now()
+		if !last.IsZero() && now.Sub(last) < delay {
+			t.Errorf("%s handed out %v after the last request, want at least %v", job.URL, now.Sub(last), delay)
+		}
+		last = now
+		s.Done(job, nil)
+	}
+	if s.wakeTimer == nil {
+		t.Error("Next didn't wait for the host delay")
+	}
+}
+
+func TestSchedulerWakeTimer(t *testing.T) {
+	tests := []struct {
+		name  string
+		waits []time.Duration
+		want  time.Duration
+	}{
+		{"one wait", []time.Duration{time.Hour}, time.Hour},
+		{"an earlier wait moves the timer", []time.Duration{time.Hour, time.Minute}, time.Minute},
+		{"a later wait keeps it", []time.Duration{time.Minute, time.Hour, 2 * time.Minute}, time.Minute},
+	}
+	for _, test := range tests {
+		s := testScheduler(0, time.Second)
+		now := time.Now()
+		var timer *time.Timer
+		s.mu.Lock()
+		for _, wait := range test.waits {
+			s.wakeIn(now, wait)
+			if timer != nil && s.wakeTimer != timer {
+				t.Errorf("%s: a new timer was started for %v", test.name, wait)
+			}
+			timer = s.wakeTimer
+		}
+		if !s.wakeAt.Equal(now.Add(test.want)) {
+			t.Errorf("%s: wakes up in %v, want %v", test.name, s.wakeAt.Sub(now), test.want)
+		}
+		s.mu.Unlock()
+		timer.Stop()
+	}
+}
+
+func TestSchedulerDrainKeepsBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		backoff time.Duration
+	}{
+		{"throttled host", &StatusError{StatusCode: http.StatusTooManyRequests}, 100 * time.Millisecond},
+		{"retry after", &StatusError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 50 * time.Millisecond}, 100 * time.Millisecond},
+		{"healthy host", nil, 0},
+	}
+	for _, test := range tests {
+		s := testScheduler(0, 100*time.Millisecond)
+		s.Add(Job{URL: "https://a.com/1"})
+		job, _ := s.Next()
+		s.Add(Job{URL: "https://a.com/2"})
+		s.Done(job, test.err)
+		done := time.Now()
+
+		drained := s.Drain()
+		if len(drained) != 1 || drained[0].URL != "https://a.com/2" {
+			t.Errorf("%s: drained %v, want the queued job", test.name, drained)
+		}
+		hq, ok := s.hosts["a.com"]
+		if test.backoff == 0 {
+			if ok {
+				t.Errorf("%s: idle host was kept", test.name)
+			}
+			continue
+		}
+		if !ok || hq.backoff != test.backoff {
+			t.Fatalf("%s: backoff of the host was lost", test.name)
+		}
+
+		// the next job of the host still waits for the backoff
+		s.Add(Job{URL: "https://a.com/3"})
+		s.Next()
+		if waited := time.Since(done); waited < test.backoff/2 {
+			t.Errorf("%s: next job handed out after %v, want the backoff", test.name, waited)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+/*
+ScopeRules declare which links the crawler follows and which images it keeps.
+Empty lists don't restrict anything, except Schemes which defaults to http and https.
+
+AllowedHosts: hosts links may point to, "*.example.org" also matches subdomains
+Schemes: accepted url schemes
+Include: regexes, when set a url has to match one of them
+Exclude: regexes, a url matching any of them is skipped
+DepthLimits: lower max depth for urls matching a pattern
+ImageHosts: hosts images are accepted from, same syntax as AllowedHosts
+*/
+type ScopeRules struct {
+	AllowedHosts []string
+	Schemes      []string
+	Include      []string
+	Exclude      []string
+	DepthLimits  []DepthLimit
+	ImageHosts   []string
+}
+
+// DepthLimit limits the depth of urls matching Pattern
+type DepthLimit struct {
+	Pattern  string
+	MaxDepth int
+}
+
+type depthLimit struct {
+	pattern  *regexp.Regexp
+	maxDepth int
+}
+
+// Scope is the compiled form of ScopeRules
+type Scope struct {
+	rules       ScopeRules
+	include     []*regexp.Regexp
+	exclude     []*regexp.Regexp
+	depthLimits []depthLimit
+}
+
+// WikipediaScope are the rules for crawling articles of the english Wikipedia
+func WikipediaScope() ScopeRules {
+	return ScopeRules{
+		AllowedHosts: []string{"en.wikipedia.org"},
+		Schemes:      []string{"https"},
+		Include:      []string{`^https://en\.wikipedia\.org/wiki/`},
+		// namespaces like Special:, File:, Talk: or Category:
+		Exclude:    []string{`^https://en\.wikipedia\.org/wiki/[^?]*:`},
+		ImageHosts: []string{"upload.wikimedia.org"},
+	}
+}
+
+// NewScope compiles the scope rules
+func NewScope(rules ScopeRules) (*Scope, error) {
+	if len(rules.Schemes) == 0 {
+		rules.Schemes = []string{"http", "https"}
+	}
+	s := &Scope{rules: rules}
+	for _, pattern := range rules.Include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include pattern %q: %w", pattern, err)
+		}
+		s.include = append(s.include, re)
+	}
+	for _, pattern := range rules.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("exclude pattern %q: %w", pattern, err)
+		}
+		s.exclude = append(s.exclude, re)
+	}
+	for _, limit := range rules.DepthLimits {
+		re, err := regexp.Compile(limit.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("depth limit pattern %q: %w", limit.Pattern, err)
+		}
+		s.depthLimits = append(s.depthLimits, depthLimit{pattern: re, maxDepth: limit.MaxDepth})
+	}
+	return s, nil
+}
+
+// ResolveLink resolves an href against the page it was found on
+// and returns the absolute url if it is in scope
+func (s *Scope) ResolveLink(base *url.URL, href string) (string, error) {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return "", fmt.Errorf("href %s is a fragment", href)
+	}
+	u, err := resolve(base, href)
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(s.rules.Schemes, u.Scheme) {
+		return "", fmt.Errorf("scheme of %s is not allowed", u)
+	}
+	if !matchHost(s.rules.AllowedHosts, u) {
+		return "", fmt.Errorf("host of %s is not allowed", u)
+	}
+
+	link := u.String()
+	if len(s.include) > 0 && !slices.ContainsFunc(s.include, func(re *regexp.Regexp) bool { return re.MatchString(link) }) {
+		return "", fmt.Errorf("%s doesn't match any include pattern", link)
+	}
+	if slices.ContainsFunc(s.exclude, func(re *regexp.Regexp) bool { return re.MatchString(link) }) {
+		return "", fmt.Errorf("%s matches an exclude pattern", link)
+	}
+	return link, nil
+}
+
+// ResolveImage resolves an image src against the page and returns the absolute url
+// if the image comes from an allowed host
+func (s *Scope) ResolveImage(base *url.URL, src string) (string, error) {
+	u, err := resolve(base, strings.TrimSpace(src))
+	if err != nil {
+		return "", err
+	}
+	if !matchHost(s.rules.ImageHosts, u) {
+		return "", errors.New("image src is not from an allowed image host")
+	}
+	return u.String(), nil
+}
+
+// AllowsDepth reports whether the url may be crawled at the given depth
+func (s *Scope) AllowsDepth(link string, depth int) bool {
+	for _, limit := range s.depthLimits {
+		if limit.pattern.MatchString(link) && depth > limit.maxDepth {
+			return false
+		}
+	}
+	return true
+}
+
+// matchHost reports whether the url's host is in the list, an empty list matches every host
+func matchHost(hosts []string, u *url.URL) bool {
+	if len(hosts) == 0 {
+		return true
+	}
+	host := strings.ToLower(u.Host)
+	hostname := strings.ToLower(u.Hostname())
+	for _, allowed := range hosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if hostname == suffix || strings.HasSuffix(hostname, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == allowed || hostname == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestScopeResolveLink(t *testing.T) {
+	base, _ := url.Parse("https://en.wikipedia.org/wiki/Physics")
+	tests := []struct {
+		rules ScopeRules
+		href  string
+		want  string // empty when the link is out of scope
+	}{
+		{WikipediaScope(), "/wiki/Energy", "https://en.wikipedia.org/wiki/Energy"},
+		{WikipediaScope(), "Energy#History", "https://en.wikipedia.org/wiki/Energy"},
+		{WikipediaScope(), "#History", ""},
+		{WikipediaScope(), "  ", ""},
+		{WikipediaScope(), "/wiki/Special:Random", ""},
+		{WikipediaScope(), "/wiki/Talk:Physics", ""},
+		{WikipediaScope(), "/w/index.php?title=Physics", ""},
+		{WikipediaScope(), "https://de.wikipedia.org/wiki/Physik", ""},
+		{WikipediaScope(), "http://en.wikipedia.org/wiki/Energy", ""},
+		{WikipediaScope(), "mailto:someone@example.org", ""},
+		// no rules allow any http(s) link
+		{ScopeRules{}, "https://example.org/a", "https://example.org/a"},
+		{ScopeRules{}, "ftp://example.org/a", ""},
+		{ScopeRules{AllowedHosts: []string{"*.example.org"}}, "https://docs.example.org/a", "https://docs.example.org/a"},
+		{ScopeRules{AllowedHosts: []string{"*.example.org"}}, "https://example.org/a", "https://example.org/a"},
+		{ScopeRules{AllowedHosts: []string{"*.example.org"}}, "https://badexample.org/a", ""},
+		{ScopeRules{AllowedHosts: []string{"example.org:8080"}}, "https://example.org:8080/a", "https://example.org:8080/a"},
+		{ScopeRules{AllowedHosts: []string{"example.org"}}, "https://EXAMPLE.org:8080/a", "https://example.org:8080/a"},
+		{ScopeRules{Exclude: []string{`\.pdf$`}}, "https://example.org/a.pdf", ""},
+	}
+	for _, test := range tests {
+		scope, err := NewScope(test.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := scope.ResolveLink(base, test.href)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("ResolveLink(%q) = %q, want it out of scope", test.href, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ResolveLink(%q) = %q, %v, want %q", test.href, got, err, test.want)
+		}
+	}
+}
+
+func TestScopeResolveImage(t *testing.T) {
+	base, _ := url.Parse("https://en.wikipedia.org/wiki/Physics")
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"//upload.wikimedia.org/a.jpg", "https://upload.wikimedia.org/a.jpg"},
+		{"/static/logo.png", ""},
+		{"https://example.org/a.jpg", ""},
+	}
+	scope, err := NewScope(WikipediaScope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		got, err := scope.ResolveImage(base, test.src)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("ResolveImage(%q) = %q, want it rejected", test.src, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ResolveImage(%q) = %q, %v, want %q", test.src, got, err, test.want)
+		}
+	}
+}
+
+func TestScopeAllowsDepth(t *testing.T) {
+	scope, err := NewScope(ScopeRules{DepthLimits: []DepthLimit{
+		{Pattern: `/wiki/List_of_`, MaxDepth: 1},
+		{Pattern: `/wiki/`, MaxDepth: 3},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		link  string
+		depth int
+		want  bool
+	}{
+		{"https://en.wikipedia.org/wiki/List_of_physicists", 1, true},
+		{"https://en.wikipedia.org/wiki/List_of_physicists", 2, false},
+		{"https://en.wikipedia.org/wiki/Physics", 3, true},
+		{"https://en.wikipedia.org/wiki/Physics", 4, false},
+		{"https://example.org/a", 10, true},
+	}
+	for _, test := range tests {
+		if got := scope.AllowsDepth(test.link, test.depth); got != test.want {
+			t.Errorf("AllowsDepth(%q, %d) = %v, want %v", test.link, test.depth, got, test.want)
+		}
+	}
+}
+
+func TestNewScopeInvalidPattern(t *testing.T) {
+	tests := []ScopeRules{
+		{Include: []string{"("}},
+		{Exclude: []string{"["}},
+		{DepthLimits: []DepthLimit{{Pattern: "*", MaxDepth: 1}}},
+	}
+	for _, rules := range tests {
+		if _, err := NewScope(rules); err == nil {
+			t.Errorf("NewScope(%+v) accepted an invalid pattern", rules)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// number of words in a shingle
+const SHINGLE_SIZE = 3
+
+// pages with fewer words get no fingerprint, short texts look alike too easily
+const MIN_FINGERPRINT_WORDS = 10
+
+// the fingerprint is split into this many bands, near duplicates share at least one of them
+// as long as they differ in fewer bits than there are bands
+const SIMHASH_BANDS = 4
+
+// largest distance the index can find, more differing bits may miss every band
+const MAX_SIMHASH_DISTANCE = SIMHASH_BANDS - 1
+
+// what happens to near duplicates
+const DUPLICATES_OFF = "off"
+const DUPLICATES_MARK = "mark"
+const DUPLICATES_SKIP = "skip"
+
+// SimHash returns the 64 bit SimHash of the text, built from shingles of its words.
+// Texts that differ in a few words differ in a few bits.
+func SimHash(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	h := fnv.New64a()
+	for i := 0; i+SHINGLE_SIZE <= len(words) || i == 0; i++ {
+		h.Reset()
+		h.Write([]byte(strings.Join(words[i:min(i+SHINGLE_SIZE, len(words))], " ")))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+// Fingerprint returns the SimHash of the text as stored in DocMetadata, empty for short texts
+func Fingerprint(text string) string {
+	if len(strings.Fields(text)) < MIN_FINGERPRINT_WORDS {
+		return ""
+	}
+	return FormatSimHash(SimHash(text))
+}
+
+// FormatSimHash returns the fingerprint as hex, bson has no unsigned 64 bit integers
+func FormatSimHash(fingerprint uint64) string {
+	return fmt.Sprintf("%016x", fingerprint)
+}
+
+// ParseSimHash reads a fingerprint written by FormatSimHash
+func ParseSimHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// nearDoc is a canonical document of the index
+type nearDoc struct {
+	fingerprint uint64
+	hash        string
+	url         string
+	clusterID   string
+}
+
+/*
+NearDuplicates finds documents whose fingerprint differs in at most maxDistance bits.
+Only canonical documents are indexed, duplicates join the cluster of the first document they match.
+
+bands: canonical documents by the value of each 16 bit band of their fingerprint
+*/
+type NearDuplicates struct {
+	mu          sync.Mutex
+	maxDistance int
+	bands       [SIMHASH_BANDS]map[uint16][]*nearDoc
+	count       int
+}
+
+// NewNearDuplicates creates an empty index
+func NewNearDuplicates(maxDistance int) *NearDuplicates {
+	n := &NearDuplicates{maxDistance: min(maxDistance, MAX_SIMHASH_DISTANCE)}
+	for i := range n.bands {
+		n.bands[i] = make(map[uint16][]*nearDoc)
+	}
+	return n
+}
+
+// Load indexes the canonical documents that were already stored
+func (n *NearDuplicates) Load(docs []DocMetadata) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, doc := range docs {
+		n.addDoc(doc)
+	}
+}
+
+// Add indexes a document once it is stored, duplicates and documents without a fingerprint are skipped
+func (n *NearDuplicates) Add(docMetadata DocMetadata) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addDoc(docMetadata)
+}
+
+// Check sets the fingerprint and cluster of the document. When it is a near duplicate of an
+// indexed document it returns that document's hash and url, otherwise the document is canonical
+// and is matched by later documents once it was stored and passed to Add.
+func (n *NearDuplicates) Check(docMetadata *DocMetadata, text string) (string, string, bool) {
+	docMetadata.ClusterID = docMetadata.Hash
+	docMetadata.DuplicateOf = ""
+	docMetadata.SimHash = Fingerprint(text)
+	if docMetadata.SimHash == "" {
+		return "", "", false
+	}
+	fingerprint := SimHash(text)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if match := n.find(fingerprint); match != nil {
+		docMetadata.ClusterID = match.clusterID
+		docMetadata.DuplicateOf = match.hash
+		return match.hash, match.url, true
+	}
+	return "", "", false
+}
+
+// Remove drops the document from the index, a changed page is checked again with its new text
+func (n *NearDuplicates) Remove(docMetadata DocMetadata) {
+	if docMetadata.SimHash == "" || docMetadata.DuplicateOf != "" {
+		return
+	}
+	fingerprint, err := ParseSimHash(docMetadata.SimHash)
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	removed := false
+	for i := range n.bands {
+		key := band(fingerprint, i)
+		docs := n.bands[i][key]
+		j := slices.IndexFunc(docs, func(doc *nearDoc) bool { return doc.hash == docMetadata.Hash })
+		if j < 0 {
+			continue
+		}
+		removed = true
+		if len(docs) == 1 {
+			delete(n.bands[i], key)
+		} else {
+			n.bands[i][key] = slices.Delete(docs, j, j+1)
+		}
+	}
+	if removed {
+		n.count--
+	}
+}
+
+// Count returns the number of canonical documents
+func (n *NearDuplicates) Count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.count
+}
+
+// find returns the closest indexed document within maxDistance
+func (n *NearDuplicates) find(fingerprint uint64) *nearDoc {
+	var best *nearDoc
+	bestDistance := n.maxDistance + 1
+	for i := range n.bands {
+		for _, doc := range n.bands[i][band(fingerprint, i)] {
+			distance := bits.OnesCount64(doc.fingerprint ^ fingerprint)
+			if distance < bestDistance {
+				best = doc
+				bestDistance = distance
+			}
+		}
+	}
+	return best
+}
+
+// addDoc indexes a canonical document with its fingerprint
+func (n *NearDuplicates) addDoc(doc DocMetadata) {
+	if doc.SimHash == "" || doc.DuplicateOf != "" {
+		return
+	}
+	fingerprint, err := ParseSimHash(doc.SimHash)
+	if err != nil {
+		return
+	}
+	clusterID := doc.ClusterID
+	if clusterID == "" {
+		clusterID = doc.Hash
+	}
+	n.add(&nearDoc{fingerprint: fingerprint, hash: doc.Hash, url: doc.URL, clusterID: clusterID})
+}
+
+func (n *NearDuplicates) add(doc *nearDoc) {
+	for i := range n.bands {
+		key := band(doc.fingerprint, i)
+		n.bands[i][key] = append(n.bands[i][key], doc)
+	}
+	n.count++
+}
+
+// band returns the i-th 16 bits of the fingerprint
+func band(fingerprint uint64, i int) uint16 {
+	return uint16(fingerprint >> (16 * i))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math/bits"
+	"testing"
+)
+
+func TestNearDuplicatesBands(t *testing.T) {
+	const fingerprint = 0x0123456789abcdef
+	tests := []struct {
+		name        string
+		maxDistance int
+		flipped     []int // bits that differ from the indexed fingerprint
+		want        bool
+	}{
+		{"same fingerprint", 3, nil, true},
+		{"one bit", 3, []int{5}, true},
+		{"three bits in one band", 3, []int{0, 1, 2}, true},
+		{"three bits in three bands", 3, []int{0, 16, 32}, true},
+		{"one bit in every band is too far", 3, []int{0, 16, 32, 48}, false},
+		{"over the max distance", 1, []int{0, 16}, false},
+		// a max distance above what the bands can find is lowered
+		{"max distance is capped", 10, []int{0, 1, 2, 3}, false},
+	}
+	for _, test := range tests {
+		n := NewNearDuplicates(test.maxDistance)
+		n.add(&nearDoc{fingerprint: fingerprint, hash: "original"})
+		query := uint64(fingerprint)
+		for _, bit := range test.flipped {
+			query ^= 1 << bit
+		}
+		if got := n.find(query) != nil; got != test.want {
+			t.Errorf("%s: found %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSimHash(t *testing.T) {
+	base := "the quick brown fox jumps over the lazy dog near the quiet river bank every single morning before the sun rises over the hills"
+	tests := []struct {
+		name        string
+		text        string
+		maxDistance int
+		minDistance int
+	}{
+		{"same text", base, 0, 0},
+		{"case and punctuation", "The quick, brown fox jumps over the lazy dog; near the quiet river bank every single morning before the sun rises over the hills!", 0, 0},
+		{"one word changed", "the quick brown fox jumps over the lazy cat near the quiet river bank every single morning before the sun rises over the hills", 16, 0},
+		{"different text", "a new version of the page describing modern bridges their engineering and the materials used today in cities", 64, 10},
+	}
+	for _, test := range tests {
+		distance := bits.OnesCount64(SimHash(base) ^ SimHash(test.text))
+		if distance > test.maxDistance || distance < test.minDistance {
+			t.Errorf("%s: distance %d, want %d to %d", test.name, distance, test.minDistance, test.maxDistance)
+		}
+	}
+}
+
+func TestNearDuplicatesCheck(t *testing.T) {
+	n := NewNearDuplicates(3)
+	n.Load([]DocMetadata{
+		{Hash: "original", URL: "https://a.com/original", SimHash: Fingerprint(ORIGINAL_TEXT), ClusterID: "cluster"},
+		// duplicates and pages without a fingerprint aren't indexed
+		{Hash: "copy", SimHash: Fingerprint(NEW_TEXT), DuplicateOf: "original"},
+		{Hash: "short"},
+	})
+	if n.Count() != 1 {
+		t.Fatalf("%d canonical documents loaded, want 1", n.Count())
+	}
+
+	tests := []struct {
+		hash        string
+		text        string
+		duplicateOf string
+		clusterID   string
+	}{
+		{"same", ORIGINAL_TEXT, "original", "cluster"},
+		{"new", NEW_TEXT, "", "new"},
+		{"new-copy", NEW_TEXT, "new", "new"},
+		{"short", "too short to compare", "", "short"},
+	}
+	for _, test := range tests {
+		doc := DocMetadata{Hash: test.hash, DuplicateOf: "stale"}
+		hash, _, duplicate := n.Check(&doc, test.text)
+		if duplicate != (test.duplicateOf != "") || hash != test.duplicateOf {
+			t.Errorf("%s: Check = %q, %v, want %q", test.hash, hash, duplicate, test.duplicateOf)
+		}
+		if doc.DuplicateOf != test.duplicateOf || doc.ClusterID != test.clusterID {
+			t.Errorf("%s: duplicate of %q in cluster %q, want %q in %q", test.hash, doc.DuplicateOf, doc.ClusterID, test.duplicateOf, test.clusterID)
+		}
+		// the document is stored
+		n.Add(doc)
+	}
+
+	// a removed page doesn't match anymore
+	n.Remove(DocMetadata{Hash: "original", SimHash: Fingerprint(ORIGINAL_TEXT)})
+	doc := DocMetadata{Hash: "later"}
+	if _, _, duplicate := n.Check(&doc, ORIGINAL_TEXT); duplicate {
+		t.Error("removed document still matches")
+	}
+	if n.Count() != 1 {
+		t.Errorf("%d canonical documents, want 1", n.Count())
+	}
+}
+
+func TestNearDuplicatesCheckDoesntIndex(t *testing.T) {
+	n := NewNearDuplicates(3)
+	// a document that was checked but not stored isn't matched
+	first := DocMetadata{Hash: "first"}
+	n.Check(&first, ORIGINAL_TEXT)
+	second := DocMetadata{Hash: "second"}
+	if _, _, duplicate := n.Check(&second, ORIGINAL_TEXT); duplicate || n.Count() != 0 {
+		t.Errorf("unstored document matches, %d canonical documents", n.Count())
+	}
+	n.Add(second)
+	third := DocMetadata{Hash: "third"}
+	if hash, _, _ := n.Check(&third, ORIGINAL_TEXT); hash != "second" {
+		t.Errorf("matched %q, want the stored document", hash)
+	}
+}
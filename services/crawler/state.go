@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// key prefixes in the crawl state db
+const PENDING_PREFIX = "pending:"
+const VISITED_PREFIX = "visited:"
+const SPILL_PREFIX = "spill:"
+const INLINKS_PREFIX = "inlinks:"
+const HOST_PAGES_PREFIX = "hostpages:"
+const SEED_PAGES_PREFIX = "seedpages:"
+const STORED_PREFIX = "stored:"
+const CHECKPOINT_KEY = "checkpoint"
+const CONFIG_KEY = "config"
+const TOPIC_KEY = "topic"
+
+// Checkpoint is the progress of a crawl, saved periodically while it runs
+type Checkpoint struct {
+	CrawlID   string
+	StartedAt time.Time
+	UpdatedAt time.Time
+	Visited   int
+	Queued    int
+	Pages     int
+	Bytes     int64
+	Finished  bool
+	// budget or event that ended the last run, STOP_FINISHED when no jobs were left
+	StopReason string `json:",omitempty"`
+}
+
+/*
+CrawlState persists the frontier and the visited set of a crawl so it can be resumed.
+A job is pending from the moment it is discovered until it has been processed,
+then it moves to the visited set.
+*/
+type CrawlState struct {
+	db      *badger.DB
+	crawlID string
+}
+
+// OpenCrawlState opens (or creates) the state of the crawl with the given id
+func OpenCrawlState(dir string, crawlID string) (*CrawlState, error) {
+	opts := badger.DefaultOptions(filepath.Join(dir, crawlID))
+	opts.Logger = nil // Disable logging
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &CrawlState{db: db, crawlID: crawlID}, nil
+}
+
+// AddPending saves newly discovered jobs
+func (s *CrawlState) AddPending(jobs []Job) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, job := range jobs {
+		jobBytes, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		err = wb.Set([]byte(PENDING_PREFIX+job.URL), jobBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// Complete moves a processed job from the pending jobs to the visited set
+func (s *CrawlState) Complete(job Job) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		err := txn.Delete([]byte(PENDING_PREFIX + job.URL))
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte(VISITED_PREFIX+job.URL), nil)
+	})
+}
+
+// RemovePending drops pending jobs that were handed to another instance
+func (s *CrawlState) RemovePending(jobs []Job) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, job := range jobs {
+		err := wb.Delete([]byte(PENDING_PREFIX + job.URL))
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// StoredPage is the hash of a stored page and the in-link count saved in its metadata
+type StoredPage struct {
+	Hash    string
+	InLinks int
+}
+
+// AddInLinks adds one to the in-link count of every url
+func (s *CrawlState) AddInLinks(urls []string) error {
+	keys := make([]string, 0, len(urls))
+	for _, url := range urls {
+		keys = append(keys, INLINKS_PREFIX+url)
+	}
+	return s.increment(keys)
+}
+
+// AddPage counts a stored page for the budgets of its host and of its seed, jobs without a seed only count for the host
+func (s *CrawlState) AddPage(host string, seed string) error {
+	keys := []string{HOST_PAGES_PREFIX + host}
+	if seed != "" {
+		keys = append(keys, SEED_PAGES_PREFIX+seed)
+	}
+	return s.increment(keys)
+}
+
+// PageCounts returns the stored pages per host and per seed
+func (s *CrawlState) PageCounts() (map[string]int, map[string]int, error) {
+	hosts := make(map[string]int)
+	seeds := make(map[string]int)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for _, counts := range []struct {
+			prefix []byte
+			dst    map[string]int
+		}{{[]byte(HOST_PAGES_PREFIX), hosts}, {[]byte(SEED_PAGES_PREFIX), seeds}} {
+			for it.Seek(counts.prefix); it.ValidForPrefix(counts.prefix); it.Next() {
+				err := it.Item().Value(func(val []byte) error {
+					counts.dst[string(it.Item().Key()[len(counts.prefix):])] = int(binary.BigEndian.Uint64(val))
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return hosts, seeds, err
+}
+
+// increment adds one to every counter, each in its own transaction so workers
+// counting links to the same popular pages rarely conflict
+func (s *CrawlState) increment(keys []string) error {
+	for _, k := range keys {
+		key := []byte(k)
+		for {
+			err := s.db.Update(func(txn *badger.Txn) error {
+				count, err := readCount(txn, key)
+				if err != nil {
+					return err
+				}
+				value := make([]byte, 8)
+				binary.BigEndian.PutUint64(value, count+1)
+				return txn.Set(key, value)
+			})
+			// another worker updated the same counter at the same time
+			if errors.Is(err, badger.ErrConflict) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// SaveStored records stored pages by url, with the in-link count their metadata was saved with
+func (s *CrawlState) SaveStored(pages map[string]StoredPage) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for url, page := range pages {
+		pageBytes, err := json.Marshal(page)
+		if err != nil {
+			return err
+		}
+		err = wb.Set([]byte(STORED_PREFIX+url), pageBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// StaleInLinks returns the stored pages whose in-link count changed since their metadata
+// was saved, by url with the current count
+func (s *CrawlState) StaleInLinks() (map[string]StoredPage, error) {
+	stale := make(map[string]StoredPage)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(STORED_PREFIX)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			url := string(it.Item().Key()[len(prefix):])
+			var page StoredPage
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &page)
+			})
+			if err != nil {
+				return err
+			}
+			count, err := readCount(txn, []byte(INLINKS_PREFIX+url))
+			if err != nil {
+				return err
+			}
+			if int(count) != page.InLinks {
+				stale[url] = StoredPage{Hash: page.Hash, InLinks: int(count)}
+			}
+		}
+		return nil
+	})
+	return stale, err
+}
+
+// InLinks returns the number of crawled pages linking to the url
+func (s *CrawlState) InLinks(url string) (int, error) {
+	var count uint64
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		count, err = readCount(txn, []byte(INLINKS_PREFIX+url))
+		return err
+	})
+	return int(count), err
+}
+
+// readCount reads a counter, missing counters are 0
+func readCount(txn *badger.Txn, key []byte) (uint64, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var count uint64
+	err = item.Value(func(val []byte) error {
+		count = binary.BigEndian.Uint64(val)
+		return nil
+	})
+	return count, err
+}
+
+// PendingJobs returns all jobs that were discovered but not processed
+func (s *CrawlState) PendingJobs() ([]Job, error) {
+	jobs := []Job{}
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(PENDING_PREFIX)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var job Job
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &job)
+			})
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// VisitedURLs returns all urls that were already processed
+func (s *CrawlState) VisitedURLs() ([]string, error) {
+	urls := []string{}
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := []byte(VISITED_PREFIX)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			urls = append(urls, string(it.Item().Key()[len(prefix):]))
+		}
+		return nil
+	})
+	return urls, err
+}
+
+// SaveSpilled writes jobs that don't fit in the frontier's memory, starting at sequence number seq
+func (s *CrawlState) SaveSpilled(seq uint64, jobs []Job) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for i, job := range jobs {
+		jobBytes, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		err = wb.Set(spillKey(seq+uint64(i)), jobBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// LoadSpilled removes and returns up to n spilled jobs in the order they were saved
+func (s *CrawlState) LoadSpilled(n int) ([]Job, error) {
+	jobs := []Job{}
+	err := s.db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(SPILL_PREFIX)
+		keys := [][]byte{}
+		for it.Seek(prefix); it.ValidForPrefix(prefix) && len(jobs) < n; it.Next() {
+			var job Job
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &job)
+			})
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		for _, key := range keys {
+			err := txn.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// ClearSpilled removes spilled jobs left by a previous run, they are still in the pending jobs
+func (s *CrawlState) ClearSpilled() error {
+	return s.db.DropPrefix([]byte(SPILL_PREFIX))
+}
+
+// spillKey keeps spilled jobs sorted by sequence number
+func spillKey(seq uint64) []byte {
+	key := make([]byte, len(SPILL_PREFIX)+8)
+	copy(key, SPILL_PREFIX)
+	binary.BigEndian.PutUint64(key[len(SPILL_PREFIX):], seq)
+	return key
+}
+
+// LoadCheckpoint returns the last checkpoint, false if the crawl never saved one
+func (s *CrawlState) LoadCheckpoint() (Checkpoint, bool, error) {
+	checkpoint := Checkpoint{}
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(CHECKPOINT_KEY))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &checkpoint)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return checkpoint, false, nil
+	}
+	return checkpoint, err == nil, err
+}
+
+// SaveCheckpoint saves the checkpoint and syncs everything written so far to disk
+func (s *CrawlState) SaveCheckpoint(checkpoint Checkpoint) error {
+	checkpoint.CrawlID = s.crawlID
+	checkpoint.UpdatedAt = time.Now()
+	err := s.db.Update(func(txn *badger.Txn) error {
+		checkpointBytes, err := json.Marshal(checkpoint)
+		if err != nil {
+			return err
+		}
+		return txn.Set([]byte(CHECKPOINT_KEY), checkpointBytes)
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Sync()
+}
+
+// SaveConfig saves the config the crawl was started with, so the run can be reproduced
+func (s *CrawlState) SaveConfig(config *Config) error {
+	configBytes, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(CONFIG_KEY), configBytes)
+	})
+}
+
+// SaveTopic saves the topic of a focused crawl, learned from its seed pages
+func (s *CrawlState) SaveTopic(profiles []TopicProfile) error {
+	topicBytes, err := json.Marshal(profiles)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(TOPIC_KEY), topicBytes)
+	})
+}
+
+// LoadTopic returns the saved topic of a focused crawl, nil if none was saved
+func (s *CrawlState) LoadTopic() ([]TopicProfile, error) {
+	var profiles []TopicProfile
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(TOPIC_KEY))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &profiles)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	return profiles, err
+}
+
+// Close closes the state db
+func (s *CrawlState) Close() error {
+	return s.db.Close()
+}
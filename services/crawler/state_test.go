@@ -0,0 +1,91 @@
+package main
+
+import (
+	"maps"
+	"sync"
+	"testing"
+)
+
+func openTestState(t *testing.T) *CrawlState {
+	state, err := OpenCrawlState(t.TempDir(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { state.Close() })
+	return state
+}
+
+func TestAddInLinksConcurrent(t *testing.T) {
+	state := openTestState(t)
+	urls := []string{"https://a.com/", "https://b.com/", "https://c.com/"}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				err := state.AddInLinks(urls)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, url := range urls {
+		count, err := state.InLinks(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 400 {
+			t.Errorf("in-links of %s = %d, want 400", url, count)
+		}
+	}
+}
+
+func TestStaleInLinks(t *testing.T) {
+	state := openTestState(t)
+	err := state.AddInLinks([]string{"https://a.com/", "https://b.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = state.SaveStored(map[string]StoredPage{
+		"https://a.com/": {Hash: "ha", InLinks: 1},
+		"https://b.com/": {Hash: "hb", InLinks: 1},
+		"https://c.com/": {Hash: "hc", InLinks: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// links found after the pages were stored
+	err = state.AddInLinks([]string{"https://b.com/", "https://c.com/", "https://d.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := state.StaleInLinks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]StoredPage{
+		"https://b.com/": {Hash: "hb", InLinks: 2},
+		"https://c.com/": {Hash: "hc", InLinks: 1},
+	}
+	if !maps.Equal(stale, want) {
+		t.Errorf("stale = %v, want %v", stale, want)
+	}
+
+	// saved counts aren't stale anymore
+	err = state.SaveStored(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err = state.StaleInLinks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 0 {
+		t.Errorf("stale after saving = %v, want none", stale)
+	}
+}
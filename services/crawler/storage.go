@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"log"
+	"sync"
 
 	"github.com/minio/minio-go/v7"
 	"go.mongodb.org/mongo-driver/bson"
@@ -14,17 +16,36 @@ import (
 type Storage interface {
 	SaveHTML(hash string, body []byte) error
 	SaveMetadata(docMetadata DocMetadata) error
+	ListMetadata() ([]DocMetadata, error)
+	DeleteMetadata(hash string) error
+	SaveChanges(changes []DocChange) error
 	CreateMetadataDirectory(name string) error
 	CreateHTMLDirectory(name string) error
 	FlushMetadata() error
+	// UpdateInLinks sets the in-link counts of stored pages, by hash
+	UpdateInLinks(counts map[string]int) error
+	SaveFetchLogs(logs []FetchLog) error
+	ListFetchLogs(crawlID string) ([]FetchLog, error)
+	// SaveImages replaces the images of the page with the given hash
+	SaveImages(pageHash string, images []Image) error
+	DeleteImages(pageHash string) error
 }
 
-// minio and mongodb storage
+// content encoding of the html uploaded to minio
+const HTML_ENCODING = "gzip"
+
+/*
+minio and mongodb storage, mu guards the metadata queue and the encodings.
+encodings: content encoding of the pages known to be in the bucket by hash,
+a page is uploaded once and its metadata records the encoding
+*/
 type MinioMongoStorage struct {
 	mongoConnection *mongo.Client
 	minioClient     *minio.Client
+	mu              sync.Mutex
 	metadataQueue   []interface{}
 	maxMetadataJobs int
+	encodings       map[string]string
 }
 
 func NewMinioMongoStorage(mongoUri string, minioClient *minio.Client, ctx context.Context) *MinioMongoStorage {
@@ -32,11 +53,19 @@ func NewMinioMongoStorage(mongoUri string, minioClient *minio.Client, ctx contex
 	if err != nil {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
+	// images are replaced and deleted by page
+	_, err = mongoConnection.Database("crawler").Collection("images").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "pagehash", Value: 1}},
+	})
+	if err != nil {
+		log.Fatalf("Failed to create image indexes: %v", err)
+	}
 	return &MinioMongoStorage{
 		mongoConnection: mongoConnection,
 		minioClient:     minioClient,
 		metadataQueue:   make([]interface{}, 0),
 		maxMetadataJobs: 300,
+		encodings:       make(map[string]string),
 	}
 }
 
@@ -61,23 +90,79 @@ func (s *MinioMongoStorage) CreateHTMLDirectory(name string) error {
 	return nil
 }
 
+// SaveHTML uploads the page gzip compressed, pages already in the bucket are skipped
 func (s *MinioMongoStorage) SaveHTML(hash string, body []byte) error {
 	objectName := hash + ".html"
 	contentType := "text/html"
 
+	s.mu.Lock()
+	_, saved := s.encodings[hash]
+	s.mu.Unlock()
+	if saved {
+		return nil
+	}
+	// the page may have been uploaded by an earlier run or another instance, maybe uncompressed
+	info, err := s.minioClient.StatObject(context.Background(), PAGES_DIR, objectName, minio.StatObjectOptions{})
+	if err == nil {
+		s.setEncoding(hash, info.Metadata.Get("Content-Encoding"))
+		return nil
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return err
+	}
+
+	compressed, err := compressHTML(body)
+	if err != nil {
+		return err
+	}
 	// upload the html to minio
-	_, err := s.minioClient.PutObject(context.Background(), PAGES_DIR, objectName, bytes.NewReader(body), int64(len(body)), minio.PutObjectOptions{ContentType: contentType})
+	_, err = s.minioClient.PutObject(context.Background(), PAGES_DIR, objectName, bytes.NewReader(compressed), int64(len(compressed)), minio.PutObjectOptions{
+		ContentType:     contentType,
+		ContentEncoding: HTML_ENCODING,
+	})
 	if err != nil {
 		return err
 	}
+	s.setEncoding(hash, HTML_ENCODING)
 	return nil
 }
 
+func (s *MinioMongoStorage) setEncoding(hash string, encoding string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encodings[hash] = encoding
+}
+
+// compressHTML returns the page gzip compressed
+func compressHTML(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func (s *MinioMongoStorage) SaveMetadata(docMetadata DocMetadata) error {
-	s.metadataQueue = append(s.metadataQueue, docMetadata)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// metadata saved without its html, like on a recrawl of an unchanged page, keeps its encoding
+	if encoding, ok := s.encodings[docMetadata.Hash]; ok {
+		docMetadata.ContentEncoding = encoding
+	}
+	// a full queue is written first, when that fails the document isn't queued and can be saved again
 	if len(s.metadataQueue) >= s.maxMetadataJobs {
-		return s.saveBatchMetadata()
+		err := s.saveBatchMetadata()
+		if err != nil {
+			return err
+		}
 	}
+	s.metadataQueue = append(s.metadataQueue, docMetadata)
 	return nil
 }
 
@@ -97,13 +182,120 @@ func (s *MinioMongoStorage) saveBatchMetadata() error {
 		)
 	}
 	_, err := coll.BulkWrite(context.Background(), models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		// keep the batch for the next flush, the upserts can be repeated
+		return err
+	}
 	s.metadataQueue = s.metadataQueue[:0]
-	return err
+	return nil
 }
 
 func (s *MinioMongoStorage) FlushMetadata() error {
-	if len(s.metadataQueue) == 0 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveBatchMetadata()
+}
+
+// UpdateInLinks only sets the counts, the rest of the documents isn't written again
+func (s *MinioMongoStorage) UpdateInLinks(counts map[string]int) error {
+	if len(counts) == 0 {
 		return nil
 	}
-	return s.saveBatchMetadata()
+	coll := s.mongoConnection.Database("crawler").Collection("metadata")
+	models := make([]mongo.WriteModel, 0, len(counts))
+	for hash, inLinks := range counts {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"hash": hash}).
+			SetUpdate(bson.M{"$set": bson.M{"inlinks": inLinks}}),
+		)
+	}
+	_, err := coll.BulkWrite(context.Background(), models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (s *MinioMongoStorage) ListMetadata() ([]DocMetadata, error) {
+	coll := s.mongoConnection.Database("crawler").Collection("metadata")
+	cursor, err := coll.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var docs []DocMetadata
+	err = cursor.All(context.Background(), &docs)
+	if err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (s *MinioMongoStorage) DeleteMetadata(hash string) error {
+	coll := s.mongoConnection.Database("crawler").Collection("metadata")
+	_, err := coll.DeleteOne(context.Background(), bson.M{"hash": hash})
+	return err
+}
+
+// save the changes found by a recrawl, the indexer reads them from the changes collection
+func (s *MinioMongoStorage) SaveChanges(changes []DocChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	coll := s.mongoConnection.Database("crawler").Collection("changes")
+	docs := make([]interface{}, 0, len(changes))
+	for _, change := range changes {
+		docs = append(docs, change)
+	}
+	_, err := coll.InsertMany(context.Background(), docs)
+	return err
+}
+
+// save fetch attempts to the fetch_log collection
+func (s *MinioMongoStorage) SaveFetchLogs(logs []FetchLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	coll := s.mongoConnection.Database("crawler").Collection("fetch_log")
+	docs := make([]interface{}, 0, len(logs))
+	for _, entry := range logs {
+		docs = append(docs, entry)
+	}
+	_, err := coll.InsertMany(context.Background(), docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+func (s *MinioMongoStorage) ListFetchLogs(crawlID string) ([]FetchLog, error) {
+	coll := s.mongoConnection.Database("crawler").Collection("fetch_log")
+	cursor, err := coll.Find(context.Background(), bson.M{"crawlid": crawlID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var logs []FetchLog
+	err = cursor.All(context.Background(), &logs)
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// save the images of a page to the images collection, replacing the ones saved before
+func (s *MinioMongoStorage) SaveImages(pageHash string, images []Image) error {
+	err := s.DeleteImages(pageHash)
+	if err != nil || len(images) == 0 {
+		return err
+	}
+	coll := s.mongoConnection.Database("crawler").Collection("images")
+	docs := make([]interface{}, 0, len(images))
+	for _, image := range images {
+		docs = append(docs, image)
+	}
+	_, err = coll.InsertMany(context.Background(), docs)
+	return err
+}
+
+func (s *MinioMongoStorage) DeleteImages(pageHash string) error {
+	coll := s.mongoConnection.Database("crawler").Collection("images")
+	_, err := coll.DeleteMany(context.Background(), bson.M{"pagehash": pageHash})
+	return err
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCompressHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"empty", ""},
+		{"page", "<html><body>page</body></html>"},
+		{"large page", strings.Repeat("<p>paragraph</p>", 10000)},
+	}
+	for _, test := range tests {
+		compressed, err := compressHTML([]byte(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// readers of old records recognize compressed pages by the gzip magic bytes
+		if !bytes.HasPrefix(compressed, []byte{0x1f, 0x8b}) {
+			t.Errorf("%s: compressed page doesn't start with the gzip magic bytes", test.name)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != test.body {
+			t.Errorf("%s: decompressed %d bytes, want %d", test.name, len(body), len(test.body))
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// frontier ordering strategies
+const STRATEGY_BFS = "bfs"
+const STRATEGY_DFS = "dfs"
+const STRATEGY_OPIC = "opic"
+const STRATEGY_RELEVANCE = "relevance"
+const STRATEGY_FOCUSED = "focused"
+
+// words shorter than this are left out of the term vectors
+const MIN_TERM_LENGTH = 3
+
+// pages whose OPIC cash is kept, the half with the least cash is dropped when there are more
+const MAX_OPIC_PAGES = 100000
+
+// Strategy decides the order in which the frontier hands out jobs, higher priorities go first
+type Strategy interface {
+	// Seed sets the priority of the start jobs
+	Seed(jobs []Job)
+	// Prioritize sets the priority of the links found on the page of job, text is the page's text
+	Prioritize(job Job, text string, links []Job)
+}
+
+// NewStrategy returns the strategy of the config, BFS for unknown names
+func NewStrategy(config *Config) Strategy {
+	switch config.Strategy {
+	case STRATEGY_DFS:
+		return dfsStrategy{}
+	case STRATEGY_OPIC:
+		return &opicStrategy{cash: make(map[string]float64), limit: MAX_OPIC_PAGES}
+	case STRATEGY_RELEVANCE:
+		// a focused crawl that follows every link, the ones on topic first
+		return newFocusedStrategy(config.FocusKeywords, 0)
+	case STRATEGY_FOCUSED:
+		return newFocusedStrategy(config.FocusKeywords, config.FocusThreshold)
+	}
+	return bfsStrategy{}
+}
+
+// bfsStrategy crawls level by level, shallow pages first
+type bfsStrategy struct{}
+
+func (bfsStrategy) Seed(jobs []Job) {}
+
+func (bfsStrategy) Prioritize(job Job, text string, links []Job) {
+	for i := range links {
+		links[i].Priority = -float64(links[i].Depth)
+	}
+}
+
+// dfsStrategy follows links down to MaxDepth before going back up, deep pages first
+type dfsStrategy struct{}
+
+func (dfsStrategy) Seed(jobs []Job) {}
+
+func (dfsStrategy) Prioritize(job Job, text string, links []Job) {
+	for i := range links {
+		links[i].Priority = float64(links[i].Depth)
+	}
+}
+
+/*
+opicStrategy is On-line Page Importance Computation. Every seed starts with the same cash,
+a crawled page hands its cash out equally to its links, and pages are crawled in order of
+the cash they collected. Pages linked from many important pages collect the most.
+
+cash: cash collected by pages that weren't crawled yet, by url. Pages that are never crawled,
+like the ones robots.txt disallows, would stay forever, so at most limit pages keep their cash.
+A page whose cash was dropped still has the cash it was queued with as its priority.
+*/
+type opicStrategy struct {
+	mu    sync.Mutex
+	cash  map[string]float64
+	limit int
+}
+
+func (s *opicStrategy) Seed(jobs []Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range jobs {
+		s.cash[jobs[i].URL] += 1 / float64(len(jobs))
+		jobs[i].Priority = s.cash[jobs[i].URL]
+	}
+}
+
+func (s *opicStrategy) Prioritize(job Job, text string, links []Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the cash of a resumed crawl is only known from the priority of the job
+	cash := max(s.cash[job.URL], job.Priority)
+	delete(s.cash, job.URL)
+	if len(links) == 0 {
+		return
+	}
+	share := cash / float64(len(links))
+	for i := range links {
+		s.cash[links[i].URL] += share
+		// a link found again is queued again with its new cash, the first copy out wins
+		links[i].Priority = s.cash[links[i].URL]
+	}
+	if len(s.cash) > s.limit {
+		s.evict()
+	}
+}
+
+// evict drops the cash of the half of the pages with the least of it
+func (s *opicStrategy) evict() {
+	cash := make([]float64, 0, len(s.cash))
+	for _, c := range s.cash {
+		cash = append(cash, c)
+	}
+	slices.Sort(cash)
+	cutoff := cash[len(cash)/2]
+	for url, c := range s.cash {
+		if c < cutoff {
+			delete(s.cash, url)
+		}
+	}
+	// pages with the same cash as the cutoff go too until half is left
+	for url, c := range s.cash {
+		if len(s.cash) <= len(cash)-len(cash)/2 {
+			break
+		}
+		if c == cutoff {
+			delete(s.cash, url)
+		}
+	}
+}
+
+// termVector counts the words of the text
+func termVector(text string) map[string]float64 {
+	terms := make(map[string]float64)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		if len([]rune(word)) >= MIN_TERM_LENGTH {
+			terms[word]++
+		}
+	}
+	return terms
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestStrategyOrder(t *testing.T) {
+	tests := []struct {
+		strategy string
+		// the link that should be crawled first
+		first string
+	}{
+		{STRATEGY_BFS, "https://a.com/shallow"},
+		{STRATEGY_DFS, "https://a.com/deep"},
+	}
+	for _, test := range tests {
+		strategy := NewStrategy(&Config{Strategy: test.strategy})
+		links := []Job{
+			{URL: "https://a.com/shallow", Depth: 1},
+			{URL: "https://a.com/deep", Depth: 3},
+		}
+		strategy.Prioritize(Job{URL: "https://a.com/"}, "", links)
+		first := links[0]
+		if links[1].Priority > first.Priority {
+			first = links[1]
+		}
+		if first.URL != test.first {
+			t.Errorf("%s: %s goes first, want %s", test.strategy, first.URL, test.first)
+		}
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{STRATEGY_BFS, "main.bfsStrategy"},
+		{STRATEGY_DFS, "main.dfsStrategy"},
+		{STRATEGY_OPIC, "*main.opicStrategy"},
+		{STRATEGY_RELEVANCE, "*main.focusedStrategy"},
+		{STRATEGY_FOCUSED, "*main.focusedStrategy"},
+		{"unknown", "main.bfsStrategy"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprintf("%T", NewStrategy(&Config{Strategy: test.name})); got != test.want {
+			t.Errorf("NewStrategy(%q) = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestOpicCash(t *testing.T) {
+	s := NewStrategy(&Config{Strategy: STRATEGY_OPIC}).(*opicStrategy)
+	seeds := []Job{{URL: "https://a.com/"}, {URL: "https://b.com/"}}
+	s.Seed(seeds)
+	for _, seed := range seeds {
+		if seed.Priority != 0.5 {
+			t.Errorf("seed %s has cash %v, want 0.5", seed.URL, seed.Priority)
+		}
+	}
+
+	// a splits its cash between two links, b gives all of its cash to one of them
+	fromA := []Job{{URL: "https://c.com/"}, {URL: "https://d.com/"}}
+	s.Prioritize(seeds[0], "", fromA)
+	fromB := []Job{{URL: "https://d.com/"}}
+	s.Prioritize(seeds[1], "", fromB)
+
+	tests := []struct {
+		job  Job
+		cash float64
+	}{
+		{fromA[0], 0.25},
+		{fromA[1], 0.25},
+		// linked from both seeds
+		{fromB[0], 0.75},
+	}
+	for _, test := range tests {
+		if math.Abs(test.job.Priority-test.cash) > 1e-9 {
+			t.Errorf("%s has cash %v, want %v", test.job.URL, test.job.Priority, test.cash)
+		}
+	}
+	// crawled pages hand out their cash and are forgotten
+	if _, ok := s.cash["https://a.com/"]; ok {
+		t.Error("cash of a crawled page is kept")
+	}
+	if len(s.cash) != 2 {
+		t.Errorf("cash kept for %d pages, want 2", len(s.cash))
+	}
+}
+
+func TestOpicCashLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		// cash of link i is share(i)
+		share func(i int) float64
+	}{
+		{"different cash", func(i int) float64 { return float64(i) }},
+		{"same cash", func(i int) float64 { return 1 }},
+	}
+	for _, test := range tests {
+		s := &opicStrategy{cash: make(map[string]float64), limit: 100}
+		for i := range 1000 {
+			page := Job{URL: fmt.Sprintf("https://a.com/page/%d", i), Priority: test.share(i)}
+			s.Prioritize(page, "", []Job{{URL: fmt.Sprintf("https://a.com/link/%d", i)}})
+			if len(s.cash) > s.limit {
+				t.Fatalf("%s: cash kept for %d pages, limit %d", test.name, len(s.cash), s.limit)
+			}
+		}
+		if len(s.cash) < s.limit/2 {
+			t.Errorf("%s: cash kept for %d pages, want at least half of the limit", test.name, len(s.cash))
+		}
+		// the pages with the most cash are kept
+		if _, ok := s.cash["https://a.com/link/999"]; !ok {
+			t.Errorf("%s: cash of the last page was dropped", test.name)
+		}
+	}
+}
+
+func TestRelevanceStrategy(t *testing.T) {
+	s := NewStrategy(&Config{Strategy: STRATEGY_RELEVANCE})
+	seed := []Job{{URL: "https://a.com/wiki/Physics"}}
+	s.Seed(seed)
+	focused := s.(*focusedStrategy)
+
+	// the seed page defines the topic, its links are always followed
+	if _, follow := focused.Score(seed[0], PHYSICS_TEXT); !follow {
+		t.Fatal("links of the seed page aren't followed")
+	}
+	// without a threshold the links of off topic pages are followed too
+	offTopic := Job{URL: "https://a.com/wiki/Cooking", Depth: 1}
+	if _, follow := focused.Score(offTopic, COOKING_TEXT); !follow {
+		t.Error("links of an off topic page aren't followed")
+	}
+
+	links := []Job{
+		{URL: "https://a.com/wiki/Bread", Depth: 1, Anchor: "bread and butter"},
+		{URL: "https://a.com/wiki/Quantum_mechanics", Depth: 1, Anchor: "quantum mechanics"},
+	}
+	s.Prioritize(seed[0], PHYSICS_TEXT, links)
+	if links[1].Priority <= links[0].Priority {
+		t.Errorf("on topic link has priority %v, off topic link %v", links[1].Priority, links[0].Priority)
+	}
+}
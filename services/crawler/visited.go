@@ -26,6 +26,12 @@ func (v *Visited) IsVisited(url string) bool {
 	return v.visited[url]
 }
 
+func (v *Visited) Count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.visited)
+}
+
 func (v *Visited) GetVisited() map[string]bool {
 	v.mu.Lock()
 	defer v.mu.Unlock()
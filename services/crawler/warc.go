@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const WARC_VERSION = "WARC/1.1"
+
+// WarcRecord is a single record of a WARC file
+type WarcRecord struct {
+	Header textproto.MIMEHeader
+	Block  []byte
+}
+
+// NewWarcRecord creates a record of the given type with a new id and the current date
+func NewWarcRecord(recordType string, targetURI string, contentType string, block []byte) *WarcRecord {
+	header := textproto.MIMEHeader{}
+	header.Set("WARC-Type", recordType)
+	header.Set("WARC-Record-ID", "<urn:uuid:"+uuid.NewString()+">")
+	header.Set("WARC-Date", time.Now().UTC().Format(time.RFC3339))
+	if targetURI != "" {
+		header.Set("WARC-Target-URI", targetURI)
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("WARC-Block-Digest", warcDigest(block))
+	return &WarcRecord{Header: header, Block: block}
+}
+
+// ID returns the WARC-Record-ID of the record
+func (r *WarcRecord) ID() string {
+	return r.Header.Get("WARC-Record-ID")
+}
+
+// WriteTo writes the record in the WARC format
+func (r *WarcRecord) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	b.WriteString(WARC_VERSION + "\r\n")
+	// WARC-Type and WARC-Record-ID first, the rest in a stable order
+	for _, name := range []string{"WARC-Type", "WARC-Record-ID"} {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, r.Header.Get(name))
+	}
+	for _, name := range sortedKeys(r.Header) {
+		if name == "Warc-Type" || name == "Warc-Record-Id" || name == "Content-Length" {
+			continue
+		}
+		for _, value := range r.Header[name] {
+			fmt.Fprintf(&b, "%s: %s\r\n", warcFieldName(name), value)
+		}
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(r.Block))
+
+	n, err := io.WriteString(w, b.String())
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	n, err = w.Write(r.Block)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+	n, err = io.WriteString(w, "\r\n\r\n")
+	return written + int64(n), err
+}
+
+// ReadWarcRecord reads the next record, it returns io.EOF when there are no more records
+func ReadWarcRecord(r *bufio.Reader) (*WarcRecord, error) {
+	// skip blank lines between records
+	var line string
+	var err error
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			break
+		}
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, fmt.Errorf("invalid WARC record start %q", line)
+	}
+
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WARC Content-Length: %w", err)
+	}
+	block := make([]byte, length)
+	_, err = io.ReadFull(r, block)
+	if err != nil {
+		return nil, err
+	}
+	return &WarcRecord{Header: header, Block: block}, nil
+}
+
+// warcDigest is the labelled sha1 digest used by WARC-Block-Digest and WARC-Payload-Digest
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// warcFieldName undoes the canonicalization of textproto for the WARC- fields
+func warcFieldName(name string) string {
+	switch name {
+	case "Warc-Ip-Address":
+		return "WARC-IP-Address"
+	case "Warc-Target-Uri":
+		return "WARC-Target-URI"
+	case "Warc-Warcinfo-Id":
+		return "WARC-Warcinfo-ID"
+	case "Warc-Refers-To-Target-Uri":
+		return "WARC-Refers-To-Target-URI"
+	}
+	if rest, ok := strings.CutPrefix(name, "Warc-"); ok {
+		return "WARC-" + rest
+	}
+	return name
+}
+
+func sortedKeys(header textproto.MIMEHeader) []string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// a new WARC file is started once the current one reaches this size
+const WARC_MAX_SIZE = 1 << 30
+
+// marks a metadata record that removes the document with the given hash
+const WARC_DELETED_HEADER = "X-Crawler-Deleted"
+
+/*
+WarcStorage writes the crawl as gzipped WARC files, one gzip member per record.
+Every page gets a request, a response and a metadata record, the metadata record
+holds the DocMetadata as JSON. Pages with a body that was already written get
+a revisit record instead of a response. The response headers are reconstructed,
+the crawler doesn't keep the original ones.
+
+	<dir>/warc/<crawl id>-00000.warc.gz
+	<dir>/changes.jsonl
+	<dir>/fetch_log.jsonl
+	<dir>/images.jsonl
+
+bodies: html saved by SaveHTML, waiting for the metadata with the url of the page
+written: id of the response record of every body written so far, by hash
+docs: latest metadata by hash, read back from the metadata records when the storage is opened
+*/
+type WarcStorage struct {
+	mu        sync.Mutex
+	dir       string
+	warcDir   string
+	prefix    string
+	userAgent string
+	file      *os.File
+	size      int64
+	seq       int
+	infoID    string
+	bodies    map[string][]byte
+	written   map[string]string
+	docs      map[string]DocMetadata
+}
+
+// NewWarcStorage creates a storage writing WARC files to dir/warc, named after the crawl
+func NewWarcStorage(dir string, crawlID string, userAgent string) (*WarcStorage, error) {
+	s := &WarcStorage{
+		dir:       dir,
+		warcDir:   filepath.Join(dir, "warc"),
+		prefix:    crawlID,
+		userAgent: userAgent,
+		bodies:    make(map[string][]byte),
+		written:   make(map[string]string),
+		docs:      make(map[string]DocMetadata),
+	}
+	err := os.MkdirAll(s.warcDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(s.warcDir, "*.warc.gz"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		err = s.load(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *WarcStorage) CreateMetadataDirectory(name string) error {
+	// metadata is written to the WARC files next to the pages
+	return nil
+}
+
+func (s *WarcStorage) CreateHTMLDirectory(name string) error {
+	return os.MkdirAll(s.warcDir, 0755)
+}
+
+// SaveHTML keeps the page until its metadata is saved, the records need the url
+func (s *WarcStorage) SaveHTML(hash string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies[hash] = body
+	return nil
+}
+
+// SaveMetadata writes the records of the page
+func (s *WarcStorage) SaveMetadata(docMetadata DocMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	targetURI := docMetadata.FinalURL
+	if targetURI == "" {
+		targetURI = docMetadata.URL
+	}
+	records := make([]*WarcRecord, 0, 3)
+	concurrentTo := ""
+	body, withBody := s.bodies[docMetadata.Hash]
+	firstCopy := ""
+	if withBody {
+		request := NewWarcRecord("request", targetURI, "application/http; msgtype=request", s.httpRequest(targetURI))
+		var response *WarcRecord
+		if id, ok := s.written[docMetadata.Hash]; ok {
+			// same content under another url, refer to the first copy
+			response = NewWarcRecord("revisit", targetURI, "application/http; msgtype=response", httpResponseHeader(docMetadata, len(body)))
+			response.Header.Set("WARC-Profile", "http://netpreserve.org/warc/1.1/revisit/identical-payload-digest")
+			response.Header.Set("WARC-Refers-To", id)
+		} else {
+			block := append(httpResponseHeader(docMetadata, len(body)), body...)
+			response = NewWarcRecord("response", targetURI, "application/http; msgtype=response", block)
+			firstCopy = response.ID()
+		}
+		response.Header.Set("WARC-Payload-Digest", warcDigest(body))
+		request.Header.Set("WARC-Concurrent-To", response.ID())
+		records = append(records, response, request)
+		concurrentTo = response.ID()
+	}
+
+	metadataBytes, err := json.Marshal(docMetadata)
+	if err != nil {
+		return err
+	}
+	metadata := NewWarcRecord("metadata", targetURI, "application/json", metadataBytes)
+	if concurrentTo != "" {
+		metadata.Header.Set("WARC-Concurrent-To", concurrentTo)
+	}
+	records = append(records, metadata)
+
+	// the body is kept until its records are written, so a failed save can be repeated
+	err = s.write(records...)
+	if err != nil {
+		return err
+	}
+	if withBody {
+		delete(s.bodies, docMetadata.Hash)
+	}
+	if firstCopy != "" {
+		s.written[docMetadata.Hash] = firstCopy
+	}
+	s.docs[docMetadata.Hash] = docMetadata
+	return nil
+}
+
+// FlushMetadata syncs the current WARC file, records are written as they come
+func (s *WarcStorage) FlushMetadata() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Sync()
+}
+
+// UpdateInLinks writes a metadata record for every updated document
+func (s *WarcStorage) UpdateInLinks(counts map[string]int) error {
+	for hash, inLinks := range counts {
+		s.mu.Lock()
+		doc, ok := s.docs[hash]
+		s.mu.Unlock()
+		if !ok {
+			continue
+		}
+		doc.InLinks = inLinks
+		err := s.SaveMetadata(doc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WarcStorage) ListMetadata() ([]DocMetadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs := make([]DocMetadata, 0, len(s.docs))
+	for _, doc := range s.docs {
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// DeleteMetadata writes a metadata record that removes the document, WARC files are append only
+func (s *WarcStorage) DeleteMetadata(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.docs[hash]
+	if !ok {
+		return nil
+	}
+	metadataBytes, err := json.Marshal(DocMetadata{URL: doc.URL, Hash: hash})
+	if err != nil {
+		return err
+	}
+	record := NewWarcRecord("metadata", doc.URL, "application/json", metadataBytes)
+	record.Header.Set(WARC_DELETED_HEADER, "true")
+	err = s.write(record)
+	if err != nil {
+		return err
+	}
+	delete(s.docs, hash)
+	return nil
+}
+
+func (s *WarcStorage) SaveChanges(changes []DocChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	docs := make([]any, 0, len(changes))
+	for _, change := range changes {
+		docs = append(docs, change)
+	}
+	return writeJSONL(filepath.Join(s.dir, "changes.jsonl"), docs, true)
+}
+
+// SaveFetchLogs writes the fetch log next to the WARC files, failed fetches have no record to attach it to
+func (s *WarcStorage) SaveFetchLogs(logs []FetchLog) error {
+	return appendFetchLogs(filepath.Join(s.dir, "fetch_log.jsonl"), logs)
+}
+
+func (s *WarcStorage) ListFetchLogs(crawlID string) ([]FetchLog, error) {
+	return readFetchLogs(filepath.Join(s.dir, "fetch_log.jsonl"), crawlID)
+}
+
+// SaveImages writes the images next to the WARC files, in the format of the filesystem storage
+func (s *WarcStorage) SaveImages(pageHash string, images []Image) error {
+	return appendImages(filepath.Join(s.dir, "images.jsonl"), pageHash, images)
+}
+
+func (s *WarcStorage) DeleteImages(pageHash string) error {
+	return appendImages(filepath.Join(s.dir, "images.jsonl"), pageHash, []Image{})
+}
+
+// Close closes the current WARC file
+func (s *WarcStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// write appends the records to the current file, each as its own gzip member
+func (s *WarcStorage) write(records ...*WarcRecord) error {
+	if s.file == nil || s.size >= WARC_MAX_SIZE {
+		err := s.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		if record.Header.Get("WARC-Type") != "warcinfo" {
+			record.Header.Set("WARC-Warcinfo-ID", s.infoID)
+		}
+		n, err := writeGzipRecord(s.file, record)
+		s.size += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotate closes the current file and starts a new one with a warcinfo record
+func (s *WarcStorage) rotate() error {
+	if s.file != nil {
+		err := s.file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	// never append to an existing file, it already has its own warcinfo record
+	var name string
+	var file *os.File
+	var err error
+	for {
+		name = fmt.Sprintf("%s-%05d.warc.gz", s.prefix, s.seq)
+		s.seq++
+		file, err = os.OpenFile(filepath.Join(s.warcDir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.size = 0
+
+	fields := fmt.Sprintf("software: google_clone crawler\r\nformat: WARC File Format 1.1\r\nhttp-header-user-agent: %s\r\n", s.userAgent)
+	info := NewWarcRecord("warcinfo", "", "application/warc-fields", []byte(fields))
+	info.Header.Set("WARC-Filename", name)
+	s.infoID = info.ID()
+	n, err := writeGzipRecord(s.file, info)
+	s.size += n
+	return err
+}
+
+// load reads the metadata records of a WARC file written by a previous run
+func (s *WarcStorage) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	// ids of the response records, to find the hash of their page in the metadata records
+	responses := make(map[string]bool)
+	reader := bufio.NewReader(gz)
+	for {
+		record, err := ReadWarcRecord(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch record.Header.Get("WARC-Type") {
+		case "response":
+			responses[record.ID()] = true
+		case "metadata":
+			if !strings.HasPrefix(record.Header.Get("Content-Type"), "application/json") {
+				continue
+			}
+			var doc DocMetadata
+			err = json.Unmarshal(record.Block, &doc)
+			if err != nil {
+				return err
+			}
+			if record.Header.Get(WARC_DELETED_HEADER) != "" {
+				delete(s.docs, doc.Hash)
+				continue
+			}
+			s.docs[doc.Hash] = doc
+			if id := record.Header.Get("WARC-Concurrent-To"); responses[id] {
+				s.written[doc.Hash] = id
+			}
+		}
+	}
+}
+
+// httpRequest reconstructs the GET request of the page
+func (s *WarcStorage) httpRequest(targetURI string) []byte {
+	u, err := url.Parse(targetURI)
+	if err != nil {
+		return nil
+	}
+	return []byte(fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: %s\r\nAccept: text/html\r\n\r\n", u.RequestURI(), u.Host, s.userAgent))
+}
+
+// httpResponseHeader reconstructs the status line and headers of the response
+func httpResponseHeader(doc DocMetadata, length int) []byte {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 200 OK\r\n")
+	b.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	fmt.Fprintf(&b, "Content-Length: %d\r\n", length)
+	if doc.ETag != "" {
+		fmt.Fprintf(&b, "ETag: %s\r\n", doc.ETag)
+	}
+	if doc.LastModified != "" {
+		fmt.Fprintf(&b, "Last-Modified: %s\r\n", doc.LastModified)
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// writeGzipRecord writes the record as a gzip member and returns the compressed size
+func writeGzipRecord(w io.Writer, record *WarcRecord) (int64, error) {
+	counter := &countingWriter{w: w}
+	gz := gzip.NewWriter(counter)
+	_, err := record.WriteTo(gz)
+	if err != nil {
+		return counter.n, err
+	}
+	err = gz.Close()
+	return counter.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
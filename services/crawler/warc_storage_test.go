@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dxmv/google_clone/shared"
+)
+
+// warcPage is a page saved to the WARC storage
+type warcPage struct {
+	url  string
+	body []byte
+}
+
+func (p warcPage) doc() DocMetadata {
+	hash := sha256.Sum256(p.body)
+	return DocMetadata{
+		URL:           p.url,
+		Title:         filepath.Base(p.url),
+		Hash:          hex.EncodeToString(hash[:]),
+		ContentLength: len(p.body),
+		ETag:          `"` + filepath.Base(p.url) + `"`,
+		Images:        []string{},
+	}
+}
+
+// savePages writes the pages with a storage opened on dir and closes it
+func savePages(t *testing.T, dir string, pages ...warcPage) {
+	s, err := NewWarcStorage(dir, "test", "test-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range pages {
+		doc := page.doc()
+		err = s.SaveHTML(doc.Hash, page.body)
+		if err == nil {
+			err = s.SaveMetadata(doc)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// warcMember is a record and the offset of its gzip member in the file
+type warcMember struct {
+	record *WarcRecord
+	path   string
+	offset int64
+}
+
+// readWarcMembers reads the WARC files of dir member by member, every member must hold one record
+func readWarcMembers(t *testing.T, dir string) []warcMember {
+	paths, err := filepath.Glob(filepath.Join(dir, "warc", "*.warc.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := []warcMember{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := bytes.NewReader(data)
+		for reader.Len() > 0 {
+			offset := int64(len(data) - reader.Len())
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				t.Fatalf("%s at %d: %v", path, offset, err)
+			}
+			gz.Multistream(false)
+			records := bufio.NewReader(gz)
+			record, err := ReadWarcRecord(records)
+			if err != nil {
+				t.Fatalf("%s at %d: %v", path, offset, err)
+			}
+			if _, err := ReadWarcRecord(records); !errors.Is(err, io.EOF) {
+				t.Fatalf("%s at %d: more than one record in a gzip member", path, offset)
+			}
+			members = append(members, warcMember{record: record, path: path, offset: offset})
+		}
+	}
+	return members
+}
+
+func TestWarcStorageRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	first := warcPage{"https://en.wikipedia.org/wiki/First", []byte("<html><body>first</body></html>")}
+	second := warcPage{"https://en.wikipedia.org/wiki/Second", []byte("<html><body>second</body></html>")}
+	// same content as the first page, written as a revisit
+	copied := warcPage{"https://en.wikipedia.org/wiki/Copy", first.body}
+	// written by a second run, to a new file
+	third := warcPage{"https://en.wikipedia.org/wiki/Third", []byte("<html><body>third</body></html>")}
+	savePages(t, dir, first, second, copied)
+	savePages(t, dir, third)
+
+	members := readWarcMembers(t, dir)
+	byID := make(map[string]warcMember)
+	for _, member := range members {
+		byID[member.record.ID()] = member
+	}
+	infoID := ""
+	responses := make(map[string]string)
+	for _, member := range members {
+		record := member.record
+		header := record.Header
+		if header.Get("WARC-Block-Digest") != warcDigest(record.Block) {
+			t.Errorf("%s record of %s: wrong block digest", header.Get("WARC-Type"), header.Get("WARC-Target-URI"))
+		}
+		switch header.Get("WARC-Type") {
+		case "warcinfo":
+			if member.offset != 0 || header.Get("WARC-Filename") != filepath.Base(member.path) {
+				t.Errorf("warcinfo record at %d of %s, named %q", member.offset, member.path, header.Get("WARC-Filename"))
+			}
+			infoID = record.ID()
+			continue
+		case "response":
+			response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(record.Block)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Get("WARC-Payload-Digest") != warcDigest(payload) {
+				t.Errorf("response of %s: wrong payload digest", header.Get("WARC-Target-URI"))
+			}
+			if response.Header.Get("ETag") == "" {
+				t.Errorf("response of %s has no ETag", header.Get("WARC-Target-URI"))
+			}
+			responses[header.Get("WARC-Target-URI")] = record.ID()
+		case "revisit":
+			refers, ok := byID[header.Get("WARC-Refers-To")]
+			if !ok || refers.record.Header.Get("WARC-Target-URI") != first.url {
+				t.Errorf("revisit of %s refers to %q, want the response of %s", header.Get("WARC-Target-URI"), header.Get("WARC-Refers-To"), first.url)
+			}
+			if header.Get("WARC-Payload-Digest") != warcDigest(first.body) {
+				t.Errorf("revisit of %s: wrong payload digest", header.Get("WARC-Target-URI"))
+			}
+		case "request", "metadata":
+			concurrent, ok := byID[header.Get("WARC-Concurrent-To")]
+			if !ok || concurrent.record.Header.Get("WARC-Target-URI") != header.Get("WARC-Target-URI") {
+				t.Errorf("%s record of %s isn't concurrent to its response", header.Get("WARC-Type"), header.Get("WARC-Target-URI"))
+			}
+		}
+		if header.Get("WARC-Warcinfo-ID") != infoID {
+			t.Errorf("%s record of %s refers to warcinfo %q, want %q", header.Get("WARC-Type"), header.Get("WARC-Target-URI"), header.Get("WARC-Warcinfo-ID"), infoID)
+		}
+	}
+	if len(responses) != 3 {
+		t.Errorf("%d response records, want 3", len(responses))
+	}
+
+	// the shared corpus finds the response records by their offsets
+	corpus := shared.NewWarcCorpus(dir)
+	ctx := context.Background()
+	for _, page := range []warcPage{first, second, third} {
+		doc := page.doc()
+		html, err := corpus.GetHTML(ctx, doc.Hash)
+		if err != nil || !bytes.Equal(html, page.body) {
+			t.Errorf("html of %s read back as %q (%v)", page.url, html, err)
+		}
+	}
+	// the copy has the same hash, its metadata record is the latest one
+	for _, page := range []warcPage{copied, second, third} {
+		want := page.doc()
+		got, err := corpus.GetMetadata(ctx, want.Hash)
+		if err != nil {
+			t.Errorf("metadata of %s: %v", page.url, err)
+			continue
+		}
+		if got.URL != want.URL || got.Title != want.Title || got.ContentLength != want.ContentLength {
+			t.Errorf("metadata of %s read back as %+v", page.url, got)
+		}
+	}
+	docs, err := corpus.ListMetadata(ctx)
+	if err != nil || len(docs) != 3 {
+		t.Errorf("corpus lists %d documents (%v), want 3", len(docs), err)
+	}
+}
+
+func TestWarcStorageDelete(t *testing.T) {
+	dir := t.TempDir()
+	kept := warcPage{"https://en.wikipedia.org/wiki/Kept", []byte("<html><body>kept</body></html>")}
+	deleted := warcPage{"https://en.wikipedia.org/wiki/Deleted", []byte("<html><body>deleted</body></html>")}
+	savePages(t, dir, kept, deleted)
+
+	s, err := NewWarcStorage(dir, "test", "test-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, _ := s.ListMetadata()
+	if len(docs) != 2 {
+		t.Fatalf("storage read back %d documents, want 2", len(docs))
+	}
+	err = s.DeleteMetadata(deleted.doc().Hash)
+	if err == nil {
+		err = s.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corpus := shared.NewWarcCorpus(dir)
+	ctx := context.Background()
+	_, err = corpus.GetMetadata(ctx, deleted.doc().Hash)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("deleted document is still in the corpus (%v)", err)
+	}
+	_, err = corpus.GetMetadata(ctx, kept.doc().Hash)
+	if err != nil {
+		t.Errorf("kept document: %v", err)
+	}
+	// a storage opened later doesn't bring the document back
+	s, err = NewWarcStorage(dir, "test", "test-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	docs, _ = s.ListMetadata()
+	if len(docs) != 1 || docs[0].Hash != kept.doc().Hash {
+		t.Errorf("storage read back %v, want the kept document", docs)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// base url of the images on Wikimedia Commons
+const COMMONS_UPLOAD_URL = "https://upload.wikimedia.org/wikipedia/commons/"
+
+// maximum number of image urls kept in the metadata of a page
+const MAX_IMAGES = 5
+
+// options of a [[File:...]] link that aren't its caption
+var wikiFileOptions = map[string]bool{
+	"thumb": true, "thumbnail": true, "frame": true, "framed": true, "frameless": true, "border": true,
+	"left": true, "right": true, "center": true, "centre": true, "none": true, "upright": true,
+	"baseline": true, "middle": true, "sub": true, "super": true, "top": true, "text-top": true, "bottom": true, "text-bottom": true,
+}
+
+var (
+	wikiComment      = regexp.MustCompile(`(?s)<!--.*?-->`)
+	wikiRef          = regexp.MustCompile(`(?is)<ref[^>/]*>.*?</ref\s*>|<ref[^>]*/>`)
+	wikiBlockTags    = regexp.MustCompile(`(?is)<(?:math|gallery|score|syntaxhighlight|source|timeline|imagemap|chem|graph)[^>]*>.*?</(?:math|gallery|score|syntaxhighlight|source|timeline|imagemap|chem|graph)\s*>`)
+	wikiTag          = regexp.MustCompile(`<[^>]+>`)
+	wikiExternalLink = regexp.MustCompile(`\[(?:https?:)?//[^\s\]]+\s*([^\]]*)\]`)
+	wikiEmphasis     = regexp.MustCompile(`'{2,5}`)
+	wikiMagicWord    = regexp.MustCompile(`__[A-Z]+__`)
+	wikiSpaces       = regexp.MustCompile(`\s+`)
+	wikiImageKey     = regexp.MustCompile(`(?i)^(?:image|photo|logo|caption|alt|upright)(?:[_ ]\w+)*\d*$`)
+	wikiEmptyParens  = regexp.MustCompile(`\(\s*\)`)
+	wikiImageWidth   = regexp.MustCompile(`^\d*(?:x\d+)?px$`)
+	wikiImageParam   = regexp.MustCompile(`(?i)\|\s*(?:image|photo|logo)\d*\s*=\s*([^|\n}]+?\.(?:jpe?g|png|gif|svg|webp|tiff?))\s*(?:\||\n|}})`)
+)
+
+// names of the templates that mark a disambiguation page, lowercase
+var disambiguationTemplates = map[string]bool{
+	"disambiguation": true, "disambig": true, "dab": true, "disamb": true,
+	"hndis": true, "geodis": true, "set index article": true, "sia": true,
+}
+
+// WikiBlock is a heading or a paragraph of an article, Level is 2 to 6 for headings
+type WikiBlock struct {
+	Heading bool
+	Level   int
+	Text    string
+}
+
+// WikiImage is a file shown in an article, with the alt text and caption of its link
+type WikiImage struct {
+	URL     string
+	Alt     string
+	Caption string
+}
+
+// WikiArticle is the plain text of an article, the images it shows and the structure
+// read from its templates and category links
+type WikiArticle struct {
+	Blocks           []WikiBlock
+	Images           []WikiImage
+	ShortDescription string
+	Infobox          []InfoboxField
+	Categories       []string
+	Disambiguation   bool
+}
+
+// ParseWikitext turns the wikitext of an article into plain text blocks.
+// Templates, tables, references and files are dropped, links keep their label.
+func ParseWikitext(text string) WikiArticle {
+	article := WikiArticle{Images: []WikiImage{}, Infobox: []InfoboxField{}, Categories: []string{}}
+
+	text = wikiComment.ReplaceAllString(text, "")
+	// images are found before templates are dropped, infoboxes are templates
+	for _, match := range wikiImageParam.FindAllStringSubmatch(text, -1) {
+		article.addImage(match[1], "")
+	}
+	article.readTemplates(text)
+	text = wikiRef.ReplaceAllString(text, "")
+	text = wikiBlockTags.ReplaceAllString(text, "")
+	text = removeNested(text, "{{", "}}")
+	text = removeNested(text, "{|", "|}")
+	text = replaceLinks(text, &article)
+	text = wikiExternalLink.ReplaceAllString(text, "$1")
+	text = wikiTag.ReplaceAllString(text, "")
+	text = wikiEmphasis.ReplaceAllString(text, "")
+	text = wikiMagicWord.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	// blank lines separate paragraphs, headings and list items stand alone
+	paragraph := []string{}
+	endParagraph := func() {
+		if len(paragraph) > 0 {
+			article.addBlock(false, strings.Join(paragraph, " "))
+			paragraph = paragraph[:0]
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			endParagraph()
+		case strings.HasPrefix(line, "=") && strings.HasSuffix(line, "="):
+			endParagraph()
+			level := min(len(line)-len(strings.TrimLeft(line, "=")), len(line)-len(strings.TrimRight(line, "=")))
+			article.addHeading(min(max(level, 2), 6), strings.Trim(line, "= "))
+		case strings.HasPrefix(line, "|") || strings.HasPrefix(line, "!"):
+			// leftovers of tables and templates that weren't closed
+			continue
+		case strings.IndexAny(line[:1], "*#:;") == 0:
+			endParagraph()
+			article.addBlock(false, strings.TrimLeft(line, "*#:; "))
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	endParagraph()
+	return article
+}
+
+// FirstParagraph returns the text of the first paragraph
+func (a *WikiArticle) FirstParagraph() string {
+	for _, block := range a.Blocks {
+		if !block.Heading {
+			return block.Text
+		}
+	}
+	return ""
+}
+
+// Text returns the text of all blocks
+func (a *WikiArticle) Text() string {
+	texts := make([]string, 0, len(a.Blocks))
+	for _, block := range a.Blocks {
+		texts = append(texts, block.Text)
+	}
+	return strings.Join(texts, "\n")
+}
+
+func (a *WikiArticle) addBlock(heading bool, text string) {
+	text = strings.TrimSpace(wikiSpaces.ReplaceAllString(text, " "))
+	if text != "" {
+		a.Blocks = append(a.Blocks, WikiBlock{Heading: heading, Text: text})
+	}
+}
+
+func (a *WikiArticle) addHeading(level int, text string) {
+	text = strings.TrimSpace(wikiSpaces.ReplaceAllString(text, " "))
+	if text != "" {
+		a.Blocks = append(a.Blocks, WikiBlock{Heading: true, Level: level, Text: text})
+	}
+}
+
+// readTemplates reads the short description, the first infobox and disambiguation
+// markers from the templates at the top level of the text
+func (a *WikiArticle) readTemplates(text string) {
+	for {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			return
+		}
+		end := matchingClose(text, start, "{{", "}}")
+		if end < 0 {
+			return
+		}
+		params := splitTemplate(text[start+2 : end])
+		text = text[end+2:]
+
+		name := strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(params[0], "_", " ")), " "))
+		switch {
+		case name == "short description" && len(params) > 1:
+			a.ShortDescription = strings.TrimSpace(params[1])
+		case strings.HasPrefix(name, "infobox") && len(a.Infobox) == 0:
+			for _, param := range params[1:] {
+				key, value, ok := strings.Cut(param, "=")
+				key = strings.TrimSpace(key)
+				if !ok || key == "" || wikiImageKey.MatchString(key) {
+					continue
+				}
+				if value = wikiPlainText(value); value != "" {
+					a.Infobox = append(a.Infobox, InfoboxField{Key: key, Value: truncateUTF8(value, MAX_INFOBOX_VALUE)})
+				}
+			}
+		case disambiguationTemplates[name] || strings.HasSuffix(name, " disambiguation"):
+			a.Disambiguation = true
+		}
+	}
+}
+
+// splitTemplate splits the inside of a template at the pipes that aren't in nested templates or links
+func splitTemplate(inner string) []string {
+	params := []string{}
+	depth := 0
+	last := 0
+	for i := 0; i < len(inner); i++ {
+		switch {
+		case strings.HasPrefix(inner[i:], "{{") || strings.HasPrefix(inner[i:], "[["):
+			depth++
+			i++
+		case strings.HasPrefix(inner[i:], "}}") || strings.HasPrefix(inner[i:], "]]"):
+			depth--
+			i++
+		case inner[i] == '|' && depth == 0:
+			params = append(params, inner[last:i])
+			last = i + 1
+		}
+	}
+	return append(params, inner[last:])
+}
+
+// wikiPlainText turns a short piece of wikitext, like an infobox value, into plain text
+func wikiPlainText(text string) string {
+	text = wikiRef.ReplaceAllString(text, "")
+	text = removeNested(text, "{{", "}}")
+	// links in values aren't part of the article, they don't add images or categories
+	text = replaceLinks(text, &WikiArticle{})
+	text = wikiExternalLink.ReplaceAllString(text, "$1")
+	text = wikiTag.ReplaceAllString(text, " ")
+	text = wikiEmphasis.ReplaceAllString(text, "")
+	text = wikiEmptyParens.ReplaceAllString(text, "")
+	return strings.TrimSpace(wikiSpaces.ReplaceAllString(html.UnescapeString(text), " "))
+}
+
+// addImage adds the file with the options of its link, the caption is the last option that isn't a keyword
+func (a *WikiArticle) addImage(name string, options string) {
+	imageURL := commonsImageURL(name)
+	if imageURL == "" {
+		return
+	}
+	for _, image := range a.Images {
+		if image.URL == imageURL {
+			return
+		}
+	}
+	image := WikiImage{URL: imageURL}
+	if options != "" {
+		for _, option := range splitTemplate(options) {
+			option = strings.TrimSpace(option)
+			key, value, ok := strings.Cut(option, "=")
+			switch {
+			case ok && strings.TrimSpace(key) == "alt":
+				image.Alt = truncateUTF8(wikiPlainText(value), MAX_IMAGE_TEXT)
+			case ok && !strings.Contains(key, "[") && !strings.Contains(key, "{"):
+				// link=, page=, class= and the like
+				continue
+			case wikiFileOptions[strings.ToLower(option)] || wikiImageWidth.MatchString(option):
+				continue
+			default:
+				image.Caption = truncateUTF8(wikiPlainText(option), MAX_IMAGE_TEXT)
+			}
+		}
+	}
+	a.Images = append(a.Images, image)
+}
+
+// commonsImageURL returns the upload url of a file on Wikimedia Commons,
+// the directories are the first characters of the md5 of the file name
+func commonsImageURL(name string) string {
+	name = strings.TrimSpace(name)
+	if prefix, rest, ok := strings.Cut(name, ":"); ok && isFileNamespace(prefix) {
+		name = rest
+	}
+	name = strings.ReplaceAll(strings.TrimSpace(name), " ", "_")
+	if name == "" {
+		return ""
+	}
+	// the first letter of a file name is always upper case
+	name = strings.ToUpper(name[:1]) + name[1:]
+	sum := md5.Sum([]byte(name))
+	hash := hex.EncodeToString(sum[:])
+	return COMMONS_UPLOAD_URL + hash[:1] + "/" + hash[:2] + "/" + escapeTitle(name)
+}
+
+// replaceLinks replaces [[target|label]] with the label, files and categories are dropped
+func replaceLinks(text string, article *WikiArticle) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, "[[")
+		if start < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		end := matchingClose(text, start, "[[", "]]")
+		if end < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(text[:start])
+		inner := text[start+2 : end]
+		text = text[end+2:]
+
+		target, label, hasLabel := strings.Cut(inner, "|")
+		if prefix, rest, ok := strings.Cut(target, ":"); ok && !strings.HasPrefix(target, ":") {
+			if isFileNamespace(prefix) {
+				article.addImage(rest, label)
+				continue
+			}
+			if strings.EqualFold(strings.TrimSpace(prefix), "category") {
+				if category := strings.TrimSpace(rest); category != "" {
+					article.Categories = append(article.Categories, strings.ReplaceAll(category, "_", " "))
+				}
+				continue
+			}
+			// links to other languages
+			if len(strings.TrimSpace(prefix)) <= 3 {
+				continue
+			}
+		}
+		if hasLabel {
+			// the label may hold links itself
+			b.WriteString(replaceLinks(label, article))
+		} else {
+			b.WriteString(strings.TrimPrefix(target, ":"))
+		}
+	}
+}
+
+// removeNested removes everything between open and close, including nested pairs
+func removeNested(text string, open string, close string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, open)
+		if start < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+		b.WriteString(text[:start])
+		end := matchingClose(text, start, open, close)
+		if end < 0 {
+			// unclosed, drop the rest
+			return b.String()
+		}
+		text = text[end+len(close):]
+	}
+}
+
+// matchingClose returns the index of the close matching the open at start, -1 if there is none
+func matchingClose(text string, start int, open string, close string) int {
+	depth := 0
+	for i := start; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], open):
+			depth++
+			i += len(open)
+		case strings.HasPrefix(text[i:], close):
+			depth--
+			if depth == 0 {
+				return i
+			}
+			i += len(close)
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+func isFileNamespace(prefix string) bool {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	return prefix == "file" || prefix == "image"
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseWikitextText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []WikiBlock
+	}{
+		{"templates are dropped",
+			"{{Use dmy dates|date=May 2020}}'''Physics''' is {{lang|la|a}} science.{{cite web|url=x}}",
+			[]WikiBlock{{Text: "Physics is science."}}},
+		{"nested templates are dropped",
+			"Before{{outer|{{inner|x}}|y}} after.",
+			[]WikiBlock{{Text: "Before after."}}},
+		{"references are dropped",
+			`Matter<ref name="a">{{cite book|title=Mechanics}}</ref> moves<ref name="a" />.`,
+			[]WikiBlock{{Text: "Matter moves."}}},
+		{"links keep their label",
+			"[[Energy]] and [[Force (physics)|force]] and [[:Category:Physics]].",
+			[]WikiBlock{{Text: "Energy and force and Category:Physics."}}},
+		{"links nested in a label",
+			"[[Motion|the [[motion]] of bodies]]",
+			[]WikiBlock{{Text: "the motion of bodies"}}},
+		{"external links keep their label",
+			"See [https://example.com the site] and [https://example.com].",
+			[]WikiBlock{{Text: "See the site and ."}}},
+		{"files, categories and other languages are dropped",
+			"[[File:Apple.jpg|thumb|An apple]]Text.[[Category:Physics]][[de:Physik]]",
+			[]WikiBlock{{Text: "Text."}}},
+		{"tables, comments and math are dropped",
+			"A<!-- hidden --> b.\n{| class=\"wikitable\"\n|-\n| cell\n|}\n<math>E=mc^2</math>C.",
+			[]WikiBlock{{Text: "A b."}, {Text: "C."}}},
+		{"headings and paragraphs",
+			"Intro line one\nline two.\n\n== History ==\nOld.\n=== Early ===\n* first item\n# second item",
+			[]WikiBlock{
+				{Text: "Intro line one line two."},
+				{Heading: true, Level: 2, Text: "History"},
+				{Text: "Old."},
+				{Heading: true, Level: 3, Text: "Early"},
+				{Text: "first item"},
+				{Text: "second item"},
+			}},
+		{"entities and emphasis", "''Caf&eacute;'' &amp; '''bar'''__NOTOC__", []WikiBlock{{Text: "Café & bar"}}},
+	}
+	for _, test := range tests {
+		article := ParseWikitext(test.text)
+		if !slices.Equal(article.Blocks, test.want) {
+			t.Errorf("%s: blocks %+v, want %+v", test.name, article.Blocks, test.want)
+		}
+	}
+}
+
+func TestParseWikitextStructure(t *testing.T) {
+	text := `{{Short description|Study of matter and energy}}
+{{Infobox scientist
+| name = Isaac Newton
+| image = Portrait of Newton.jpg
+| caption = Newton in 1689
+| birth_date = {{birth date|1643|1|4}}
+| field = [[Physics]], [[Mathematics|maths]]<ref>cite</ref>
+| empty =
+}}
+{{Infobox other|name=Second}}
+'''Newton''' was a physicist.
+[[File:Newton's cradle.gif|thumb|upright=1.2|alt=Five balls|A [[Newton's cradle]]]]
+[[Image:apple tree.jpg]]
+[[File:Newton's cradle.gif|thumb|Again]]
+[[Category:Physicists]]
+[[Category:English_people| Newton]]`
+	article := ParseWikitext(text)
+
+	if article.ShortDescription != "Study of matter and energy" {
+		t.Errorf("short description %q", article.ShortDescription)
+	}
+	wantInfobox := []InfoboxField{{Key: "name", Value: "Isaac Newton"}, {Key: "field", Value: "Physics, maths"}}
+	if !slices.Equal(article.Infobox, wantInfobox) {
+		t.Errorf("infobox %+v, want %+v", article.Infobox, wantInfobox)
+	}
+	wantCategories := []string{"Physicists", "English people"}
+	if !slices.Equal(article.Categories, wantCategories) {
+		t.Errorf("categories %v, want %v", article.Categories, wantCategories)
+	}
+	if article.Disambiguation {
+		t.Error("article is a disambiguation page")
+	}
+	if article.FirstParagraph() != "Newton was a physicist." {
+		t.Errorf("first paragraph %q", article.FirstParagraph())
+	}
+
+	// the infobox image comes first, a file linked twice is kept once
+	wantImages := []WikiImage{
+		{URL: commonsImageURL("Portrait of Newton.jpg")},
+		{URL: commonsImageURL("Newton's cradle.gif"), Alt: "Five balls", Caption: "A Newton's cradle"},
+		{URL: commonsImageURL("Apple tree.jpg")},
+	}
+	if !slices.Equal(article.Images, wantImages) {
+		t.Errorf("images %+v, want %+v", article.Images, wantImages)
+	}
+	for _, image := range article.Images {
+		if strings.Contains(image.URL, " ") || !strings.HasPrefix(image.URL, COMMONS_UPLOAD_URL) {
+			t.Errorf("image url %s", image.URL)
+		}
+	}
+}
+
+func TestParseWikitextDisambiguation(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"'''Mercury''' may refer to:\n* [[Mercury (planet)]]\n{{disambiguation}}", true},
+		{"{{Disambig}}", true},
+		{"{{Set index article}}", true},
+		{"{{Place name disambiguation}}", true},
+		{"{{About|the planet|the element|Mercury (element)}}", false},
+	}
+	for _, test := range tests {
+		if got := ParseWikitext(test.text).Disambiguation; got != test.want {
+			t.Errorf("%q: disambiguation %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestCommonsImageURL(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		// the path of the example on https://www.mediawiki.org/wiki/Manual:$wgHashedUploadDirectory
+		{"Example.jpg", COMMONS_UPLOAD_URL + "a/a9/Example.jpg"},
+		{"File:example.jpg", COMMONS_UPLOAD_URL + "a/a9/Example.jpg"},
+		{" Image: Example.jpg ", COMMONS_UPLOAD_URL + "a/a9/Example.jpg"},
+		{"File:", ""},
+	}
+	for _, test := range tests {
+		if got := commonsImageURL(test.name); got != test.want {
+			t.Errorf("commonsImageURL(%q) = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
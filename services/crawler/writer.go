@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// delay before the first retry of a failed write, it doubles with every attempt
+const STORAGE_RETRY_BACKOFF = time.Second
+
+// htmlWrite is a page waiting for an uploader
+type htmlWrite struct {
+	hash string
+	body []byte
+}
+
+/*
+storageWrite is a write other than a page, run in order by the writer.
+doc: metadata, written through the batching of the storage and flushed with the batch
+run: any other write
+needsPage: the write is dropped when the upload of its page fails
+*/
+type storageWrite struct {
+	what      string
+	doc       *DocMetadata
+	run       func() error
+	needsPage bool
+}
+
+/*
+StorageWriter saves everything in the background, so workers don't wait for the storage.
+Pages go through a bounded queue to a pool of uploaders, SaveHTML only blocks while the queue is full.
+The other writes (metadata, in-link counts, images, deletions, fetch logs and links) run in order on one writer in
+batches, when a batch is full or every MetadataFlushInterval. The writes of a page wait until its
+html is saved, so readers never find metadata of a missing page. Failed writes are retried, only
+reads go straight to the wrapped storage.
+
+html: pages waiting for an uploader
+batches: full batches waiting for the writer
+pending: writes saved and not done yet, FlushMetadata waits until it is 0
+uploading: uploads in progress per hash, waiting holds the writes of those pages
+batch: writes of the next batch
+err: the last write that failed after its retries, returned by the next FlushMetadata
+*/
+type StorageWriter struct {
+	Storage
+	html      chan htmlWrite
+	batches   chan []storageWrite
+	workers   sync.WaitGroup
+	stop      chan struct{}
+	stopped   chan struct{}
+	mu        sync.Mutex
+	changed   *sync.Cond
+	pending   int
+	uploading map[string]int
+	waiting   map[string][]storageWrite
+	batch     []storageWrite
+	batchSize int
+	retries   int
+	err       error
+}
+
+// NewStorageWriter starts the uploaders and the writer of the storage
+func NewStorageWriter(storage Storage, config *Config) *StorageWriter {
+	w := &StorageWriter{
+		Storage:   storage,
+		html:      make(chan htmlWrite, config.StorageQueue),
+		batches:   make(chan []storageWrite, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		uploading: make(map[string]int),
+		waiting:   make(map[string][]storageWrite),
+		batch:     make([]storageWrite, 0, config.MetadataBatch),
+		batchSize: config.MetadataBatch,
+		retries:   config.StorageRetries,
+	}
+	w.changed = sync.NewCond(&w.mu)
+	for i := 0; i < config.StorageWorkers; i++ {
+		w.workers.Add(1)
+		go w.uploader()
+	}
+	w.workers.Add(1)
+	go w.writer()
+	go w.flushLoop(config.MetadataFlushInterval)
+	return w
+}
+
+// SaveHTML queues the page for upload, errors are printed and returned by FlushMetadata
+func (w *StorageWriter) SaveHTML(hash string, body []byte) error {
+	w.mu.Lock()
+	w.pending++
+	w.uploading[hash]++
+	w.mu.Unlock()
+	w.html <- htmlWrite{hash: hash, body: body}
+	return nil
+}
+
+// SaveMetadata adds the metadata to the next batch, once the html of the page is saved
+func (w *StorageWriter) SaveMetadata(docMetadata DocMetadata) error {
+	w.queue(docMetadata.Hash, storageWrite{what: "saving metadata", doc: &docMetadata, needsPage: true})
+	return nil
+}
+
+func (w *StorageWriter) DeleteMetadata(hash string) error {
+	w.queue(hash, storageWrite{what: "deleting metadata", run: func() error {
+		return w.Storage.DeleteMetadata(hash)
+	}})
+	return nil
+}
+
+func (w *StorageWriter) UpdateInLinks(counts map[string]int) error {
+	w.queue("", storageWrite{what: "updating in-link counts", run: func() error {
+		return w.Storage.UpdateInLinks(counts)
+	}})
+	return nil
+}
+
+func (w *StorageWriter) SaveImages(pageHash string, images []Image) error {
+	w.queue(pageHash, storageWrite{what: "saving images", needsPage: true, run: func() error {
+		return w.Storage.SaveImages(pageHash, images)
+	}})
+	return nil
+}
+
+func (w *StorageWriter) DeleteImages(pageHash string) error {
+	w.queue(pageHash, storageWrite{what: "deleting images", run: func() error {
+		return w.Storage.DeleteImages(pageHash)
+	}})
+	return nil
+}
+
+func (w *StorageWriter) SaveFetchLogs(logs []FetchLog) error {
+	w.queue("", storageWrite{what: "saving fetch log", run: func() error {
+		return w.Storage.SaveFetchLogs(logs)
+	}})
+	return nil
+}
+
+// Links returns the link store writing its edges through the writer
+func (w *StorageWriter) Links(links LinkStore) LinkStore {
+	return &writerLinks{links: links, writer: w}
+}
+
+// FlushMetadata waits until everything saved so far is written
+func (w *StorageWriter) FlushMetadata() error {
+	w.mu.Lock()
+	for w.pending > 0 {
+		if batch := w.takeBatch(1); batch != nil {
+			w.mu.Unlock()
+			w.send(batch)
+			w.mu.Lock()
+			continue
+		}
+		w.changed.Wait()
+	}
+	err := w.err
+	w.err = nil
+	w.mu.Unlock()
+	return err
+}
+
+// Close writes everything saved so far and closes the wrapped storage, the writer can't be used after it
+func (w *StorageWriter) Close() error {
+	close(w.stop)
+	<-w.stopped
+	err := w.FlushMetadata()
+	close(w.html)
+	close(w.batches)
+	w.workers.Wait()
+	if closer, ok := w.Storage.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// queue adds the write to the next batch, or holds it while the page with the hash is uploaded
+func (w *StorageWriter) queue(hash string, write storageWrite) {
+	w.mu.Lock()
+	w.pending++
+	if hash != "" && w.uploading[hash] > 0 {
+		w.waiting[hash] = append(w.waiting[hash], write)
+		w.mu.Unlock()
+		return
+	}
+	w.batch = append(w.batch, write)
+	full := w.takeBatch(w.batchSize)
+	w.mu.Unlock()
+	w.send(full)
+}
+
+// uploader saves queued pages, then queues the writes that waited for them
+func (w *StorageWriter) uploader() {
+	defer w.workers.Done()
+	for page := range w.html {
+		err := w.retry("saving HTML", func() error {
+			return w.Storage.SaveHTML(page.hash, page.body)
+		})
+		w.mu.Lock()
+		w.uploading[page.hash]--
+		if w.uploading[page.hash] == 0 {
+			writes := w.waiting[page.hash]
+			delete(w.uploading, page.hash)
+			delete(w.waiting, page.hash)
+			for _, write := range writes {
+				// metadata of a page that wasn't saved would point at nothing
+				if err != nil && write.needsPage {
+					w.pending--
+					continue
+				}
+				w.batch = append(w.batch, write)
+			}
+		}
+		if err != nil {
+			w.err = err
+		}
+		w.pending--
+		full := w.takeBatch(w.batchSize)
+		w.changed.Broadcast()
+		w.mu.Unlock()
+		w.send(full)
+	}
+}
+
+// writer runs the batches one at a time, so the writes of a page keep their order
+func (w *StorageWriter) writer() {
+	defer w.workers.Done()
+	for batch := range w.batches {
+		var err error
+		metadata := false
+		for _, write := range batch {
+			run := write.run
+			if write.doc != nil {
+				doc := *write.doc
+				run = func() error { return w.Storage.SaveMetadata(doc) }
+				metadata = true
+			}
+			if writeErr := w.retry(write.what, run); writeErr != nil {
+				err = writeErr
+			}
+		}
+		if metadata {
+			if flushErr := w.retry("flushing metadata", w.Storage.FlushMetadata); flushErr != nil {
+				err = flushErr
+			}
+		}
+		w.mu.Lock()
+		w.pending -= len(batch)
+		if err != nil {
+			w.err = err
+		}
+		w.changed.Broadcast()
+		w.mu.Unlock()
+	}
+}
+
+// flushLoop writes the batch collected so far every interval, until stop is closed
+func (w *StorageWriter) flushLoop(interval time.Duration) {
+	defer close(w.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			batch := w.takeBatch(1)
+			w.mu.Unlock()
+			w.send(batch)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// takeBatch returns the next batch once it has at least min writes, nil before that
+func (w *StorageWriter) takeBatch(min int) []storageWrite {
+	if len(w.batch) == 0 || len(w.batch) < min {
+		return nil
+	}
+	batch := w.batch
+	w.batch = make([]storageWrite, 0, w.batchSize)
+	return batch
+}
+
+// send hands the batch to the writer, waiting while it is busy with the one before
+func (w *StorageWriter) send(batch []storageWrite) {
+	if batch != nil {
+		w.batches <- batch
+	}
+}
+
+// retry runs the write until it succeeds or StorageRetries retries failed
+func (w *StorageWriter) retry(what string, write func() error) error {
+	backoff := STORAGE_RETRY_BACKOFF
+	for attempt := 0; ; attempt++ {
+		err := write()
+		if err == nil {
+			return nil
+		}
+		if attempt >= w.retries {
+			fmt.Println("Error", what, err)
+			return err
+		}
+		fmt.Println("Retrying", what, "in", backoff, "after", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// writerLinks queues the edges and flushes of a link store on the writer
+type writerLinks struct {
+	links  LinkStore
+	writer *StorageWriter
+}
+
+func (l *writerLinks) SaveLinks(links []Link) error {
+	l.writer.queue("", storageWrite{what: "saving links", run: func() error {
+		return l.links.SaveLinks(links)
+	}})
+	return nil
+}
+
+// Flush writes the queued edges and waits for them
+func (l *writerLinks) Flush() error {
+	l.writer.queue("", storageWrite{what: "saving links", run: l.links.Flush})
+	return l.writer.FlushMetadata()
+}
+
+// Close waits for the queued edges and closes the link store, the writer must still be open
+func (l *writerLinks) Close() error {
+	err := l.Flush()
+	if closeErr := l.links.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
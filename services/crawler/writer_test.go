@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingStorage records the writes that reach it, pages in failPages can't be saved
+type recordingStorage struct {
+	mu        sync.Mutex
+	delay     time.Duration
+	failPages map[string]bool
+	writes    []string
+	flushed   int
+	closed    bool
+}
+
+func (s *recordingStorage) record(write string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes = append(s.writes, write)
+}
+
+func (s *recordingStorage) SaveHTML(hash string, body []byte) error {
+	time.Sleep(s.delay)
+	if s.failPages[hash] {
+		return errors.New("upload failed")
+	}
+	s.record("html " + hash)
+	return nil
+}
+
+func (s *recordingStorage) SaveMetadata(docMetadata DocMetadata) error {
+	s.record("metadata " + docMetadata.Hash)
+	return nil
+}
+
+func (s *recordingStorage) FlushMetadata() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushed++
+	return nil
+}
+
+func (s *recordingStorage) DeleteMetadata(hash string) error {
+	s.record("delete " + hash)
+	return nil
+}
+
+func (s *recordingStorage) SaveImages(pageHash string, images []Image) error {
+	s.record("images " + pageHash)
+	return nil
+}
+
+func (s *recordingStorage) DeleteImages(pageHash string) error {
+	s.record("delete images " + pageHash)
+	return nil
+}
+
+func (s *recordingStorage) UpdateInLinks(counts map[string]int) error {
+	s.record("inlinks")
+	return nil
+}
+
+func (s *recordingStorage) SaveFetchLogs(logs []FetchLog) error {
+	s.record("fetch log")
+	return nil
+}
+
+func (s *recordingStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingStorage) ListMetadata() ([]DocMetadata, error)             { return nil, nil }
+func (s *recordingStorage) SaveChanges(changes []DocChange) error            { return nil }
+func (s *recordingStorage) CreateMetadataDirectory(name string) error        { return nil }
+func (s *recordingStorage) CreateHTMLDirectory(name string) error            { return nil }
+func (s *recordingStorage) ListFetchLogs(crawlID string) ([]FetchLog, error) { return nil, nil }
+
+func TestStorageWriterClose(t *testing.T) {
+	tests := []struct {
+		name      string
+		pages     int
+		failPages []string
+		// writes that must not reach the storage
+		dropped []string
+		wantErr bool
+	}{
+		{"everything is written", 50, nil, nil, false},
+		{"writes of a failed page are dropped", 5, []string{"page2"}, []string{"metadata page2", "images page2"}, true},
+	}
+	for _, test := range tests {
+		storage := &recordingStorage{delay: time.Millisecond, failPages: make(map[string]bool)}
+		for _, hash := range test.failPages {
+			storage.failPages[hash] = true
+		}
+		config := NewConfig()
+		config.StorageQueue = 4
+		config.StorageWorkers = 3
+		config.MetadataBatch = 7
+		config.StorageRetries = 0
+		// Close has to flush the last batch itself
+		config.MetadataFlushInterval = time.Hour
+		w := NewStorageWriter(storage, config)
+
+		for i := range test.pages {
+			hash := fmt.Sprintf("page%d", i)
+			w.SaveHTML(hash, []byte("html"))
+			w.SaveMetadata(DocMetadata{Hash: hash})
+			w.SaveImages(hash, nil)
+		}
+		w.DeleteMetadata("old")
+		w.UpdateInLinks(map[string]int{"page0": 1})
+		err := w.Close()
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: Close() = %v, want error %v", test.name, err, test.wantErr)
+		}
+		if !storage.closed {
+			t.Errorf("%s: the storage wasn't closed", test.name)
+		}
+		if storage.flushed == 0 {
+			t.Errorf("%s: metadata wasn't flushed", test.name)
+		}
+
+		written := make(map[string]int)
+		for i, write := range storage.writes {
+			written[write] = i
+		}
+		for i := range test.pages {
+			hash := fmt.Sprintf("page%d", i)
+			if slices.Contains(test.failPages, hash) {
+				continue
+			}
+			for _, write := range []string{"html " + hash, "metadata " + hash, "images " + hash} {
+				if _, ok := written[write]; !ok {
+					t.Errorf("%s: %s wasn't written", test.name, write)
+				}
+			}
+			// the writes of a page wait for its html
+			if written["metadata "+hash] < written["html "+hash] || written["images "+hash] < written["html "+hash] {
+				t.Errorf("%s: writes of %s ran before its html was saved", test.name, hash)
+			}
+		}
+		for _, write := range test.dropped {
+			if _, ok := written[write]; ok {
+				t.Errorf("%s: %s was written", test.name, write)
+			}
+		}
+		// writes without a page keep their order
+		_, deleted := written["delete old"]
+		_, updated := written["inlinks"]
+		if !deleted || !updated || written["delete old"] > written["inlinks"] {
+			t.Errorf("%s: in-links were updated before the deletion", test.name)
+		}
+	}
+}
+
+func TestStorageWriterFlushReturnsError(t *testing.T) {
+	storage := &recordingStorage{failPages: map[string]bool{"bad": true}}
+	config := NewConfig()
+	config.StorageRetries = 0
+	w := NewStorageWriter(storage, config)
+	defer w.Close()
+
+	tests := []struct {
+		hash    string
+		wantErr bool
+	}{
+		{"bad", true},
+		// the error is returned once
+		{"good", false},
+	}
+	for _, test := range tests {
+		w.SaveHTML(test.hash, []byte("html"))
+		w.SaveMetadata(DocMetadata{Hash: test.hash})
+		if err := w.FlushMetadata(); (err != nil) != test.wantErr {
+			t.Errorf("%s: FlushMetadata() = %v, want error %v", test.hash, err, test.wantErr)
+		}
+	}
+}
@@ -30,7 +30,7 @@ func worker(id int, jobs <-chan shared.DocMetadata, results chan<- WorkerResult,
 		// read the metadata file
 		metadata := j
 		// index the html file
-		html, err := corpus.GetHTML(context.Background(), metadata.Hash+".html")
+		html, err := corpus.GetDocHTML(context.Background(), metadata)
 		error_check(err)
 		index_file(string(html), []byte(metadata.Hash), postings)
 		results <- WorkerResult{ContentLength: uint32(len(html)), Postings: postings, Hash: metadata.Hash}
@@ -57,6 +57,10 @@ func makeIndex(storage *shared.Storage) {
 	// enqueue the jobs from the metadata directory
 	go func() {
 		for _, doc := range docs {
+			// near duplicates marked by the crawler are only indexed through their original
+			if doc.DuplicateOf != "" {
+				continue
+			}
 			jobs <- doc
 		}
 		close(jobs)
@@ -116,7 +120,7 @@ func makeIndex(storage *shared.Storage) {
 }
 
 func main() {
-	corpus := shared.Corpus(shared.NewMinoMongoCorpus())
+	corpus := shared.NewCorpus()
 
 	storage := shared.NewStorage(corpus)
 	defer storage.DB.Close()
@@ -88,6 +88,13 @@ async def search(request: SearchRequest):
                 "hash": result.Doc.hash,
                 "images": list(result.Doc.images),
                 "first_paragraph": result.Doc.first_paragraph,
+                "short_description": result.Doc.short_description,
+                "categories": list(result.Doc.categories),
+                "sections": [{"level": s.level, "title": s.title, "anchor": s.anchor} for s in result.Doc.sections],
+                "infobox": [{"key": f.key, "value": f.value} for f in result.Doc.infobox],
+                "coordinates": {"lat": result.Doc.coordinates.lat, "lon": result.Doc.coordinates.lon} if result.Doc.HasField("coordinates") else None,
+                "modified_at": result.Doc.modified_at,
+                "disambiguation": result.Doc.disambiguation,
             },
             "score": result.Score,
             "term_count": result.TermCount
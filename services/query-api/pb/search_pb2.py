@@ -24,7 +24,7 @@ _sym_db = _symbol_database.Default()
 
 
 
-DESCRIPTOR = _descriptor_pool.Default().AddSerializedFile(b'\n\x0csearch.proto\x12\x06search\";\n\rSearchRequest\x12\r\n\x05query\x18\x01 \x01(\t\x12\x0c\n\x04page\x18\x02 \x01(\x05\x12\r\n\x05\x63ount\x18\x03 \x01(\x05\"F\n\x0eSearchResponse\x12%\n\x07results\x18\x01 \x03(\x0b\x32\x14.search.SearchResult\x12\r\n\x05total\x18\x02 \x01(\x03\"R\n\x0cSearchResult\x12 \n\x03\x44oc\x18\x01 \x01(\x0b\x32\x13.search.DocMetadata\x12\r\n\x05Score\x18\x02 \x01(\x01\x12\x11\n\tTermCount\x18\x03 \x01(\x05\"o\n\x0b\x44ocMetadata\x12\x0b\n\x03url\x18\x01 \x01(\t\x12\r\n\x05\x64\x65pth\x18\x02 \x01(\x05\x12\r\n\x05title\x18\x03 \x01(\t\x12\x0c\n\x04hash\x18\x04 \x01(\t\x12\x0e\n\x06images\x18\x05 \x03(\t\x12\x17\n\x0f\x66irst_paragraph\x18\x06 \x01(\t2F\n\x06Search\x12<\n\x0bSearchQuery\x12\x15.search.SearchRequest\x1a\x16.search.SearchResponseB$Z\"google-clone/services/search/pb;pbb\x06proto3')
+DESCRIPTOR = _descriptor_pool.Default().AddSerializedFile(b'\n\x0csearch.proto\x12\x06search\";\n\rSearchRequest\x12\r\n\x05query\x18\x01 \x01(\t\x12\x0c\n\x04page\x18\x02 \x01(\x05\x12\r\n\x05\x63ount\x18\x03 \x01(\x05\"F\n\x0eSearchResponse\x12%\n\x07results\x18\x01 \x03(\x0b\x32\x14.search.SearchResult\x12\r\n\x05total\x18\x02 \x01(\x03\"R\n\x0cSearchResult\x12 \n\x03\x44oc\x18\x01 \x01(\x0b\x32\x13.search.DocMetadata\x12\r\n\x05Score\x18\x02 \x01(\x01\x12\x11\n\tTermCount\x18\x03 \x01(\x05\"\xbf\x02\n\x0b\x44ocMetadata\x12\x0b\n\x03url\x18\x01 \x01(\t\x12\r\n\x05\x64\x65pth\x18\x02 \x01(\x05\x12\r\n\x05title\x18\x03 \x01(\t\x12\x0c\n\x04hash\x18\x04 \x01(\t\x12\x0e\n\x06images\x18\x05 \x03(\t\x12\x17\n\x0f\x66irst_paragraph\x18\x06 \x01(\t\x12\x19\n\x11short_description\x18\x07 \x01(\t\x12\x12\n\ncategories\x18\x08 \x03(\t\x12!\n\x08sections\x18\t \x03(\x0b\x32\x0f.search.Section\x12%\n\x07infobox\x18\n \x03(\x0b\x32\x14.search.InfoboxField\x12(\n\x0b\x63oordinates\x18\x0b \x01(\x0b\x32\x13.search.Coordinates\x12\x13\n\x0bmodified_at\x18\x0c \x01(\t\x12\x16\n\x0e\x64isambiguation\x18\r \x01(\x08\"7\n\x07Section\x12\r\n\x05level\x18\x01 \x01(\x05\x12\r\n\x05title\x18\x02 \x01(\t\x12\x0e\n\x06\x61nchor\x18\x03 \x01(\t\"*\n\x0cInfoboxField\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t\"\'\n\x0b\x43oordinates\x12\x0b\n\x03lat\x18\x01 \x01(\x01\x12\x0b\n\x03lon\x18\x02 \x01(\x01\x32\x46\n\x06Search\x12<\n\x0bSearchQuery\x12\x15.search.SearchRequest\x1a\x16.search.SearchResponseB$Z\"google-clone/services/search/pb;pbb\x06proto3')
 
 _globals = globals()
 _builder.BuildMessageAndEnumDescriptors(DESCRIPTOR, _globals)
@@ -38,8 +38,14 @@ if not _descriptor._USE_C_DESCRIPTORS:
   _globals['_SEARCHRESPONSE']._serialized_end=155
   _globals['_SEARCHRESULT']._serialized_start=157
   _globals['_SEARCHRESULT']._serialized_end=239
-  _globals['_DOCMETADATA']._serialized_start=241
-  _globals['_DOCMETADATA']._serialized_end=352
-  _globals['_SEARCH']._serialized_start=354
-  _globals['_SEARCH']._serialized_end=424
+  _globals['_DOCMETADATA']._serialized_start=242
+  _globals['_DOCMETADATA']._serialized_end=561
+  _globals['_SECTION']._serialized_start=563
+  _globals['_SECTION']._serialized_end=618
+  _globals['_INFOBOXFIELD']._serialized_start=620
+  _globals['_INFOBOXFIELD']._serialized_end=662
+  _globals['_COORDINATES']._serialized_start=664
+  _globals['_COORDINATES']._serialized_end=703
+  _globals['_SEARCH']._serialized_start=705
+  _globals['_SEARCH']._serialized_end=775
 # @@protoc_insertion_point(module_scope)
@@ -35,17 +35,57 @@ class SearchResult(_message.Message):
     def __init__(self, Doc: _Optional[_Union[DocMetadata, _Mapping]] = ..., Score: _Optional[float] = ..., TermCount: _Optional[int] = ...) -> None: ...
 
 class DocMetadata(_message.Message):
-    __slots__ = ("url", "depth", "title", "hash", "images", "first_paragraph")
+    __slots__ = ("url", "depth", "title", "hash", "images", "first_paragraph", "short_description", "categories", "sections", "infobox", "coordinates", "modified_at", "disambiguation")
     URL_FIELD_NUMBER: _ClassVar[int]
     DEPTH_FIELD_NUMBER: _ClassVar[int]
     TITLE_FIELD_NUMBER: _ClassVar[int]
     HASH_FIELD_NUMBER: _ClassVar[int]
     IMAGES_FIELD_NUMBER: _ClassVar[int]
     FIRST_PARAGRAPH_FIELD_NUMBER: _ClassVar[int]
+    SHORT_DESCRIPTION_FIELD_NUMBER: _ClassVar[int]
+    CATEGORIES_FIELD_NUMBER: _ClassVar[int]
+    SECTIONS_FIELD_NUMBER: _ClassVar[int]
+    INFOBOX_FIELD_NUMBER: _ClassVar[int]
+    COORDINATES_FIELD_NUMBER: _ClassVar[int]
+    MODIFIED_AT_FIELD_NUMBER: _ClassVar[int]
+    DISAMBIGUATION_FIELD_NUMBER: _ClassVar[int]
     url: str
     depth: int
     title: str
     hash: str
     images: _containers.RepeatedScalarFieldContainer[str]
     first_paragraph: str
-    def __init__(self, url: _Optional[str] = ..., depth: _Optional[int] = ..., title: _Optional[str] = ..., hash: _Optional[str] = ..., images: _Optional[_Iterable[str]] = ..., first_paragraph: _Optional[str] = ...) -> None: ...
+    short_description: str
+    categories: _containers.RepeatedScalarFieldContainer[str]
+    sections: _containers.RepeatedCompositeFieldContainer[Section]
+    infobox: _containers.RepeatedCompositeFieldContainer[InfoboxField]
+    coordinates: Coordinates
+    modified_at: str
+    disambiguation: bool
+    def __init__(self, url: _Optional[str] = ..., depth: _Optional[int] = ..., title: _Optional[str] = ..., hash: _Optional[str] = ..., images: _Optional[_Iterable[str]] = ..., first_paragraph: _Optional[str] = ..., short_description: _Optional[str] = ..., categories: _Optional[_Iterable[str]] = ..., sections: _Optional[_Iterable[_Union[Section, _Mapping]]] = ..., infobox: _Optional[_Iterable[_Union[InfoboxField, _Mapping]]] = ..., coordinates: _Optional[_Union[Coordinates, _Mapping]] = ..., modified_at: _Optional[str] = ..., disambiguation: bool = ...) -> None: ...
+
+class Section(_message.Message):
+    __slots__ = ("level", "title", "anchor")
+    LEVEL_FIELD_NUMBER: _ClassVar[int]
+    TITLE_FIELD_NUMBER: _ClassVar[int]
+    ANCHOR_FIELD_NUMBER: _ClassVar[int]
+    level: int
+    title: str
+    anchor: str
+    def __init__(self, level: _Optional[int] = ..., title: _Optional[str] = ..., anchor: _Optional[str] = ...) -> None: ...
+
+class InfoboxField(_message.Message):
+    __slots__ = ("key", "value")
+    KEY_FIELD_NUMBER: _ClassVar[int]
+    VALUE_FIELD_NUMBER: _ClassVar[int]
+    key: str
+    value: str
+    def __init__(self, key: _Optional[str] = ..., value: _Optional[str] = ...) -> None: ...
+
+class Coordinates(_message.Message):
+    __slots__ = ("lat", "lon")
+    LAT_FIELD_NUMBER: _ClassVar[int]
+    LON_FIELD_NUMBER: _ClassVar[int]
+    lat: float
+    lon: float
+    def __init__(self, lat: _Optional[float] = ..., lon: _Optional[float] = ...) -> None: ...
@@ -7,7 +7,7 @@ import (
 func main() {
 	// t := time.Now()
 	// cache := NewLRUCache[string, []SearchResult](1000)
-	storage := shared.NewStorage(shared.NewMinoMongoCorpus())
+	storage := shared.NewStorage(shared.NewCorpus())
 	// results := search("logic math", storage, 0, 0, &cache)
 	// fmt.Println(len(results))
 	// fmt.Println(time.Since(t))
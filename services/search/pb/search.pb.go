@@ -201,8 +201,16 @@ type DocMetadata struct {
 	Hash           string                 `protobuf:"bytes,4,opt,name=hash,proto3" json:"hash,omitempty"`
 	Images         []string               `protobuf:"bytes,5,rep,name=images,proto3" json:"images,omitempty"`
 	FirstParagraph string                 `protobuf:"bytes,6,opt,name=first_paragraph,json=firstParagraph,proto3" json:"first_paragraph,omitempty"`
-	unknownFields  protoimpl.UnknownFields
-	sizeCache      protoimpl.SizeCache
+	// structure of a Wikipedia article, modified_at is RFC 3339, empty when unknown
+	ShortDescription string          `protobuf:"bytes,7,opt,name=short_description,json=shortDescription,proto3" json:"short_description,omitempty"`
+	Categories       []string        `protobuf:"bytes,8,rep,name=categories,proto3" json:"categories,omitempty"`
+	Sections         []*Section      `protobuf:"bytes,9,rep,name=sections,proto3" json:"sections,omitempty"`
+	Infobox          []*InfoboxField `protobuf:"bytes,10,rep,name=infobox,proto3" json:"infobox,omitempty"`
+	Coordinates      *Coordinates    `protobuf:"bytes,11,opt,name=coordinates,proto3" json:"coordinates,omitempty"`
+	ModifiedAt       string          `protobuf:"bytes,12,opt,name=modified_at,json=modifiedAt,proto3" json:"modified_at,omitempty"`
+	Disambiguation   bool            `protobuf:"varint,13,opt,name=disambiguation,proto3" json:"disambiguation,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *DocMetadata) Reset() {
@@ -277,6 +285,219 @@ func (x *DocMetadata) GetFirstParagraph() string {
 	return ""
 }
 
+func (x *DocMetadata) GetShortDescription() string {
+	if x != nil {
+		return x.ShortDescription
+	}
+	return ""
+}
+
+func (x *DocMetadata) GetCategories() []string {
+	if x != nil {
+		return x.Categories
+	}
+	return nil
+}
+
+func (x *DocMetadata) GetSections() []*Section {
+	if x != nil {
+		return x.Sections
+	}
+	return nil
+}
+
+func (x *DocMetadata) GetInfobox() []*InfoboxField {
+	if x != nil {
+		return x.Infobox
+	}
+	return nil
+}
+
+func (x *DocMetadata) GetCoordinates() *Coordinates {
+	if x != nil {
+		return x.Coordinates
+	}
+	return nil
+}
+
+func (x *DocMetadata) GetModifiedAt() string {
+	if x != nil {
+		return x.ModifiedAt
+	}
+	return ""
+}
+
+func (x *DocMetadata) GetDisambiguation() bool {
+	if x != nil {
+		return x.Disambiguation
+	}
+	return false
+}
+
+type Section struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Level         int32                  `protobuf:"varint,1,opt,name=level,proto3" json:"level,omitempty"`
+	Title         string                 `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Anchor        string                 `protobuf:"bytes,3,opt,name=anchor,proto3" json:"anchor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Section) Reset() {
+	*x = Section{}
+	mi := &file_search_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Section) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Section) ProtoMessage() {}
+
+func (x *Section) ProtoReflect() protoreflect.Message {
+	mi := &file_search_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Section.ProtoReflect.Descriptor instead.
+func (*Section) Descriptor() ([]byte, []int) {
+	return file_search_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Section) GetLevel() int32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+func (x *Section) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Section) GetAnchor() string {
+	if x != nil {
+		return x.Anchor
+	}
+	return ""
+}
+
+type InfoboxField struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InfoboxField) Reset() {
+	*x = InfoboxField{}
+	mi := &file_search_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InfoboxField) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InfoboxField) ProtoMessage() {}
+
+func (x *InfoboxField) ProtoReflect() protoreflect.Message {
+	mi := &file_search_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InfoboxField.ProtoReflect.Descriptor instead.
+func (*InfoboxField) Descriptor() ([]byte, []int) {
+	return file_search_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *InfoboxField) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *InfoboxField) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type Coordinates struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lon           float64                `protobuf:"fixed64,2,opt,name=lon,proto3" json:"lon,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Coordinates) Reset() {
+	*x = Coordinates{}
+	mi := &file_search_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Coordinates) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Coordinates) ProtoMessage() {}
+
+func (x *Coordinates) ProtoReflect() protoreflect.Message {
+	mi := &file_search_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Coordinates.ProtoReflect.Descriptor instead.
+func (*Coordinates) Descriptor() ([]byte, []int) {
+	return file_search_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Coordinates) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *Coordinates) GetLon() float64 {
+	if x != nil {
+		return x.Lon
+	}
+	return 0
+}
+
 var File_search_proto protoreflect.FileDescriptor
 
 const file_search_proto_rawDesc = "" +
@@ -292,14 +513,35 @@ const file_search_proto_rawDesc = "" +
 	"\fSearchResult\x12%\n" +
 	"\x03Doc\x18\x01 \x01(\v2\x13.search.DocMetadataR\x03Doc\x12\x14\n" +
 	"\x05Score\x18\x02 \x01(\x01R\x05Score\x12\x1c\n" +
-	"\tTermCount\x18\x03 \x01(\x05R\tTermCount\"\xa0\x01\n" +
+	"\tTermCount\x18\x03 \x01(\x05R\tTermCount\"\xca\x03\n" +
 	"\vDocMetadata\x12\x10\n" +
 	"\x03url\x18\x01 \x01(\tR\x03url\x12\x14\n" +
 	"\x05depth\x18\x02 \x01(\x05R\x05depth\x12\x14\n" +
 	"\x05title\x18\x03 \x01(\tR\x05title\x12\x12\n" +
 	"\x04hash\x18\x04 \x01(\tR\x04hash\x12\x16\n" +
 	"\x06images\x18\x05 \x03(\tR\x06images\x12'\n" +
-	"\x0ffirst_paragraph\x18\x06 \x01(\tR\x0efirstParagraph2F\n" +
+	"\x0ffirst_paragraph\x18\x06 \x01(\tR\x0efirstParagraph\x12+\n" +
+	"\x11short_description\x18\a \x01(\tR\x10shortDescription\x12\x1e\n" +
+	"\n" +
+	"categories\x18\b \x03(\tR\n" +
+	"categories\x12+\n" +
+	"\bsections\x18\t \x03(\v2\x0f.search.SectionR\bsections\x12.\n" +
+	"\ainfobox\x18\n" +
+	" \x03(\v2\x14.search.InfoboxFieldR\ainfobox\x125\n" +
+	"\vcoordinates\x18\v \x01(\v2\x13.search.CoordinatesR\vcoordinates\x12\x1f\n" +
+	"\vmodified_at\x18\f \x01(\tR\n" +
+	"modifiedAt\x12&\n" +
+	"\x0edisambiguation\x18\r \x01(\bR\x0edisambiguation\"M\n" +
+	"\aSection\x12\x14\n" +
+	"\x05level\x18\x01 \x01(\x05R\x05level\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x16\n" +
+	"\x06anchor\x18\x03 \x01(\tR\x06anchor\"6\n" +
+	"\fInfoboxField\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\"1\n" +
+	"\vCoordinates\x12\x10\n" +
+	"\x03lat\x18\x01 \x01(\x01R\x03lat\x12\x10\n" +
+	"\x03lon\x18\x02 \x01(\x01R\x03lon2F\n" +
 	"\x06Search\x12<\n" +
 	"\vSearchQuery\x12\x15.search.SearchRequest\x1a\x16.search.SearchResponseB$Z\"google-clone/services/search/pb;pbb\x06proto3"
 
@@ -315,23 +557,29 @@ func file_search_proto_rawDescGZIP() []byte {
 	return file_search_proto_rawDescData
 }
 
-var file_search_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_search_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_search_proto_goTypes = []any{
 	(*SearchRequest)(nil),  // 0: search.SearchRequest
 	(*SearchResponse)(nil), // 1: search.SearchResponse
 	(*SearchResult)(nil),   // 2: search.SearchResult
 	(*DocMetadata)(nil),    // 3: search.DocMetadata
+	(*Section)(nil),        // 4: search.Section
+	(*InfoboxField)(nil),   // 5: search.InfoboxField
+	(*Coordinates)(nil),    // 6: search.Coordinates
 }
 var file_search_proto_depIdxs = []int32{
 	2, // 0: search.SearchResponse.results:type_name -> search.SearchResult
 	3, // 1: search.SearchResult.Doc:type_name -> search.DocMetadata
-	0, // 2: search.Search.SearchQuery:input_type -> search.SearchRequest
-	1, // 3: search.Search.SearchQuery:output_type -> search.SearchResponse
-	3, // [3:4] is the sub-list for method output_type
-	2, // [2:3] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	4, // 2: search.DocMetadata.sections:type_name -> search.Section
+	5, // 3: search.DocMetadata.infobox:type_name -> search.InfoboxField
+	6, // 4: search.DocMetadata.coordinates:type_name -> search.Coordinates
+	0, // 5: search.Search.SearchQuery:input_type -> search.SearchRequest
+	1, // 6: search.Search.SearchQuery:output_type -> search.SearchResponse
+	6, // [6:7] is the sub-list for method output_type
+	5, // [5:6] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_search_proto_init() }
@@ -345,7 +593,7 @@ func file_search_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_search_proto_rawDesc), len(file_search_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   4,
+			NumMessages:   7,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"time"
 
 	pb "github.com/dxmv/google_clone/search/pb"
 	shared "github.com/dxmv/google_clone/shared"
@@ -50,6 +51,14 @@ func (s *searchServer) SearchQuery(ctx context.Context, req *pb.SearchRequest) (
 				Title:          docMetadata.Title,
 				Hash:           docMetadata.Hash,
 				Images:         docMetadata.Images,
+				// structured fields of Wikipedia articles
+				ShortDescription: docMetadata.ShortDescription,
+				Categories:       docMetadata.Categories,
+				Sections:         toPbSections(docMetadata.Sections),
+				Infobox:          toPbInfobox(docMetadata.Infobox),
+				Coordinates:      toPbCoordinates(docMetadata.Coordinates),
+				ModifiedAt:       formatTime(docMetadata.ModifiedAt),
+				Disambiguation:   docMetadata.Disambiguation,
 			},
 			Score:     result.Score,
 			TermCount: int32(result.CountTerm),
@@ -59,6 +68,37 @@ func (s *searchServer) SearchQuery(ctx context.Context, req *pb.SearchRequest) (
 	return &pb.SearchResponse{Results: finalResults, Total: totalResults}, nil
 }
 
+func toPbSections(sections []shared.Section) []*pb.Section {
+	result := make([]*pb.Section, len(sections))
+	for i, section := range sections {
+		result[i] = &pb.Section{Level: int32(section.Level), Title: section.Title, Anchor: section.Anchor}
+	}
+	return result
+}
+
+func toPbInfobox(fields []shared.InfoboxField) []*pb.InfoboxField {
+	result := make([]*pb.InfoboxField, len(fields))
+	for i, field := range fields {
+		result[i] = &pb.InfoboxField{Key: field.Key, Value: field.Value}
+	}
+	return result
+}
+
+func toPbCoordinates(coordinates *shared.Coordinates) *pb.Coordinates {
+	if coordinates == nil {
+		return nil
+	}
+	return &pb.Coordinates{Lat: coordinates.Lat, Lon: coordinates.Lon}
+}
+
+// formatTime formats the time as RFC 3339, the zero time is empty
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
 func startServer(storage *shared.Storage) {
 
 	lis, err := net.Listen("tcp", PORT)
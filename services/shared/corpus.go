@@ -1,7 +1,10 @@
 package shared
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -13,6 +16,19 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// NewCorpus reads the crawl from the directory in CORPUS_DIR if it is set, otherwise from minio and mongo.
+// CORPUS_FORMAT=warc reads the WARC files in that directory instead of the filesystem layout.
+func NewCorpus() Corpus {
+	dir := os.Getenv("CORPUS_DIR")
+	if dir == "" {
+		return NewMinoMongoCorpus()
+	}
+	if os.Getenv("CORPUS_FORMAT") == "warc" {
+		return NewWarcCorpus(dir)
+	}
+	return NewFilesystemCorpus(dir)
+}
+
 func NewMinoMongoCorpus() *MinoMongoCorpus {
 	minio, err := newR2Client()
 	if err != nil {
@@ -77,14 +93,59 @@ func newR2Client() (*minio.Client, error) {
 	return client, nil
 }
 
-// get html from minio
+// get html from minio, decoded with the Content-Encoding it was uploaded with
 func (c *MinoMongoCorpus) GetHTML(ctx context.Context, hash string) ([]byte, error) {
 	data, err := c.minioClient.GetObject(ctx, c.bucketName, hash, minio.GetObjectOptions{})
 	if err != nil {
 		return nil, err
 	}
 	defer data.Close()
-	return io.ReadAll(data)
+	body, err := io.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	info, err := data.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return decodeHTML(body, info.Metadata.Get("Content-Encoding"))
+}
+
+// get the html of a document from minio, decoded with the encoding recorded in its metadata
+func (c *MinoMongoCorpus) GetDocHTML(ctx context.Context, doc DocMetadata) ([]byte, error) {
+	data, err := c.minioClient.GetObject(ctx, c.bucketName, doc.Hash+".html", minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	body, err := io.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHTML(body, doc.ContentEncoding)
+}
+
+// decodeHTML decodes html stored with the encoding. Pages stored before the encoding
+// was recorded have none, they are gzip compressed when they start with the gzip magic bytes,
+// which can't start an html page.
+func decodeHTML(body []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		if !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+			return body, nil
+		}
+	case "gzip":
+	case "identity":
+		return body, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
 }
 
 // list metadata from mongo
@@ -111,7 +172,7 @@ func (c *MinoMongoCorpus) GetMetadata(ctx context.Context, docID string) (DocMet
 	coll := c.mongoClient.Database(c.databaseName).Collection(c.collectionName)
 	var doc DocMetadata
 	log.Println("docID", docID)
-	err := coll.FindOne(ctx, bson.D{{"hash", docID}}).Decode(&doc)
+	err := coll.FindOne(ctx, bson.D{{Key: "hash", Value: docID}}).Decode(&doc)
 	if err != nil {
 		return DocMetadata{}, err
 	}
@@ -120,7 +181,7 @@ func (c *MinoMongoCorpus) GetMetadata(ctx context.Context, docID string) (DocMet
 
 func (c *MinoMongoCorpus) GetBatchMetadata(ctx context.Context, docIDs []string) ([]DocMetadata, error) {
 	coll := c.mongoClient.Database(c.databaseName).Collection(c.collectionName)
-	cursor, err := coll.Find(ctx, bson.D{{"hash", bson.D{{"$in", docIDs}}}})
+	cursor, err := coll.Find(ctx, bson.D{{Key: "hash", Value: bson.D{{Key: "$in", Value: docIDs}}}})
 	if err != nil {
 		return nil, err
 	}
@@ -133,3 +194,20 @@ func (c *MinoMongoCorpus) GetBatchMetadata(ctx context.Context, docIDs []string)
 	}
 	return docs, nil
 }
+
+// list the images of all pages from mongo
+func (c *MinoMongoCorpus) ListImages(ctx context.Context) ([]Image, error) {
+	coll := c.mongoClient.Database(c.databaseName).Collection("images")
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var images []Image
+	err = cursor.All(ctx, &images)
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
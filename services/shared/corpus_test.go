@@ -0,0 +1,49 @@
+package shared
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func gzipped(t *testing.T, body string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(body))
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeHTML(t *testing.T) {
+	page := "<html><body>page</body></html>"
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		want     string
+		wantErr  bool
+	}{
+		{"recorded gzip", gzipped(t, page), "gzip", page, false},
+		{"identity", []byte(page), "identity", page, false},
+		{"old record, uncompressed", []byte(page), "", page, false},
+		{"old record, compressed", gzipped(t, page), "", page, false},
+		// the recorded encoding wins over the content
+		{"recorded gzip that isn't", []byte(page), "gzip", "", true},
+		{"unknown encoding", []byte(page), "br", "", true},
+	}
+	for _, test := range tests {
+		got, err := decodeHTML(test.body, test.encoding)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: decoded %q, want %q", test.name, got, test.want)
+		}
+	}
+}
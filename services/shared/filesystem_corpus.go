@@ -0,0 +1,157 @@
+package shared
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NewFilesystemCorpus reads pages and metadata from the data directory of a crawl
+func NewFilesystemCorpus(dir string) *FilesystemCorpus {
+	return &FilesystemCorpus{
+		pagesDir:     filepath.Join(dir, "pages"),
+		metadataFile: filepath.Join(dir, "metadata.jsonl"),
+		imagesFile:   filepath.Join(dir, "images.jsonl"),
+		docs:         make(map[string]DocMetadata),
+	}
+}
+
+// get html from the content addressed pages directory, the hash may end in .html
+func (c *FilesystemCorpus) GetHTML(ctx context.Context, hash string) ([]byte, error) {
+	hash = strings.TrimSuffix(hash, ".html")
+	if len(hash) < 4 {
+		return os.ReadFile(filepath.Join(c.pagesDir, hash+".html"))
+	}
+	return os.ReadFile(filepath.Join(c.pagesDir, hash[:2], hash[2:4], hash+".html"))
+}
+
+// pages are stored as is, the document's encoding is always empty
+func (c *FilesystemCorpus) GetDocHTML(ctx context.Context, doc DocMetadata) ([]byte, error) {
+	return c.GetHTML(ctx, doc.Hash)
+}
+
+func (c *FilesystemCorpus) ListMetadata(ctx context.Context) ([]DocMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]DocMetadata, 0, len(c.docs))
+	for _, doc := range c.docs {
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (c *FilesystemCorpus) GetMetadata(ctx context.Context, docID string) (DocMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.load()
+	if err != nil {
+		return DocMetadata{}, err
+	}
+	doc, ok := c.docs[docID]
+	if !ok {
+		return DocMetadata{}, os.ErrNotExist
+	}
+	return doc, nil
+}
+
+func (c *FilesystemCorpus) GetBatchMetadata(ctx context.Context, docIDs []string) ([]DocMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]DocMetadata, 0, len(docIDs))
+	for _, docID := range docIDs {
+		if doc, ok := c.docs[docID]; ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func (c *FilesystemCorpus) ListImages(ctx context.Context) ([]Image, error) {
+	return readImages(c.imagesFile)
+}
+
+// readImages reads an images file of the crawler, every line has the images of a page
+// and a later line for the same page replaces an earlier one
+func readImages(path string) ([]Image, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Image{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pages := make(map[string][]Image)
+	order := []string{}
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		var page struct {
+			PageHash string
+			Images   []Image
+		}
+		err = decoder.Decode(&page)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := pages[page.PageHash]; !ok {
+			order = append(order, page.PageHash)
+		}
+		pages[page.PageHash] = page.Images
+	}
+
+	images := []Image{}
+	for _, hash := range order {
+		images = append(images, pages[hash]...)
+	}
+	return images, nil
+}
+
+// load reads the metadata file again if it changed since the last read,
+// a later line for the same hash replaces an earlier one
+func (c *FilesystemCorpus) load() error {
+	info, err := os.Stat(c.metadataFile)
+	if errors.Is(err, os.ErrNotExist) {
+		c.docs = make(map[string]DocMetadata)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return nil
+	}
+
+	file, err := os.Open(c.metadataFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	docs := make(map[string]DocMetadata)
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		var doc DocMetadata
+		err = decoder.Decode(&doc)
+		if err != nil {
+			return err
+		}
+		docs[doc.Hash] = doc
+	}
+	c.docs = docs
+	c.modTime = info.ModTime()
+	c.size = info.Size()
+	return nil
+}
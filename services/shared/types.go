@@ -2,6 +2,7 @@ package shared
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
@@ -19,6 +20,56 @@ type DocMetadata struct {
 	ContentLength  int
 	CrawledAt      time.Time
 	FirstParagraph string
+	// near duplicate cluster of the page, DuplicateOf is the hash of the page it copies
+	ClusterID   string
+	DuplicateOf string
+	// link counts recorded by the crawler, the edges are in the link store
+	OutLinks int
+	InLinks  int
+	// structure of a Wikipedia article, ModifiedAt is the last edit of the article
+	ShortDescription string
+	Sections         []Section
+	Infobox          []InfoboxField
+	Categories       []string
+	Coordinates      *Coordinates
+	ModifiedAt       time.Time
+	Disambiguation   bool
+	// encoding of the stored html, GetHTML returns it decoded
+	ContentEncoding string
+}
+
+// Section is a heading of an article, Level is 2 for <h2> up to 6
+type Section struct {
+	Level  int
+	Title  string
+	Anchor string
+}
+
+// InfoboxField is a row of the infobox of an article
+type InfoboxField struct {
+	Key   string
+	Value string
+}
+
+// Coordinates of the place an article is about
+type Coordinates struct {
+	Lat float64
+	Lon float64
+}
+
+// Image is an image of a stored page with the text describing it, saved by the crawler for image search
+type Image struct {
+	URL     string
+	Alt     string
+	Caption string
+	// 0 when the page doesn't give the size
+	Width  int
+	Height int
+	// heading of the section the image is in
+	Section   string
+	PageURL   string
+	PageHash  string
+	CrawledAt time.Time
 }
 
 // Posting represents a document's relevance for a term
@@ -36,9 +87,12 @@ type Stats struct {
 
 type Corpus interface {
 	GetHTML(ctx context.Context, hash string) ([]byte, error)
+	// GetDocHTML returns the html of the document decoded with the encoding in its metadata
+	GetDocHTML(ctx context.Context, doc DocMetadata) ([]byte, error)
 	ListMetadata(ctx context.Context) ([]DocMetadata, error)
 	GetMetadata(ctx context.Context, docID string) (DocMetadata, error)
 	GetBatchMetadata(ctx context.Context, docIDs []string) ([]DocMetadata, error)
+	ListImages(ctx context.Context) ([]Image, error)
 }
 
 type MinoMongoCorpus struct {
@@ -49,6 +103,45 @@ type MinoMongoCorpus struct {
 	databaseName   string
 }
 
+/*
+FilesystemCorpus reads the layout written by the crawler's filesystem storage.
+
+docs: metadata by hash, reloaded when the metadata file changes
+modTime, size: the metadata file the docs were loaded from
+*/
+type FilesystemCorpus struct {
+	mu           sync.Mutex
+	pagesDir     string
+	metadataFile string
+	imagesFile   string
+	docs         map[string]DocMetadata
+	modTime      time.Time
+	size         int64
+}
+
+/*
+WarcCorpus reads pages and metadata from WARC files, the files are indexed once on first use.
+
+locations: where the response record of every page is, by hash
+docs: metadata by hash, from the metadata records or the response records
+deleted: documents removed by a later metadata record, only used while indexing
+*/
+type WarcCorpus struct {
+	mu        sync.Mutex
+	dir       string
+	locations map[string]warcLocation
+	docs      map[string]DocMetadata
+	deleted   map[string]bool
+	loaded    bool
+}
+
+// warcLocation is where a record starts, doc is the metadata derived from the record
+type warcLocation struct {
+	path   string
+	offset int64
+	doc    DocMetadata
+}
+
 // storage is a wrapper around the badger db and the corpus
 type Storage struct {
 	DB     *badger.DB
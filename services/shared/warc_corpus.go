@@ -0,0 +1,356 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadata records written by the crawler for deleted documents carry this header
+const WARC_DELETED_HEADER = "X-Crawler-Deleted"
+
+// warcRecord is a record read from a WARC file
+type warcRecord struct {
+	header textproto.MIMEHeader
+	block  []byte
+}
+
+// NewWarcCorpus reads pages and metadata from the WARC files (.warc or .warc.gz) in dir and its subdirectories.
+// Response records of other tools are indexed too, pages without a metadata record get their url and date from the record.
+func NewWarcCorpus(dir string) *WarcCorpus {
+	return &WarcCorpus{
+		dir:       dir,
+		locations: make(map[string]warcLocation),
+		docs:      make(map[string]DocMetadata),
+	}
+}
+
+// get html from the response record of the page
+func (c *WarcCorpus) GetHTML(ctx context.Context, hash string) ([]byte, error) {
+	hash = strings.TrimSuffix(hash, ".html")
+	c.mu.Lock()
+	err := c.load()
+	location, ok := c.locations[hash]
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no response record for %s: %w", hash, os.ErrNotExist)
+	}
+
+	file, err := os.Open(location.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	_, err = file.Seek(location.offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader = file
+	if strings.HasSuffix(location.path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	record, _, err := readWarcRecord(bufio.NewReader(reader))
+	if err != nil {
+		return nil, err
+	}
+	_, body, err := warcResponseBody(record)
+	return body, err
+}
+
+// response records hold the page as fetched, the document's encoding is always empty
+func (c *WarcCorpus) GetDocHTML(ctx context.Context, doc DocMetadata) ([]byte, error) {
+	return c.GetHTML(ctx, doc.Hash)
+}
+
+func (c *WarcCorpus) ListMetadata(ctx context.Context) ([]DocMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]DocMetadata, 0, len(c.docs))
+	for _, doc := range c.docs {
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (c *WarcCorpus) GetMetadata(ctx context.Context, docID string) (DocMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.load()
+	if err != nil {
+		return DocMetadata{}, err
+	}
+	doc, ok := c.docs[docID]
+	if !ok {
+		return DocMetadata{}, os.ErrNotExist
+	}
+	return doc, nil
+}
+
+func (c *WarcCorpus) GetBatchMetadata(ctx context.Context, docIDs []string) ([]DocMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]DocMetadata, 0, len(docIDs))
+	for _, docID := range docIDs {
+		if doc, ok := c.docs[docID]; ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// the crawler writes the images of a WARC crawl next to the warc directory, in the filesystem format
+func (c *WarcCorpus) ListImages(ctx context.Context) ([]Image, error) {
+	return readImages(filepath.Join(c.dir, "images.jsonl"))
+}
+
+// load indexes the WARC files the first time the corpus is used
+func (c *WarcCorpus) load() error {
+	if c.loaded {
+		return nil
+	}
+	paths := []string{}
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && (strings.HasSuffix(path, ".warc") || strings.HasSuffix(path, ".warc.gz")) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// file names of a crawl are numbered, so later files win
+	for _, path := range paths {
+		err = c.index(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+
+	// pages archived by other tools have no metadata record
+	for hash, location := range c.locations {
+		if _, ok := c.docs[hash]; !ok && !c.deleted[hash] {
+			c.docs[hash] = location.doc
+		}
+	}
+	c.deleted = nil
+	c.loaded = true
+	return nil
+}
+
+// index records where the response records of a file are and reads its metadata records
+func (c *WarcCorpus) index(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if c.deleted == nil {
+		c.deleted = make(map[string]bool)
+	}
+
+	if !strings.HasSuffix(path, ".gz") {
+		reader := bufio.NewReader(file)
+		offset := int64(0)
+		for {
+			record, n, err := readWarcRecord(reader)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			c.add(record, warcLocation{path: path, offset: offset + n.skipped})
+			offset += n.total
+		}
+	}
+
+	// every gzip member holds one record, remember where the members start
+	counter := &countingReader{r: bufio.NewReader(file)}
+	gz, err := gzip.NewReader(counter)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	offset := int64(0)
+	for {
+		gz.Multistream(false)
+		reader := bufio.NewReader(gz)
+		for {
+			record, _, err := readWarcRecord(reader)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			c.add(record, warcLocation{path: path, offset: offset})
+		}
+		offset = counter.n
+		err = gz.Reset(counter)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// add indexes a response record or applies a metadata record
+func (c *WarcCorpus) add(record *warcRecord, location warcLocation) {
+	switch record.header.Get("WARC-Type") {
+	case "response":
+		response, body, err := warcResponseBody(record)
+		if err != nil || response.StatusCode != http.StatusOK || !strings.Contains(response.Header.Get("Content-Type"), "html") {
+			return
+		}
+		hash := sha256.Sum256(body)
+		hashString := hex.EncodeToString(hash[:])
+		crawledAt, _ := time.Parse(time.RFC3339, record.header.Get("WARC-Date"))
+		location.doc = DocMetadata{
+			URL:           record.header.Get("WARC-Target-URI"),
+			Hash:          hashString,
+			ContentLength: len(body),
+			CrawledAt:     crawledAt,
+			Images:        []string{},
+		}
+		c.locations[hashString] = location
+	case "metadata":
+		if !strings.HasPrefix(record.header.Get("Content-Type"), "application/json") {
+			return
+		}
+		var doc DocMetadata
+		if json.Unmarshal(record.block, &doc) != nil || doc.Hash == "" {
+			return
+		}
+		if record.header.Get(WARC_DELETED_HEADER) != "" {
+			delete(c.docs, doc.Hash)
+			c.deleted[doc.Hash] = true
+			return
+		}
+		delete(c.deleted, doc.Hash)
+		c.docs[doc.Hash] = doc
+	}
+}
+
+// warcResponseBody parses the http response in the block of a response record
+func warcResponseBody(record *warcRecord) (*http.Response, []byte, error) {
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(record.block)), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	return response, body, err
+}
+
+// warcRead are the bytes a record took in the file, skipped counts the blank lines before it
+type warcRead struct {
+	skipped int64
+	total   int64
+}
+
+// readWarcRecord reads the next record, it returns io.EOF when there are no more records
+func readWarcRecord(r *bufio.Reader) (*warcRecord, warcRead, error) {
+	read := warcRead{}
+	var line string
+	var err error
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, read, err
+		}
+		if strings.TrimRight(line, "\r\n") != "" {
+			break
+		}
+		read.skipped += int64(len(line))
+	}
+	read.total = read.skipped + int64(len(line))
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, read, fmt.Errorf("invalid WARC record start %q", strings.TrimSpace(line))
+	}
+
+	header := textproto.MIMEHeader{}
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, read, err
+		}
+		read.total += int64(len(line))
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, read, fmt.Errorf("invalid WARC header %q", line)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, read, fmt.Errorf("invalid WARC Content-Length: %w", err)
+	}
+	block := make([]byte, length)
+	_, err = io.ReadFull(r, block)
+	if err != nil {
+		return nil, read, err
+	}
+	read.total += length
+	return &warcRecord{header: header, block: block}, read, nil
+}
+
+// countingReader counts the bytes gzip consumed, it reads byte by byte so gzip doesn't read ahead
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}